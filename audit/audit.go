@@ -1,11 +1,25 @@
 package lxAudit
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
-// IAudit, interface for audit repositories
+// IAudit, interface for audit repositories, the channels of Log and LogEvent
+// deliver the write error or nil, the Sync variants wait for the write
 type IAudit interface {
 	SetupAudit() error
-	Log(user, message, data interface{}) chan bool
+	Log(user, message, data interface{}) chan error
+	LogSync(user, message, data interface{}) error
+	LogEvent(event *Event) chan error
+	LogEventSync(event *Event) error
+
+	// Variants bound to the deadline and cancellation of ctx
+	SetupAuditContext(ctx context.Context) error
+	LogContext(ctx context.Context, user, message, data interface{}) chan error
+	LogSyncContext(ctx context.Context, user, message, data interface{}) error
+	LogEventContext(ctx context.Context, event *Event) chan error
+	LogEventSyncContext(ctx context.Context, event *Event) error
 }
 
 
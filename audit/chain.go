@@ -0,0 +1,90 @@
+package lxAudit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	ReasonMissing   = "missing"
+	ReasonDuplicate = "duplicate"
+	ReasonPrevHash  = "prev_hash mismatch"
+	ReasonHash      = "hash mismatch"
+)
+
+// ChainError, first broken or missing link found by Verify
+type ChainError struct {
+	Service string
+	Seq     int64
+	Reason  string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain of %s broken at %d: %s", e.Service, e.Seq, e.Reason)
+}
+
+// ChainHash, return hash of event linked to prevHash, HMAC-SHA256 with key or SHA256 without,
+// the hash field of event is not part of the hash
+func ChainHash(prevHash string, event *Event, key []byte) (string, error) {
+	e := *event
+	e.Hash = ""
+
+	data, err := canonical(&e)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	}
+	h.Write([]byte(prevHash))
+	h.Write([]byte{0})
+	h.Write(data)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonical, json of v as stored in mongo, with sorted keys and utc times,
+// so hashes of written and read entries are equal
+func canonical(v interface{}) ([]byte, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(normalize(doc))
+}
+
+// normalize, convert bson values to stable json values
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case bson.M:
+		m := make(map[string]interface{}, len(x))
+		for k, val := range x {
+			m[k] = normalize(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(x))
+		for i, val := range x {
+			l[i] = normalize(val)
+		}
+		return l
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	}
+
+	return v
+}
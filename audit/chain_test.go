@@ -0,0 +1,42 @@
+package lxAudit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainHash(t *testing.T) {
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	event := lxAudit.NewEvent("user.login").WithData(bson.M{"b": 1, "a": bson.M{"y": 2, "x": 3}})
+	event.TimeStamp = ts
+
+	hash, err := lxAudit.ChainHash("", event, nil)
+	assert.NoError(t, err)
+	assert.Len(t, hash, 64)
+
+	t.Run("stable for stored entries", func(t *testing.T) {
+		stored := *event
+		stored.TimeStamp = ts.In(time.FixedZone("CET", 3600))
+		stored.Hash = hash
+		h, err := lxAudit.ChainHash("", &stored, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, hash, h)
+	})
+
+	t.Run("depends on previous hash, content and key", func(t *testing.T) {
+		h, _ := lxAudit.ChainHash("abc", event, nil)
+		assert.NotEqual(t, hash, h)
+
+		changed := *event
+		changed.Message = "changed"
+		h, _ = lxAudit.ChainHash("", &changed, nil)
+		assert.NotEqual(t, hash, h)
+
+		h, _ = lxAudit.ChainHash("", event, []byte("secret"))
+		assert.NotEqual(t, hash, h)
+	})
+}
@@ -0,0 +1,281 @@
+package lxAudit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+// FieldChange, before and after value of a changed field, nil when missing
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+// ChangeEntry, audit data for the change of one document
+type ChangeEntry struct {
+	Collection string        `json:"collection" bson:"collection"`
+	Op         lxDb.Op       `json:"op" bson:"op"`
+	Id         interface{}   `json:"id" bson:"id"`
+	Changes    []FieldChange `json:"changes" bson:"changes"`
+}
+
+// AppliedError, the change was written but its audit entries failed
+type AppliedError struct {
+	Err error
+}
+
+func (e *AppliedError) Error() string {
+	return "change applied, audit failed: " + e.Err.Error()
+}
+
+// AuditDb, repository decorator logging field diffs of updates and deletes,
+// the user of the entries is the actor of lxDb.WithActor, writes are pinned
+// to the documents of the diff, a failed audit after the write returns *AppliedError
+type AuditDb struct {
+	lxDb.IBaseDb
+	Audit      IAudit
+	Collection string
+
+	// IgnoredFields, fields and their sub fields left out of the diff
+	IgnoredFields []string
+}
+
+// NewAuditDb, return audit decorator for db
+func NewAuditDb(db lxDb.IBaseDb, audit IAudit, collection string) *AuditDb {
+	return &AuditDb{IBaseDb: db, Audit: audit, Collection: collection}
+}
+
+// Update, update the first document matching the query
+func (db *AuditDb) Update(query interface{}, data interface{}) error {
+	return db.UpdateContext(context.Background(), query, data)
+}
+
+// UpdateContext, update the first document matching the query
+func (db *AuditDb) UpdateContext(ctx context.Context, query interface{}, data interface{}) error {
+	before, pinned, err := db.snapshot(ctx, query, 1)
+	if err != nil {
+		return err
+	}
+	if len(before) == 0 {
+		return lxDb.ErrNotFound
+	}
+	if err := db.IBaseDb.UpdateContext(ctx, pinned, data); err != nil {
+		return err
+	}
+
+	return db.logChanges(ctx, lxDb.OpUpdate, before)
+}
+
+// UpdateAll, update all documents matching the query
+func (db *AuditDb) UpdateAll(query interface{}, data interface{}) (lxDb.ChangeInfo, error) {
+	return db.UpdateAllContext(context.Background(), query, data)
+}
+
+// UpdateAllContext, update all documents matching the query
+func (db *AuditDb) UpdateAllContext(ctx context.Context, query interface{}, data interface{}) (lxDb.ChangeInfo, error) {
+	before, pinned, err := db.snapshot(ctx, query, 0)
+	if err != nil || len(before) == 0 {
+		return lxDb.ChangeInfo{}, err
+	}
+
+	info, err := db.IBaseDb.UpdateAllContext(ctx, pinned, data)
+	if err != nil {
+		return info, err
+	}
+
+	return info, db.logChanges(ctx, lxDb.OpUpdateAll, before)
+}
+
+// Delete, remove the first document matching the query
+func (db *AuditDb) Delete(query interface{}) error {
+	return db.DeleteContext(context.Background(), query)
+}
+
+// DeleteContext, remove the first document matching the query
+func (db *AuditDb) DeleteContext(ctx context.Context, query interface{}) error {
+	before, pinned, err := db.snapshot(ctx, query, 1)
+	if err != nil {
+		return err
+	}
+	if len(before) == 0 {
+		return lxDb.ErrNotFound
+	}
+	if err := db.IBaseDb.DeleteContext(ctx, pinned); err != nil {
+		return err
+	}
+
+	return db.logChanges(ctx, lxDb.OpDelete, before)
+}
+
+// DeleteAll, remove all documents matching the query
+func (db *AuditDb) DeleteAll(query interface{}) (lxDb.ChangeInfo, error) {
+	return db.DeleteAllContext(context.Background(), query)
+}
+
+// DeleteAllContext, remove all documents matching the query
+func (db *AuditDb) DeleteAllContext(ctx context.Context, query interface{}) (lxDb.ChangeInfo, error) {
+	before, pinned, err := db.snapshot(ctx, query, 0)
+	if err != nil || len(before) == 0 {
+		return lxDb.ChangeInfo{}, err
+	}
+
+	info, err := db.IBaseDb.DeleteAllContext(ctx, pinned)
+	if err != nil {
+		return info, err
+	}
+
+	return info, db.logChanges(ctx, lxDb.OpDeleteAll, before)
+}
+
+// snapshot, load matching documents before the change, limit 0 for all, returns query
+// pinned to their ids, documents matching only after the snapshot aren't changed
+func (db *AuditDb) snapshot(ctx context.Context, query interface{}, limit int) ([]bson.M, interface{}, error) {
+	var docs []bson.M
+	if _, err := db.IBaseDb.GetAllContext(ctx, query, &docs, &lxDb.Options{Limit: limit}); err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc["_id"]
+	}
+	var cond bson.M
+	if limit == 1 && len(ids) == 1 {
+		cond = bson.M{"_id": ids[0]}
+	} else {
+		cond = bson.M{"_id": bson.M{"$in": ids}}
+	}
+
+	if query == nil {
+		return docs, cond, nil
+	}
+
+	return docs, bson.M{"$and": []interface{}{query, cond}}, nil
+}
+
+// logChanges, reload the documents and log the diff of each changed document,
+// removed documents are diffed against nil, errors are *AppliedError
+func (db *AuditDb) logChanges(ctx context.Context, op lxDb.Op, before []bson.M) error {
+	if len(before) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(before))
+	for i, doc := range before {
+		ids[i] = doc["_id"]
+	}
+
+	var after []bson.M
+	if _, err := db.IBaseDb.GetAllContext(lxDb.WithDeleted(ctx), bson.M{"_id": bson.M{"$in": ids}}, &after, nil); err != nil {
+		return &AppliedError{Err: err}
+	}
+
+	current := map[string]bson.M{}
+	for _, doc := range after {
+		current[idKey(doc["_id"])] = doc
+	}
+
+	actor := lxDb.ActorFromContext(ctx)
+	message := fmt.Sprintf("%s %s", op, db.Collection)
+	for _, doc := range before {
+		changes := Diff(doc, current[idKey(doc["_id"])], db.IgnoredFields)
+		if len(changes) == 0 {
+			continue
+		}
+
+		event := NewEvent(fmt.Sprintf("%s.%s", db.Collection, op)).
+			WithActor(actor, "").
+			WithResource(db.Collection, idString(doc["_id"])).
+			WithMessage(message).
+			WithData(&ChangeEntry{
+				Collection: db.Collection,
+				Op:         op,
+				Id:         doc["_id"],
+				Changes:    changes,
+			}).
+			Succeeded()
+
+		if err := db.Audit.LogEventSyncContext(ctx, event); err != nil {
+			return &AppliedError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Diff, return field level changes between two documents sorted by field,
+// nested documents are compared by dotted path, arrays as a whole
+func Diff(before, after bson.M, ignored []string) []FieldChange {
+	b, a := map[string]interface{}{}, map[string]interface{}{}
+	flatten("", before, b)
+	flatten("", after, a)
+
+	var changes []FieldChange
+	for field, v := range b {
+		if w, ok := a[field]; !ok || !reflect.DeepEqual(v, w) {
+			changes = append(changes, FieldChange{Field: field, Before: v, After: a[field]})
+		}
+	}
+	for field, w := range a {
+		if _, ok := b[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, After: w})
+		}
+	}
+
+	kept := changes[:0]
+	for _, c := range changes {
+		if !isIgnored(c.Field, ignored) {
+			kept = append(kept, c)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].Field < kept[j].Field })
+
+	return kept
+}
+
+// flatten, collect values of doc by dotted path
+func flatten(prefix string, doc bson.M, out map[string]interface{}) {
+	for k, v := range doc {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if sub, ok := v.(bson.M); ok && len(sub) > 0 {
+			flatten(path, sub, out)
+			continue
+		}
+		out[path] = v
+	}
+}
+
+// isIgnored, check if field or one of its parents is ignored
+func isIgnored(field string, ignored []string) bool {
+	for _, i := range ignored {
+		if field == i || strings.HasPrefix(field, i+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// idString, resource id of document id
+func idString(id interface{}) string {
+	if oid, ok := id.(bson.ObjectId); ok {
+		return oid.Hex()
+	}
+
+	return toString(id)
+}
+
+// idKey, comparable key for document ids
+func idKey(id interface{}) string {
+	return fmt.Sprintf("%#v", id)
+}
@@ -0,0 +1,208 @@
+package lxAudit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/mocks"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAuditDb(t *testing.T) (*lxAudit.AuditDb, *lxDb.MemoryDb) {
+	store := lxDb.NewMemoryStore()
+	users := lxDb.NewMemoryDb(store, "test_db", "users")
+	entries := lxDb.NewMemoryDb(store, "test_db", "audit")
+
+	for _, u := range []bson.M{
+		{"_id": 1, "name": "otto", "address": bson.M{"city": "Leipzig", "zip": "04109"}, "updated_at": 1},
+		{"_id": 2, "name": "emil", "address": bson.M{"city": "Berlin"}, "updated_at": 1},
+	} {
+		if err := users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := lxAudit.NewAuditDb(users, lxAuditRepos.NewAuditMongo(entries, "test_service", "localhost"), "users")
+	db.IgnoredFields = []string{"updated_at"}
+
+	return db, entries
+}
+
+func TestAuditDb(t *testing.T) {
+	ctx := lxDb.WithActor(context.Background(), "admin")
+
+	t.Run("Update logs field diff", func(t *testing.T) {
+		db, entries := setupAuditDb(t)
+		assert.NoError(t, db.UpdateContext(ctx, bson.M{"_id": 1}, bson.M{
+			"$set":   bson.M{"address.city": "Dresden", "updated_at": 2, "email": "otto@example.com"},
+			"$unset": bson.M{"address.zip": ""},
+		}))
+
+		var entry struct {
+			User    string
+			Message string
+			Data    lxAudit.ChangeEntry
+		}
+		assert.NoError(t, entries.GetOne(nil, &entry))
+		assert.Equal(t, "admin", entry.User)
+		assert.Equal(t, "update users", entry.Message)
+		assert.Equal(t, lxDb.OpUpdate, entry.Data.Op)
+		assert.Equal(t, 1, entry.Data.Id)
+
+		var event lxAudit.Event
+		assert.NoError(t, entries.GetOne(nil, &event))
+		assert.Equal(t, "users.update", event.Action)
+		assert.Equal(t, "users", event.ResourceType)
+		assert.Equal(t, "1", event.ResourceId)
+		assert.Equal(t, lxAudit.OutcomeSuccess, event.Outcome)
+		assert.Equal(t, []lxAudit.FieldChange{
+			{Field: "address.city", Before: "Leipzig", After: "Dresden"},
+			{Field: "address.zip", Before: "04109"},
+			{Field: "email", After: "otto@example.com"},
+		}, entry.Data.Changes)
+	})
+
+	t.Run("Unchanged and ignored fields are not logged", func(t *testing.T) {
+		db, entries := setupAuditDb(t)
+		assert.NoError(t, db.Update(bson.M{"_id": 1}, bson.M{"$set": bson.M{"name": "otto", "updated_at": 2}}))
+
+		n, err := entries.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("UpdateAll logs one entry per document", func(t *testing.T) {
+		db, entries := setupAuditDb(t)
+		info, err := db.UpdateAll(nil, bson.M{"$set": bson.M{"active": true}})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, info.Updated)
+
+		n, err := entries.GetCount(bson.M{"message": "update_all users"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("Delete logs removed fields", func(t *testing.T) {
+		db, entries := setupAuditDb(t)
+		assert.NoError(t, db.DeleteContext(ctx, bson.M{"_id": 2}))
+
+		var entry struct{ Data lxAudit.ChangeEntry }
+		assert.NoError(t, entries.GetOne(bson.M{"message": "delete users"}, &entry))
+		assert.Equal(t, []lxAudit.FieldChange{
+			{Field: "_id", Before: 2},
+			{Field: "address.city", Before: "Berlin"},
+			{Field: "name", Before: "emil"},
+		}, entry.Data.Changes)
+	})
+
+	t.Run("Missing document returns not found", func(t *testing.T) {
+		db, _ := setupAuditDb(t)
+		assert.Equal(t, lxDb.ErrNotFound, db.Update(bson.M{"_id": 3}, bson.M{"$set": bson.M{"name": "x"}}))
+	})
+}
+
+func TestDiff(t *testing.T) {
+	before := bson.M{"a": 1, "b": bson.M{"c": []interface{}{1, 2}}, "secret": bson.M{"hash": "x"}}
+	after := bson.M{"a": 1, "b": bson.M{"c": []interface{}{1, 3}}, "secret": bson.M{"hash": "y"}}
+
+	assert.Equal(t, []lxAudit.FieldChange{
+		{Field: "b.c", Before: []interface{}{1, 2}, After: []interface{}{1, 3}},
+	}, lxAudit.Diff(before, after, []string{"secret"}))
+	assert.Empty(t, lxAudit.Diff(before, before, nil))
+}
+
+// racingDb, runs race once after the first read, like a concurrent writer
+type racingDb struct {
+	lxDb.IBaseDb
+	race func()
+}
+
+func (db *racingDb) GetAllContext(ctx context.Context, query interface{}, result interface{}, opts *lxDb.Options) (int, error) {
+	n, err := db.IBaseDb.GetAllContext(ctx, query, result, opts)
+	if race := db.race; race != nil {
+		db.race = nil
+		race()
+	}
+	return n, err
+}
+
+func TestAuditDb_Pinned(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("UpdateAll changes only the audited documents", func(t *testing.T) {
+		audited, entries := setupAuditDb(t)
+		users := audited.IBaseDb
+		audited.IBaseDb = &racingDb{IBaseDb: users, race: func() {
+			assert.NoError(t, users.Create(bson.M{"_id": 3, "name": "karl"}))
+		}}
+
+		info, err := audited.UpdateAllContext(ctx, nil, bson.M{"$set": bson.M{"active": true}})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, info.Updated)
+
+		n, err := users.GetCount(bson.M{"active": true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		n, err = entries.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("Update doesn't switch to another document", func(t *testing.T) {
+		audited, entries := setupAuditDb(t)
+		users := audited.IBaseDb
+		audited.IBaseDb = &racingDb{IBaseDb: users, race: func() {
+			assert.NoError(t, users.Update(bson.M{"_id": 1}, bson.M{"$set": bson.M{"name": "karl"}}))
+		}}
+
+		query := bson.M{"name": bson.M{"$in": []string{"otto", "emil"}}}
+		assert.Equal(t, lxDb.ErrNotFound, audited.UpdateContext(ctx, query, bson.M{"$set": bson.M{"active": true}}))
+
+		n, err := users.GetCount(bson.M{"active": true})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		n, err = entries.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("DeleteAll removes only the audited documents", func(t *testing.T) {
+		audited, _ := setupAuditDb(t)
+		users := audited.IBaseDb
+		audited.IBaseDb = &racingDb{IBaseDb: users, race: func() {
+			assert.NoError(t, users.Create(bson.M{"_id": 3, "name": "karl"}))
+		}}
+
+		info, err := audited.DeleteAllContext(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, info.Removed)
+
+		n, err := users.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("Failed audit after the write is an AppliedError", func(t *testing.T) {
+		mockCtrl := gomock.NewController(t)
+		defer mockCtrl.Finish()
+
+		audited, _ := setupAuditDb(t)
+		audit := lxAuditMocks.NewMockIAudit(mockCtrl)
+		audit.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).Return(errors.New("down"))
+		audited.Audit = audit
+
+		err := audited.Update(bson.M{"_id": 1}, bson.M{"$set": bson.M{"name": "karl"}})
+		assert.Equal(t, &lxAudit.AppliedError{Err: errors.New("down")}, err)
+		assert.EqualError(t, err, "change applied, audit failed: down")
+
+		n, err := audited.IBaseDb.GetCount(bson.M{"name": "karl"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
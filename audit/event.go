@@ -0,0 +1,220 @@
+package lxAudit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+
+	HeaderRequestId = "X-Request-ID"
+)
+
+// Event, structured audit entry, the bson names of the AuditModel
+// fields are kept so old and new entries can be queried together
+type Event struct {
+	TimeStamp    time.Time   `json:"timestamp" bson:"timestamp"`
+	ServiceName  string      `json:"service_name" bson:"servicename"`
+	ServiceHost  string      `json:"service_host" bson:"servicehost"`
+	ActorId      string      `json:"actor_id" bson:"user"`
+	ActorType    string      `json:"actor_type,omitempty" bson:"actor_type,omitempty"`
+	Action       string      `json:"action,omitempty" bson:"action,omitempty"`
+	ResourceType string      `json:"resource_type,omitempty" bson:"resource_type,omitempty"`
+	ResourceId   string      `json:"resource_id,omitempty" bson:"resource_id,omitempty"`
+	Outcome      string      `json:"outcome,omitempty" bson:"outcome,omitempty"`
+	RequestId    string      `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	IP           string      `json:"ip,omitempty" bson:"ip,omitempty"`
+	UserAgent    string      `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+	Message      string      `json:"msg" bson:"message"`
+	Data         interface{} `json:"data" bson:"data"`
+
+	// Hash chain per service, set by repositories with chain enabled
+	Seq      int64  `json:"seq,omitempty" bson:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty" bson:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty" bson:"hash,omitempty"`
+}
+
+// NewEvent, return event builder for action,
+// e.g. NewEvent("user.update").WithActor(id, "user").WithResource("user", uid).Succeeded()
+func NewEvent(action string) *Event {
+	return &Event{Action: action}
+}
+
+// TrustedProxies, networks of proxies allowed to pass the client ip with X-Forwarded-For
+// or X-Real-IP, see ParseTrustedProxies
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies, return TrustedProxies of cidrs or single ips
+func ParseTrustedProxies(proxies ...string) (TrustedProxies, error) {
+	var nets TrustedProxies
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", p)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			p = fmt.Sprintf("%s/%d", p, bits)
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// LegacyData, data of a Log entry whose user or message isn't a string,
+// the original values are kept next to the data
+type LegacyData struct {
+	User    interface{} `json:"user,omitempty" bson:"user,omitempty"`
+	Message interface{} `json:"message,omitempty" bson:"message,omitempty"`
+	Data    interface{} `json:"data" bson:"data"`
+}
+
+// LegacyEvent, convert the arguments of Log, ActorId and Message hold user and message
+// formatted with fmt.Sprint, other values than strings are kept as LegacyData
+func LegacyEvent(user, message, data interface{}) *Event {
+	e := &Event{ActorId: toString(user), Message: toString(message), Data: data}
+
+	_, userOk := user.(string)
+	_, messageOk := message.(string)
+	userOk = userOk || user == nil
+	messageOk = messageOk || message == nil
+	if !userOk || !messageOk {
+		legacy := &LegacyData{Data: data}
+		if !userOk {
+			legacy.User = user
+		}
+		if !messageOk {
+			legacy.Message = message
+		}
+		e.Data = legacy
+	}
+
+	return e
+}
+
+// WithActor, set id and type of the acting user or system
+func (e *Event) WithActor(id, actorType string) *Event {
+	e.ActorId, e.ActorType = id, actorType
+	return e
+}
+
+// WithResource, set type and id of the affected resource
+func (e *Event) WithResource(resourceType, id string) *Event {
+	e.ResourceType, e.ResourceId = resourceType, id
+	return e
+}
+
+// WithOutcome, set outcome
+func (e *Event) WithOutcome(outcome string) *Event {
+	e.Outcome = outcome
+	return e
+}
+
+// Succeeded, set outcome success
+func (e *Event) Succeeded() *Event {
+	return e.WithOutcome(OutcomeSuccess)
+}
+
+// Failed, set outcome failure
+func (e *Event) Failed() *Event {
+	return e.WithOutcome(OutcomeFailure)
+}
+
+// WithRequest, set request id, client ip and user agent of http request,
+// the client ip is the remote address
+func (e *Event) WithRequest(r *http.Request) *Event {
+	return e.WithProxiedRequest(r, nil)
+}
+
+// WithProxiedRequest, set request id, client ip and user agent of http request,
+// forwarded addresses count behind proxies
+func (e *Event) WithProxiedRequest(r *http.Request, proxies TrustedProxies) *Event {
+	e.RequestId = r.Header.Get(HeaderRequestId)
+	e.IP = proxies.ClientIP(r)
+	e.UserAgent = r.UserAgent()
+	return e
+}
+
+// WithRequestId, set request id
+func (e *Event) WithRequestId(id string) *Event {
+	e.RequestId = id
+	return e
+}
+
+// WithMessage, set human readable message
+func (e *Event) WithMessage(message string) *Event {
+	e.Message = message
+	return e
+}
+
+// WithData, set free-form data
+func (e *Event) WithData(data interface{}) *Event {
+	e.Data = data
+	return e
+}
+
+// ClientIP, remote address of request, forwarded addresses only count behind the proxies,
+// the nearest untrusted forwarded address is the client
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !p.contains(ip) {
+		return ip
+	}
+
+	if fwd := r.Header["X-Forwarded-For"]; len(fwd) > 0 {
+		hops := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			if hop := strings.TrimSpace(hops[i]); hop != "" && (i == 0 || !p.contains(hop)) {
+				return hop
+			}
+		}
+	}
+	if real := r.Header.Get("X-Real-IP"); real != "" {
+		return real
+	}
+
+	return ip
+}
+
+// contains, ip is in one of the proxy networks
+func (p TrustedProxies) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range p {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// toString, format value, empty for nil
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,70 @@
+package lxAudit_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEvent(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/users/42", nil)
+	r.RemoteAddr = "10.0.0.1:51234"
+	r.Header.Set("User-Agent", "test-agent")
+	r.Header.Set(lxAudit.HeaderRequestId, "req-1")
+
+	event := lxAudit.NewEvent("user.update").
+		WithActor("7", "user").
+		WithResource("user", "42").
+		WithRequest(r).
+		WithMessage("changed email").
+		WithData(map[string]string{"email": "new"}).
+		Failed()
+
+	assert.Equal(t, &lxAudit.Event{
+		ActorId:      "7",
+		ActorType:    "user",
+		Action:       "user.update",
+		ResourceType: "user",
+		ResourceId:   "42",
+		Outcome:      lxAudit.OutcomeFailure,
+		RequestId:    "req-1",
+		IP:           "10.0.0.1",
+		UserAgent:    "test-agent",
+		Message:      "changed email",
+		Data:         map[string]string{"email": "new"},
+	}, event)
+
+	// Forwarded addresses only count behind trusted proxies
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 192.168.1.5, 10.0.0.2")
+	assert.Equal(t, "10.0.0.1", lxAudit.NewEvent("x").WithRequest(r).IP)
+
+	proxies, err := lxAudit.ParseTrustedProxies("10.0.0.0/8", "192.168.1.5")
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.9", lxAudit.NewEvent("x").WithProxiedRequest(r, proxies).IP)
+	assert.Equal(t, "10.0.0.1", lxAudit.NewEvent("x").WithRequest(r).IP)
+
+	r.Header.Del("X-Forwarded-For")
+	r.Header.Set("X-Real-IP", "203.0.113.7")
+	assert.Equal(t, "203.0.113.7", proxies.ClientIP(r))
+
+	_, err = lxAudit.ParseTrustedProxies("not-an-ip")
+	assert.Error(t, err)
+}
+
+func TestLegacyEvent(t *testing.T) {
+	event := lxAudit.LegacyEvent("test_user", "a message", 42)
+	assert.Equal(t, &lxAudit.Event{ActorId: "test_user", Message: "a message", Data: 42}, event)
+
+	// Values other than strings are kept
+	user := struct{ Id int }{7}
+	event = lxAudit.LegacyEvent(user, 12, "data")
+	assert.Equal(t, "{7}", event.ActorId)
+	assert.Equal(t, "12", event.Message)
+	assert.Equal(t, &lxAudit.LegacyData{User: user, Message: 12, Data: "data"}, event.Data)
+
+	event = lxAudit.LegacyEvent(nil, "a message", nil)
+	assert.Equal(t, "", event.ActorId)
+	assert.Nil(t, event.Data)
+}
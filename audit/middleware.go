@@ -0,0 +1,208 @@
+package lxAudit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+const (
+	// ActionRequest, action of request entries
+	ActionRequest = "http.request"
+
+	// DefaultMaxBodyBytes, size limit of recorded bodies
+	DefaultMaxBodyBytes = 64 << 10
+)
+
+// DefaultSkipPaths, health checks and static assets
+var DefaultSkipPaths = []string{
+	"/health", "/healthz", "/ready", "/readyz", "/live", "/livez", "/metrics", "/favicon.ico",
+	"/static/*", "/assets/*", "/public/*",
+}
+
+// staticExts, extensions of static assets
+var staticExts = map[string]bool{
+	".css": true, ".js": true, ".map": true, ".html": true, ".ico": true, ".png": true, ".jpg": true,
+	".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".woff": true, ".woff2": true, ".ttf": true,
+}
+
+// Skipper, skip auditing of a request when true
+type Skipper func(c echo.Context) bool
+
+// ActorExtractor, return id and type of the actor of a request, empty id for anonymous requests
+type ActorExtractor func(c echo.Context) (id, actorType string)
+
+// MiddlewareConfig, configuration of Middleware
+type MiddlewareConfig struct {
+	Skipper      Skipper        // Skip requests, default DefaultSkipper
+	Actor        ActorExtractor // Actor of a request, default ContextActor
+	BodyRoutes   []string       // Routes recording the json request body, "POST /users" or "/users" for all methods
+	Redactor     *Redactor      // Redacts recorded bodies, default DefaultKeyRules
+	MaxBodyBytes int64          // Bodies above are not recorded, default DefaultMaxBodyBytes
+	Proxies      TrustedProxies // Proxies passing the client ip, default none, see ParseTrustedProxies
+}
+
+// Middleware, echo middleware logging an event per request with method, route, status, latency,
+// actor, request id and the redacted body of BodyRoutes, backend errors are logged and don't fail requests,
+// the client ip is the remote address unless it is one of the trusted Proxies
+func Middleware(audit IAudit, config MiddlewareConfig) echo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = DefaultSkipper
+	}
+	if config.Actor == nil {
+		config.Actor = ContextActor
+	}
+	if config.Redactor == nil {
+		config.Redactor, _ = NewRedactor(DefaultKeyRules...)
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	// Own copy, later changes of the caller don't reach running requests
+	config.Proxies = append(TrustedProxies(nil), config.Proxies...)
+
+	bodyRoutes := map[string]bool{}
+	for _, route := range config.BodyRoutes {
+		bodyRoutes[route] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			start := time.Now()
+
+			var body []byte
+			if bodyRoutes[req.Method+" "+c.Path()] || bodyRoutes[c.Path()] {
+				body = peekBody(req, config.MaxBodyBytes)
+			}
+
+			err := next(c)
+			status := responseStatus(c, err)
+
+			data := map[string]interface{}{
+				"method":     req.Method,
+				"route":      c.Path(),
+				"path":       req.URL.Path,
+				"status":     status,
+				"latency_ms": float64(time.Since(start)) / float64(time.Millisecond),
+			}
+			if body != nil {
+				var v interface{}
+				if json.Unmarshal(body, &v) == nil {
+					if v, rerr := config.Redactor.Redact(v); rerr == nil {
+						data["body"] = v
+					}
+				}
+			}
+
+			event := NewEvent(ActionRequest).
+				WithResource("route", req.Method+" "+c.Path()).
+				WithProxiedRequest(req, config.Proxies).
+				WithMessage(req.Method + " " + req.URL.Path).
+				WithData(data)
+			event.TimeStamp = start
+			event.WithActor(config.Actor(c))
+			if event.RequestId == "" {
+				event.WithRequestId(c.Response().Header().Get(HeaderRequestId))
+			}
+			if status < http.StatusBadRequest {
+				event.Succeeded()
+			} else {
+				event.Failed()
+			}
+
+			go func(done chan error) {
+				if err := <-done; err != nil {
+					log.Printf("audit can't log request, error: %v\n", err)
+				}
+			}(audit.LogEvent(event))
+
+			return err
+		}
+	}
+}
+
+// DefaultSkipper, skip DefaultSkipPaths and static assets
+func DefaultSkipper(c echo.Context) bool {
+	return SkipPaths(DefaultSkipPaths...)(c) || SkipStatic(c)
+}
+
+// SkipPaths, skip requests with a path matching one of the patterns, see path.Match
+func SkipPaths(patterns ...string) Skipper {
+	return func(c echo.Context) bool {
+		p := c.Request().URL.Path
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			// Pattern dir/* covers all levels below dir
+			if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(p, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SkipStatic, skip GET and HEAD requests for files with extensions of static assets
+func SkipStatic(c echo.Context) bool {
+	req := c.Request()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return staticExts[strings.ToLower(path.Ext(req.URL.Path))]
+}
+
+// ContextActor, actor of lxDb.WithActor in the request context with type user
+func ContextActor(c echo.Context) (string, string) {
+	if actor := lxDb.ActorFromContext(c.Request().Context()); actor != "" {
+		return actor, "user"
+	}
+	return "", ""
+}
+
+// peekBody, read body up to limit and restore it for the handler, nil for larger bodies
+func peekBody(req *http.Request, limit int64) []byte {
+	if req.Body == nil {
+		return nil
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+	if err != nil || int64(len(buf)) > limit {
+		return nil
+	}
+
+	return buf
+}
+
+// responseStatus, status of the written response or the status echo writes for err
+func responseStatus(c echo.Context, err error) int {
+	res := c.Response()
+	if err == nil || res.Committed {
+		return res.Status
+	}
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+
+	return http.StatusInternalServerError
+}
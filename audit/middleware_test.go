@@ -0,0 +1,166 @@
+package lxAudit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/labstack/echo"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/mocks"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mock := lxAuditMocks.NewMockIAudit(mockCtrl)
+	var logged []*lxAudit.Event
+	mock.EXPECT().LogEvent(gomock.Any()).AnyTimes().DoAndReturn(func(e *lxAudit.Event) chan error {
+		logged = append(logged, e)
+		done := make(chan error, 1)
+		done <- errors.New("backend down")
+		close(done)
+		return done
+	})
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user := c.Request().Header.Get("X-User"); user != "" {
+				c.SetRequest(c.Request().WithContext(lxDb.WithActor(c.Request().Context(), user)))
+			}
+			return next(c)
+		}
+	})
+	e.Use(lxAudit.Middleware(mock, lxAudit.MiddlewareConfig{BodyRoutes: []string{"POST /users"}}))
+
+	var handlerBody string
+	e.POST("/users", func(c echo.Context) error {
+		var v map[string]interface{}
+		if err := c.Bind(&v); err != nil {
+			return err
+		}
+		handlerBody = v["password"].(string)
+		return c.NoContent(http.StatusCreated)
+	})
+	e.PUT("/users/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden)
+	})
+	e.GET("/health", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+	e.GET("/static/js/app.js", func(c echo.Context) error {
+		return c.String(http.StatusOK, "")
+	})
+
+	serve := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-User", "7")
+		req.Header.Set(lxAudit.HeaderRequestId, "req-1")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("records request with redacted body", func(t *testing.T) {
+		logged = nil
+		serve(echo.POST, "/users", `{"name":"alice","password":"geheim"}`)
+		assert.Equal(t, "geheim", handlerBody)
+
+		assert.Len(t, logged, 1)
+		event := logged[0]
+		assert.Equal(t, lxAudit.ActionRequest, event.Action)
+		assert.Equal(t, "7", event.ActorId)
+		assert.Equal(t, "user", event.ActorType)
+		assert.Equal(t, "req-1", event.RequestId)
+		assert.Equal(t, "POST /users", event.ResourceId)
+		assert.Equal(t, lxAudit.OutcomeSuccess, event.Outcome)
+		assert.False(t, event.TimeStamp.IsZero())
+
+		data := event.Data.(map[string]interface{})
+		assert.Equal(t, http.StatusCreated, data["status"])
+		assert.Equal(t, "/users", data["route"])
+		assert.Contains(t, data, "latency_ms")
+		body := data["body"].(bson.M)
+		assert.Equal(t, "alice", body["name"])
+		assert.Equal(t, lxAudit.DefaultMask, body["password"])
+	})
+
+	t.Run("records failed request without body", func(t *testing.T) {
+		logged = nil
+		serve(echo.PUT, "/users/42", `{"password":"geheim"}`)
+
+		assert.Len(t, logged, 1)
+		data := logged[0].Data.(map[string]interface{})
+		assert.Equal(t, http.StatusForbidden, data["status"])
+		assert.Equal(t, "/users/:id", data["route"])
+		assert.Equal(t, "/users/42", data["path"])
+		assert.NotContains(t, data, "body")
+		assert.Equal(t, lxAudit.OutcomeFailure, logged[0].Outcome)
+	})
+
+	t.Run("skips health checks and static assets", func(t *testing.T) {
+		logged = nil
+		serve(echo.GET, "/health", "")
+		serve(echo.GET, "/static/js/app.js", "")
+		assert.Empty(t, logged)
+	})
+}
+
+func TestMiddleware_Proxies(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mock := lxAuditMocks.NewMockIAudit(mockCtrl)
+	var logged []*lxAudit.Event
+	mock.EXPECT().LogEvent(gomock.Any()).AnyTimes().DoAndReturn(func(e *lxAudit.Event) chan error {
+		logged = append(logged, e)
+		done := make(chan error)
+		close(done)
+		return done
+	})
+
+	proxies, err := lxAudit.ParseTrustedProxies("10.0.0.0/8")
+	assert.NoError(t, err)
+	serve := func(config lxAudit.MiddlewareConfig) string {
+		logged = nil
+		e := echo.New()
+		e.Use(lxAudit.Middleware(mock, config))
+		e.GET("/users", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(echo.GET, "/users", nil)
+		req.RemoteAddr = "10.0.0.1:51234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Len(t, logged, 1)
+		return logged[0].IP
+	}
+
+	t.Run("each middleware resolves by its own proxies", func(t *testing.T) {
+		config := lxAudit.MiddlewareConfig{Proxies: proxies}
+		assert.Equal(t, "203.0.113.9", serve(config))
+		assert.Equal(t, "10.0.0.1", serve(lxAudit.MiddlewareConfig{}))
+	})
+}
+
+func TestSkipPaths(t *testing.T) {
+	skip := lxAudit.SkipPaths("/internal/*", "/ping")
+	for target, want := range map[string]bool{
+		"/ping":           true,
+		"/internal/a":     true,
+		"/internal/a/b/c": true,
+		"/users":          false,
+	} {
+		c := echo.New().NewContext(httptest.NewRequest(echo.GET, target, nil), httptest.NewRecorder())
+		assert.Equal(t, want, skip(c), target)
+	}
+}
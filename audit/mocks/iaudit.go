@@ -5,7 +5,9 @@
 package lxAuditMocks
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
+	lxAudit "github.com/litixsoft/lx-golib/audit"
 	reflect "reflect"
 )
 
@@ -33,9 +35,9 @@ func (m *MockIAudit) EXPECT() *MockIAuditMockRecorder {
 }
 
 // Log mocks base method
-func (m *MockIAudit) Log(arg0, arg1, arg2 interface{}) chan bool {
+func (m *MockIAudit) Log(arg0, arg1, arg2 interface{}) chan error {
 	ret := m.ctrl.Call(m, "Log", arg0, arg1, arg2)
-	ret0, _ := ret[0].(chan bool)
+	ret0, _ := ret[0].(chan error)
 	return ret0
 }
 
@@ -44,6 +46,90 @@ func (mr *MockIAuditMockRecorder) Log(arg0, arg1, arg2 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Log", reflect.TypeOf((*MockIAudit)(nil).Log), arg0, arg1, arg2)
 }
 
+// LogContext mocks base method
+func (m *MockIAudit) LogContext(arg0 context.Context, arg1, arg2, arg3 interface{}) chan error {
+	ret := m.ctrl.Call(m, "LogContext", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(chan error)
+	return ret0
+}
+
+// LogContext indicates an expected call of LogContext
+func (mr *MockIAuditMockRecorder) LogContext(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogContext", reflect.TypeOf((*MockIAudit)(nil).LogContext), arg0, arg1, arg2, arg3)
+}
+
+// LogEvent mocks base method
+func (m *MockIAudit) LogEvent(arg0 *lxAudit.Event) chan error {
+	ret := m.ctrl.Call(m, "LogEvent", arg0)
+	ret0, _ := ret[0].(chan error)
+	return ret0
+}
+
+// LogEvent indicates an expected call of LogEvent
+func (mr *MockIAuditMockRecorder) LogEvent(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogEvent", reflect.TypeOf((*MockIAudit)(nil).LogEvent), arg0)
+}
+
+// LogEventContext mocks base method
+func (m *MockIAudit) LogEventContext(arg0 context.Context, arg1 *lxAudit.Event) chan error {
+	ret := m.ctrl.Call(m, "LogEventContext", arg0, arg1)
+	ret0, _ := ret[0].(chan error)
+	return ret0
+}
+
+// LogEventContext indicates an expected call of LogEventContext
+func (mr *MockIAuditMockRecorder) LogEventContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogEventContext", reflect.TypeOf((*MockIAudit)(nil).LogEventContext), arg0, arg1)
+}
+
+// LogEventSync mocks base method
+func (m *MockIAudit) LogEventSync(arg0 *lxAudit.Event) error {
+	ret := m.ctrl.Call(m, "LogEventSync", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogEventSync indicates an expected call of LogEventSync
+func (mr *MockIAuditMockRecorder) LogEventSync(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogEventSync", reflect.TypeOf((*MockIAudit)(nil).LogEventSync), arg0)
+}
+
+// LogEventSyncContext mocks base method
+func (m *MockIAudit) LogEventSyncContext(arg0 context.Context, arg1 *lxAudit.Event) error {
+	ret := m.ctrl.Call(m, "LogEventSyncContext", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogEventSyncContext indicates an expected call of LogEventSyncContext
+func (mr *MockIAuditMockRecorder) LogEventSyncContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogEventSyncContext", reflect.TypeOf((*MockIAudit)(nil).LogEventSyncContext), arg0, arg1)
+}
+
+// LogSync mocks base method
+func (m *MockIAudit) LogSync(arg0, arg1, arg2 interface{}) error {
+	ret := m.ctrl.Call(m, "LogSync", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogSync indicates an expected call of LogSync
+func (mr *MockIAuditMockRecorder) LogSync(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogSync", reflect.TypeOf((*MockIAudit)(nil).LogSync), arg0, arg1, arg2)
+}
+
+// LogSyncContext mocks base method
+func (m *MockIAudit) LogSyncContext(arg0 context.Context, arg1, arg2, arg3 interface{}) error {
+	ret := m.ctrl.Call(m, "LogSyncContext", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// LogSyncContext indicates an expected call of LogSyncContext
+func (mr *MockIAuditMockRecorder) LogSyncContext(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LogSyncContext", reflect.TypeOf((*MockIAudit)(nil).LogSyncContext), arg0, arg1, arg2, arg3)
+}
+
 // SetupAudit mocks base method
 func (m *MockIAudit) SetupAudit() error {
 	ret := m.ctrl.Call(m, "SetupAudit")
@@ -55,3 +141,15 @@ func (m *MockIAudit) SetupAudit() error {
 func (mr *MockIAuditMockRecorder) SetupAudit() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupAudit", reflect.TypeOf((*MockIAudit)(nil).SetupAudit))
 }
+
+// SetupAuditContext mocks base method
+func (m *MockIAudit) SetupAuditContext(arg0 context.Context) error {
+	ret := m.ctrl.Call(m, "SetupAuditContext", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetupAuditContext indicates an expected call of SetupAuditContext
+func (mr *MockIAuditMockRecorder) SetupAuditContext(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetupAuditContext", reflect.TypeOf((*MockIAudit)(nil).SetupAuditContext), arg0)
+}
@@ -0,0 +1,159 @@
+package lxAudit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// CSVHeader, columns of the csv export, data is json encoded
+var CSVHeader = []string{
+	"timestamp", "service_name", "service_host", "actor_id", "actor_type", "action",
+	"resource_type", "resource_id", "outcome", "request_id", "ip", "user_agent", "msg", "data",
+}
+
+// IAuditReader, interface for audit repositories which can be queried
+type IAuditReader interface {
+	Find(ctx context.Context, filter *Filter, opts *lxDb.Options) ([]Event, int, error)
+	FindPage(ctx context.Context, filter *Filter, opts *lxDb.Options) ([]Event, *lxDb.Page, error)
+	Export(ctx context.Context, w io.Writer, filter *Filter, format string) (int, error)
+	Verify(ctx context.Context, service string, from, to int64) (int, error)
+}
+
+// IAuditRepo, interface for audit repositories with query support
+type IAuditRepo interface {
+	IAudit
+	IAuditReader
+}
+
+// Filter, audit query filter, empty fields match all entries
+type Filter struct {
+	From         time.Time // Including
+	To           time.Time // Excluding
+	ActorId      string
+	Action       string
+	ResourceType string
+	ResourceId   string
+	ServiceName  string
+	Outcome      string
+}
+
+// Query, return mongo query for filter
+func (f *Filter) Query() bson.M {
+	q := bson.M{}
+	if f == nil {
+		return q
+	}
+
+	ts := bson.M{}
+	if !f.From.IsZero() {
+		ts["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		ts["$lt"] = f.To
+	}
+	if len(ts) > 0 {
+		q["timestamp"] = ts
+	}
+
+	for field, v := range map[string]string{
+		"user":          f.ActorId,
+		"action":        f.Action,
+		"resource_type": f.ResourceType,
+		"resource_id":   f.ResourceId,
+		"servicename":   f.ServiceName,
+		"outcome":       f.Outcome,
+	} {
+		if v != "" {
+			q[field] = v
+		}
+	}
+
+	return q
+}
+
+// Exporter, writes events in an export format
+type Exporter interface {
+	Write(event *Event) error
+	Flush() error
+}
+
+// NewExporter, return exporter for FormatJSONL or FormatCSV,
+// the csv exporter writes the header first
+func NewExporter(w io.Writer, format string) (Exporter, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlExporter{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		e := &csvExporter{w: csv.NewWriter(w)}
+		return e, e.w.Write(CSVHeader)
+	}
+
+	return nil, fmt.Errorf("unknown export format: %s", format)
+}
+
+// jsonlExporter, one json object per line
+type jsonlExporter struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlExporter) Write(event *Event) error {
+	return e.enc.Encode(event)
+}
+
+func (e *jsonlExporter) Flush() error {
+	return nil
+}
+
+// csvExporter, one row per event in CSVHeader order
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func (e *csvExporter) Write(event *Event) error {
+	data := ""
+	if event.Data != nil {
+		raw, err := json.Marshal(event.Data)
+		if err != nil {
+			return err
+		}
+		data = string(raw)
+	}
+
+	row := []string{
+		event.TimeStamp.UTC().Format(time.RFC3339Nano), event.ServiceName, event.ServiceHost,
+		event.ActorId, event.ActorType, event.Action, event.ResourceType, event.ResourceId,
+		event.Outcome, event.RequestId, event.IP, event.UserAgent, event.Message, data,
+	}
+	for i := range row {
+		row[i] = csvCell(row[i])
+	}
+
+	return e.w.Write(row)
+}
+
+// csvCell, prefix cells starting like a formula with a quote,
+// spreadsheets opening the export must not run them
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,491 @@
+package lxAudit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Strategy, how a redacted value is replaced
+type Strategy string
+
+const (
+	StrategyMask Strategy = "mask" // replace with Redactor.Mask
+	StrategyHash Strategy = "hash" // replace with HMAC-SHA256 of the value, needs Redactor.HashKey
+	StrategyDrop Strategy = "drop" // remove key, array elements become null
+
+	// DefaultMask, replacement of StrategyMask
+	DefaultMask = "***"
+
+	// TagRedact, struct tag with the strategy of a field, e.g. `audit:"mask"`
+	TagRedact = "audit"
+
+	// maxRedactDepth, depth limit for struct tags of cyclic data
+	maxRedactDepth = 32
+)
+
+// ErrHashKey, StrategyHash is used without Redactor.HashKey, plain hashes of
+// low entropy values like IBANs are brute-forceable
+var ErrHashKey = errors.New("redact strategy hash needs a hash key")
+
+// DefaultKeyRules, mask common secrets by key name
+var DefaultKeyRules = []Rule{
+	{Key: "*password*", Strategy: StrategyMask},
+	{Key: "*passwd*", Strategy: StrategyMask},
+	{Key: "*secret*", Strategy: StrategyMask},
+	{Key: "*token*", Strategy: StrategyMask},
+	{Key: "*api_key*", Strategy: StrategyMask},
+	{Key: "*apikey*", Strategy: StrategyMask},
+	{Key: "authorization", Strategy: StrategyMask},
+	{Key: "cookie", Strategy: StrategyMask},
+	{Key: "iban", Strategy: StrategyMask},
+}
+
+// Rule, redact values by path or key name, set one of Path or Key
+type Rule struct {
+	Path     string // JSONPath-like path, e.g. $.user.password, $.cards[*].iban, $.items[0] or $..token
+	Key      string // Key name pattern at any depth, case-insensitive, e.g. *token*
+	Strategy Strategy
+}
+
+// Redactor, redact event data before backends persist it
+type Redactor struct {
+	HashKey []byte // HMAC key for StrategyHash, required by rules and tags using it
+	Mask    string // Replacement for StrategyMask, default DefaultMask
+
+	rules []compiled
+}
+
+// compiled, parsed rule
+type compiled struct {
+	segs     []segment
+	strategy Strategy
+}
+
+// segment, step of a path
+type segment struct {
+	name    string
+	any     bool // * or [*], all keys or elements
+	index   int  // [n], -1 for names
+	deep    bool // .., this node or any descendant
+	pattern bool // name is a case-insensitive glob
+}
+
+// NewRedactor, return redactor for rules, struct tags are always applied
+func NewRedactor(rules ...Rule) (*Redactor, error) {
+	r := &Redactor{Mask: DefaultMask}
+	for _, rule := range rules {
+		if err := checkStrategy(rule.Strategy); err != nil {
+			return nil, err
+		}
+
+		var segs []segment
+		switch {
+		case rule.Path != "" && rule.Key == "":
+			var err error
+			if segs, err = parsePath(rule.Path); err != nil {
+				return nil, err
+			}
+		case rule.Key != "" && rule.Path == "":
+			if _, err := path.Match(rule.Key, ""); err != nil {
+				return nil, fmt.Errorf("invalid redact key %q: %v", rule.Key, err)
+			}
+			segs = []segment{{name: strings.ToLower(rule.Key), index: -1, deep: true, pattern: true}}
+		default:
+			return nil, fmt.Errorf("redact rule needs a path or a key")
+		}
+
+		r.rules = append(r.rules, compiled{segs: segs, strategy: rule.Strategy})
+	}
+
+	return r, nil
+}
+
+// Redact, return data as document with struct tags and rules applied, data isn't modified
+func (r *Redactor) Redact(data interface{}) (interface{}, error) {
+	if r == nil || data == nil {
+		return data, nil
+	}
+
+	// Struct tags are lost by the conversion, collect their paths first
+	var rules []compiled
+	tagRules(reflect.ValueOf(data), nil, 0, func(segs []segment, s Strategy) {
+		rules = append(rules, compiled{segs: segs, strategy: s})
+	})
+	for _, rule := range rules {
+		if err := checkStrategy(rule.strategy); err != nil {
+			return nil, err
+		}
+	}
+	rules = append(rules, r.rules...)
+	if len(r.HashKey) == 0 {
+		for _, rule := range rules {
+			if rule.strategy == StrategyHash {
+				return nil, ErrHashKey
+			}
+		}
+	}
+
+	// Copy as stored by mongo
+	raw, err := bson.Marshal(bson.M{"data": data})
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	v := doc["data"]
+
+	for _, rule := range rules {
+		strategy := rule.strategy
+		walk(v, rule.segs, func(container interface{}, key interface{}) {
+			r.replace(container, key, strategy)
+		})
+	}
+
+	return v, nil
+}
+
+// RedactEvent, return copy of event with redacted data, the data of LegacyData
+// is redacted against its own root like the data of other events
+func (r *Redactor) RedactEvent(event *Event) (*Event, error) {
+	e := *event
+	if legacy, ok := event.Data.(*LegacyData); ok {
+		data, err := r.Redact(legacy.Data)
+		if err != nil {
+			return nil, err
+		}
+		l := *legacy
+		l.Data = data
+		e.Data = &l
+
+		return &e, nil
+	}
+
+	data, err := r.Redact(event.Data)
+	if err != nil {
+		return nil, err
+	}
+	e.Data = data
+
+	return &e, nil
+}
+
+// replace, redact value at key of container
+func (r *Redactor) replace(container interface{}, key interface{}, strategy Strategy) {
+	switch c := container.(type) {
+	case bson.M:
+		k := key.(string)
+		switch strategy {
+		case StrategyDrop:
+			delete(c, k)
+		case StrategyHash:
+			c[k] = r.hash(c[k])
+		default:
+			c[k] = r.mask()
+		}
+	case []interface{}:
+		i := key.(int)
+		switch strategy {
+		case StrategyDrop:
+			c[i] = nil
+		case StrategyHash:
+			c[i] = r.hash(c[i])
+		default:
+			c[i] = r.mask()
+		}
+	}
+}
+
+// mask, replacement for StrategyMask
+func (r *Redactor) mask() string {
+	if r.Mask == "" {
+		return DefaultMask
+	}
+	return r.Mask
+}
+
+// hash, HMAC-SHA256 of value, strings are hashed as is, other values as json
+func (r *Redactor) hash(v interface{}) string {
+	var b []byte
+	if s, ok := v.(string); ok {
+		b = []byte(s)
+	} else {
+		b, _ = json.Marshal(v)
+	}
+
+	h := hmac.New(sha256.New, r.HashKey)
+	h.Write(b)
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// walk, call fn with container and key of every value matching segs
+func walk(node interface{}, segs []segment, fn func(container interface{}, key interface{})) {
+	if len(segs) == 0 {
+		return
+	}
+	seg := segs[0]
+
+	switch n := node.(type) {
+	case bson.M:
+		for k, v := range n {
+			if seg.matchKey(k) {
+				if len(segs) == 1 {
+					fn(n, k)
+				} else {
+					walk(v, segs[1:], fn)
+				}
+			}
+			if seg.deep {
+				walk(v, segs, fn)
+			}
+		}
+	case []interface{}:
+		for i, v := range n {
+			if seg.any || seg.index == i {
+				if len(segs) == 1 {
+					fn(n, i)
+				} else {
+					walk(v, segs[1:], fn)
+				}
+			}
+			if seg.deep {
+				walk(v, segs, fn)
+			}
+		}
+	}
+}
+
+// matchKey, segment matches key of a document
+func (s segment) matchKey(key string) bool {
+	switch {
+	case s.any:
+		return true
+	case s.index >= 0:
+		return false
+	case s.pattern:
+		ok, _ := path.Match(s.name, strings.ToLower(key))
+		return ok
+	}
+	return s.name == key
+}
+
+// parsePath, parse JSONPath-like path, $ is optional
+func parsePath(p string) ([]segment, error) {
+	invalid := fmt.Errorf("invalid redact path %q", p)
+
+	rest := strings.TrimPrefix(p, "$")
+	var segs []segment
+	for rest != "" {
+		seg := segment{index: -1}
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			seg.deep = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			rest = rest[1:]
+		}
+		if rest == "" {
+			return nil, invalid
+		}
+
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			in := rest[1:end]
+			rest = rest[end+1:]
+
+			switch {
+			case in == "*":
+				seg.any = true
+			case len(in) >= 2 && (in[0] == '\'' || in[0] == '"') && in[len(in)-1] == in[0]:
+				seg.name = in[1 : len(in)-1]
+			default:
+				i, err := strconv.Atoi(in)
+				if err != nil || i < 0 {
+					return nil, invalid
+				}
+				seg.index = i
+			}
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, invalid
+			}
+			seg.name = rest[:end]
+			seg.any = seg.name == "*"
+			rest = rest[end:]
+		}
+
+		segs = append(segs, seg)
+	}
+	if len(segs) == 0 {
+		return nil, invalid
+	}
+
+	return segs, nil
+}
+
+// tagRules, collect paths of fields with redact tags, names follow the bson encoding
+func tagRules(v reflect.Value, prefix []segment, depth int, add func(segs []segment, s Strategy)) {
+	if depth > maxRedactDepth || !v.IsValid() {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			tagRules(v.Elem(), prefix, depth+1, add)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			name, inline := bsonName(f)
+			if name == "-" {
+				continue
+			}
+			segs := prefix
+			if !inline {
+				segs = appendSegment(prefix, segment{name: name, index: -1})
+				if s := f.Tag.Get(TagRedact); s != "" {
+					add(segs, Strategy(s))
+					continue
+				}
+			}
+			tagRules(v.Field(i), segs, depth+1, add)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		segs := appendSegment(prefix, segment{any: true, index: -1})
+		for i := 0; i < v.Len(); i++ {
+			tagRules(v.Index(i), segs, depth+1, add)
+		}
+	case reflect.Map:
+		segs := appendSegment(prefix, segment{any: true, index: -1})
+		for _, k := range v.MapKeys() {
+			tagRules(v.MapIndex(k), segs, depth+1, add)
+		}
+	}
+}
+
+// bsonName, key and inline flag of a struct field in bson documents
+func bsonName(f reflect.StructField) (string, bool) {
+	parts := strings.Split(f.Tag.Get("bson"), ",")
+	inline := false
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			inline = true
+		}
+	}
+
+	if parts[0] == "" {
+		return strings.ToLower(f.Name), inline
+	}
+	return parts[0], inline
+}
+
+// appendSegment, copy of segs with seg appended
+func appendSegment(segs []segment, seg segment) []segment {
+	out := make([]segment, len(segs), len(segs)+1)
+	copy(out, segs)
+	return append(out, seg)
+}
+
+// checkStrategy, strategy is known
+func checkStrategy(s Strategy) error {
+	switch s {
+	case StrategyMask, StrategyHash, StrategyDrop:
+		return nil
+	}
+	return fmt.Errorf("unknown redact strategy %q", s)
+}
+
+// RedactAudit, audit backend redacting event data before the wrapped backend persists it
+type RedactAudit struct {
+	IAudit
+	Redactor *Redactor
+}
+
+// NewRedactAudit, return audit backend redacting data for audit
+func NewRedactAudit(audit IAudit, redactor *Redactor) *RedactAudit {
+	return &RedactAudit{IAudit: audit, Redactor: redactor}
+}
+
+// Log, redact and log entry, the channel delivers the redaction or backend error
+func (a *RedactAudit) Log(user, message, data interface{}) chan error {
+	return a.LogContext(context.Background(), user, message, data)
+}
+
+// LogContext, redact and log entry, the channel delivers the redaction or backend error
+func (a *RedactAudit) LogContext(ctx context.Context, user, message, data interface{}) chan error {
+	return a.LogEventContext(ctx, LegacyEvent(user, message, data))
+}
+
+// LogSync, redact and log entry
+func (a *RedactAudit) LogSync(user, message, data interface{}) error {
+	return a.LogSyncContext(context.Background(), user, message, data)
+}
+
+// LogSyncContext, redact and log entry
+func (a *RedactAudit) LogSyncContext(ctx context.Context, user, message, data interface{}) error {
+	return a.LogEventSyncContext(ctx, LegacyEvent(user, message, data))
+}
+
+// LogEvent, redact and log event, the channel delivers the redaction or backend error,
+// events failing redaction aren't logged
+func (a *RedactAudit) LogEvent(event *Event) chan error {
+	return a.LogEventContext(context.Background(), event)
+}
+
+// LogEventContext, redact and log event, the channel delivers the redaction or backend error,
+// events failing redaction aren't logged
+func (a *RedactAudit) LogEventContext(ctx context.Context, event *Event) chan error {
+	e, err := a.Redactor.RedactEvent(event)
+	if err != nil {
+		done := make(chan error, 1)
+		done <- err
+		close(done)
+		return done
+	}
+
+	return a.IAudit.LogEventContext(ctx, e)
+}
+
+// LogEventSync, redact and log event, events failing redaction aren't logged
+func (a *RedactAudit) LogEventSync(event *Event) error {
+	return a.LogEventSyncContext(context.Background(), event)
+}
+
+// LogEventSyncContext, redact and log event, events failing redaction aren't logged
+func (a *RedactAudit) LogEventSyncContext(ctx context.Context, event *Event) error {
+	e, err := a.Redactor.RedactEvent(event)
+	if err != nil {
+		return err
+	}
+
+	return a.IAudit.LogEventSyncContext(ctx, e)
+}
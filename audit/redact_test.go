@@ -0,0 +1,180 @@
+package lxAudit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+type card struct {
+	Holder string
+	IBAN   string `bson:"iban" audit:"hash"`
+	CVC    string `bson:"cvc" audit:"drop"`
+}
+
+type signup struct {
+	Name     string `bson:"name"`
+	Password string `bson:"password" audit:"mask"`
+	Cards    []card `bson:"cards"`
+	Meta     bson.M `bson:"meta"`
+	Ignored  string `bson:"-" audit:"mask"`
+}
+
+func TestRedactor_Redact(t *testing.T) {
+	data := &signup{
+		Name:     "Alice",
+		Password: "geheim",
+		Cards:    []card{{Holder: "Alice", IBAN: "DE89370400440532013000", CVC: "123"}},
+		Meta:     bson.M{"Session": bson.M{"AccessToken": "t0k3n", "Lang": "de"}, "tags": []interface{}{"a", "b"}},
+	}
+
+	t.Run("struct tags", func(t *testing.T) {
+		r, err := lxAudit.NewRedactor()
+		assert.NoError(t, err)
+		r.HashKey = []byte("secret")
+
+		v, err := r.Redact(data)
+		assert.NoError(t, err)
+		doc := v.(bson.M)
+		assert.Equal(t, "Alice", doc["name"])
+		assert.Equal(t, lxAudit.DefaultMask, doc["password"])
+
+		c := doc["cards"].([]interface{})[0].(bson.M)
+		assert.Equal(t, "Alice", c["holder"])
+		assert.Regexp(t, "^sha256:[0-9a-f]{64}$", c["iban"])
+		assert.NotContains(t, c, "cvc")
+
+		// Source isn't modified
+		assert.Equal(t, "geheim", data.Password)
+	})
+
+	t.Run("key rules", func(t *testing.T) {
+		r, err := lxAudit.NewRedactor(lxAudit.DefaultKeyRules...)
+		assert.NoError(t, err)
+		r.HashKey = []byte("secret")
+
+		v, err := r.Redact(data)
+		assert.NoError(t, err)
+		session := v.(bson.M)["meta"].(bson.M)["Session"].(bson.M)
+		assert.Equal(t, lxAudit.DefaultMask, session["AccessToken"])
+		assert.Equal(t, "de", session["Lang"])
+
+		// Defaults don't hash, an iban is masked without a key
+		v, err = r.Redact(bson.M{"iban": "DE89370400440532013000"})
+		assert.NoError(t, err)
+		assert.Equal(t, bson.M{"iban": lxAudit.DefaultMask}, v)
+	})
+
+	t.Run("path rules", func(t *testing.T) {
+		r, err := lxAudit.NewRedactor(
+			lxAudit.Rule{Path: "$.name", Strategy: lxAudit.StrategyHash},
+			lxAudit.Rule{Path: "$.meta.tags[1]", Strategy: lxAudit.StrategyDrop},
+			lxAudit.Rule{Path: "$.cards[*].holder", Strategy: lxAudit.StrategyMask},
+			lxAudit.Rule{Path: "$..Lang", Strategy: lxAudit.StrategyDrop},
+		)
+		assert.NoError(t, err)
+		r.Mask = "[redacted]"
+		r.HashKey = []byte("secret")
+
+		v, err := r.Redact(data)
+		assert.NoError(t, err)
+		doc := v.(bson.M)
+		assert.Regexp(t, "^sha256:", doc["name"])
+		assert.Equal(t, []interface{}{"a", nil}, doc["meta"].(bson.M)["tags"])
+		assert.Equal(t, "[redacted]", doc["cards"].([]interface{})[0].(bson.M)["holder"])
+		assert.NotContains(t, doc["meta"].(bson.M)["Session"], "Lang")
+	})
+
+	t.Run("hash with key", func(t *testing.T) {
+		keyed, _ := lxAudit.NewRedactor(lxAudit.Rule{Key: "iban", Strategy: lxAudit.StrategyHash})
+		keyed.HashKey = []byte("secret")
+		other, _ := lxAudit.NewRedactor(lxAudit.Rule{Key: "iban", Strategy: lxAudit.StrategyHash})
+		other.HashKey = []byte("other")
+
+		a, _ := other.Redact(bson.M{"iban": "DE89"})
+		b, _ := keyed.Redact(bson.M{"iban": "DE89"})
+		c, _ := keyed.Redact(bson.M{"IBAN": "DE89"})
+		assert.NotEqual(t, a, b)
+		assert.Equal(t, b.(bson.M)["iban"], c.(bson.M)["IBAN"])
+	})
+
+	t.Run("hash without key", func(t *testing.T) {
+		r, _ := lxAudit.NewRedactor(lxAudit.Rule{Key: "iban", Strategy: lxAudit.StrategyHash})
+		_, err := r.Redact(bson.M{"iban": "DE89"})
+		assert.Equal(t, lxAudit.ErrHashKey, err)
+
+		// Hash tags need a key as well
+		r, _ = lxAudit.NewRedactor()
+		_, err = r.Redact(data)
+		assert.Equal(t, lxAudit.ErrHashKey, err)
+	})
+
+	t.Run("invalid rules", func(t *testing.T) {
+		for _, rule := range []lxAudit.Rule{
+			{Path: "$.a", Strategy: "blur"},
+			{Path: "$.a[x]", Strategy: lxAudit.StrategyMask},
+			{Path: "$.a.", Strategy: lxAudit.StrategyMask},
+			{Key: "[", Strategy: lxAudit.StrategyMask},
+			{Strategy: lxAudit.StrategyMask},
+			{Path: "$.a", Key: "a", Strategy: lxAudit.StrategyMask},
+		} {
+			_, err := lxAudit.NewRedactor(rule)
+			assert.Error(t, err, "%+v", rule)
+		}
+
+		_, err := (&lxAudit.Redactor{}).Redact(struct {
+			A string `audit:"blur"`
+		}{})
+		assert.Error(t, err)
+	})
+}
+
+func TestRedactAudit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mock := lxAuditMocks.NewMockIAudit(mockCtrl)
+	r, err := lxAudit.NewRedactor(lxAudit.DefaultKeyRules...)
+	assert.NoError(t, err)
+	audit := lxAudit.NewRedactAudit(mock, r)
+
+	t.Run("redacts before the backend", func(t *testing.T) {
+		mock.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e *lxAudit.Event) error {
+			assert.Equal(t, bson.M{"user": "alice", "password": lxAudit.DefaultMask}, e.Data)
+			return nil
+		})
+		assert.NoError(t, audit.LogSync("alice", "login", bson.M{"user": "alice", "password": "geheim"}))
+	})
+
+	t.Run("backend error", func(t *testing.T) {
+		done := make(chan error, 1)
+		done <- errors.New("down")
+		mock.EXPECT().LogEventContext(gomock.Any(), gomock.Any()).Return(done)
+		assert.EqualError(t, <-audit.Log("alice", "login", nil), "down")
+	})
+
+	t.Run("redacts legacy data of struct users by its own root", func(t *testing.T) {
+		r, err := lxAudit.NewRedactor(lxAudit.Rule{Path: "$.card", Strategy: lxAudit.StrategyMask})
+		assert.NoError(t, err)
+		audit := lxAudit.NewRedactAudit(mock, r)
+
+		user := struct{ Name string }{Name: "bob"}
+		mock.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e *lxAudit.Event) error {
+			legacy := e.Data.(*lxAudit.LegacyData)
+			assert.Equal(t, user, legacy.User)
+			assert.Equal(t, bson.M{"card": lxAudit.DefaultMask}, legacy.Data)
+			return nil
+		})
+		assert.NoError(t, audit.LogSync(user, "pay", bson.M{"card": "DE5678"}))
+	})
+
+	t.Run("fails closed", func(t *testing.T) {
+		assert.Error(t, <-audit.LogEvent(lxAudit.NewEvent("x").WithData(make(chan int))))
+	})
+}
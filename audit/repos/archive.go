@@ -0,0 +1,319 @@
+package lxAuditRepos
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+const (
+	// ActionArchive, action of the audit entry with the manifest of an archive
+	ActionArchive = "audit.archive"
+
+	// DefaultArchiveInterval, run interval of a started Archiver
+	DefaultArchiveInterval = 24 * time.Hour
+
+	// archiveTimeFormat, time in archive file names
+	archiveTimeFormat = "20060102T150405Z"
+)
+
+// ArchiveManifest, description of an archive file, logged as audit entry
+type ArchiveManifest struct {
+	File    string    `json:"file" bson:"file"`
+	Entries int       `json:"entries" bson:"entries"`
+	Bytes   int64     `json:"bytes" bson:"bytes"`
+	SHA256  string    `json:"sha256" bson:"sha256"`
+	Before  time.Time `json:"before" bson:"before"` // Archived entries are older
+	From    time.Time `json:"from" bson:"from"`     // Oldest archived entry
+	To      time.Time `json:"to" bson:"to"`         // Newest archived entry
+
+	// Last archived link per hash chain, carried over from the previous manifest
+	Chains []ArchiveChain `json:"chains,omitempty" bson:"chains,omitempty"`
+}
+
+// ArchiveChain, last archived link of the hash chain of a service,
+// Verify from the start continues after it
+type ArchiveChain struct {
+	Service string `json:"service" bson:"service"`
+	Seq     int64  `json:"seq" bson:"seq"`
+	Hash    string `json:"hash" bson:"hash"`
+}
+
+// Archiver, move audit entries older than MaxAge to gzip compressed JSON Lines files in Dir
+// and log the manifest of each file to Audit, Verify of chained entries needs the
+// manifests in the audit collection
+type Archiver struct {
+	Dir      string
+	MaxAge   time.Duration
+	Interval time.Duration // Run interval of Start, default DefaultArchiveInterval
+
+	db    lxDb.IBaseDb
+	audit lxAudit.IAudit
+
+	mu   sync.Mutex // one run at a time
+	exit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewArchiver, return archiver for the audit collection of db, manifests are logged to audit
+func NewArchiver(db lxDb.IBaseDb, audit lxAudit.IAudit, dir string, maxAge time.Duration) *Archiver {
+	return &Archiver{db: db, audit: audit, Dir: dir, MaxAge: maxAge}
+}
+
+// Run, archive and delete entries older than MaxAge, returns nil without old entries,
+// entries are deleted by id after the file is written, entries written meanwhile stay
+func (a *Archiver) Run(ctx context.Context) (*ArchiveManifest, error) {
+	if a.MaxAge <= 0 {
+		return nil, fmt.Errorf("audit archive needs a positive max age, got %v", a.MaxAge)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Links of earlier archives, the previous manifest may be archived by this run
+	last, err := lastArchive(ctx, a.db, bson.M{"action": ActionArchive})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	m := &ArchiveManifest{Before: now.Add(-a.MaxAge)}
+	if last != nil {
+		m.Chains = last.Chains
+	}
+	base := filepath.Join(a.Dir, "audit-"+m.Before.Format(archiveTimeFormat))
+	m.File = base + ".jsonl.gz"
+	for i := 1; exists(m.File); i++ {
+		m.File = fmt.Sprintf("%s-%d.jsonl.gz", base, i)
+	}
+
+	ids, err := a.write(ctx, m)
+	if err != nil || m.Entries == 0 {
+		return nil, err
+	}
+
+	// Delete archived entries in batches
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > ExportBatchSize {
+			n = ExportBatchSize
+		}
+		if _, err := a.db.DeleteAllContext(ctx, bson.M{"_id": bson.M{"$in": ids[:n]}}); err != nil {
+			a.log(ctx, m, err)
+			return m, err
+		}
+		ids = ids[n:]
+	}
+
+	return m, a.log(ctx, m, nil)
+}
+
+// write, write entries before m.Before to m.File, returns their ids
+func (a *Archiver) write(ctx context.Context, m *ArchiveManifest) ([]interface{}, error) {
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Temp file, readers never see a partial archive
+	tmp := m.File + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	hash := sha256.New()
+	counter := &countWriter{w: io.MultiWriter(f, hash)}
+	zw := gzip.NewWriter(counter)
+	exp, err := lxAudit.NewExporter(zw, lxAudit.FormatJSONL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keyset on timestamp and _id like Export
+	var ids []interface{}
+	filter := bson.M{"timestamp": bson.M{"$lt": m.Before}}
+	query := filter
+	opts := &lxDb.Options{Sort: "timestamp,_id", Limit: ExportBatchSize}
+	for {
+		var docs []struct {
+			Id            interface{} `bson:"_id"`
+			lxAudit.Event `bson:",inline"`
+		}
+		if _, err := a.db.GetAllContext(ctx, query, &docs, opts); err != nil {
+			return nil, err
+		}
+
+		for i := range docs {
+			if err := exp.Write(&docs[i].Event); err != nil {
+				return nil, err
+			}
+			if m.Entries == 0 {
+				m.From = docs[i].TimeStamp.UTC()
+			}
+			m.To = docs[i].TimeStamp.UTC()
+			m.link(&docs[i].Event)
+			m.Entries++
+			ids = append(ids, docs[i].Id)
+		}
+
+		if len(docs) < ExportBatchSize {
+			break
+		}
+
+		last := docs[len(docs)-1]
+		query = bson.M{"$and": []interface{}{filter, bson.M{"$or": []interface{}{
+			bson.M{"timestamp": bson.M{"$gt": last.TimeStamp}},
+			bson.M{"timestamp": last.TimeStamp, "_id": bson.M{"$gt": last.Id}},
+		}}}}
+	}
+	if m.Entries == 0 {
+		return nil, nil
+	}
+
+	if err := exp.Flush(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, m.File); err != nil {
+		return nil, err
+	}
+
+	m.Bytes = counter.n
+	m.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return ids, nil
+}
+
+// link, keep the highest archived link of the chain of event
+func (m *ArchiveManifest) link(event *lxAudit.Event) {
+	if event.Seq == 0 {
+		return
+	}
+	for i := range m.Chains {
+		if m.Chains[i].Service == event.ServiceName {
+			if event.Seq > m.Chains[i].Seq {
+				m.Chains[i].Seq, m.Chains[i].Hash = event.Seq, event.Hash
+			}
+			return
+		}
+	}
+	m.Chains = append(m.Chains, ArchiveChain{Service: event.ServiceName, Seq: event.Seq, Hash: event.Hash})
+}
+
+// lastArchive, newest manifest matching query, nil without archive
+func lastArchive(ctx context.Context, db lxDb.IBaseDb, query bson.M) (*ArchiveManifest, error) {
+	var docs []struct {
+		Data ArchiveManifest `bson:"data"`
+	}
+	if _, err := db.GetAllContext(ctx, query, &docs, &lxDb.Options{Sort: "-timestamp", Limit: 1}); err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	return &docs[0].Data, nil
+}
+
+// archivedLink, last archived link of the chain of service, nil without archive
+func archivedLink(ctx context.Context, db lxDb.IBaseDb, service string) (*ArchiveChain, error) {
+	m, err := lastArchive(ctx, db, bson.M{
+		"action":      ActionArchive,
+		"data.chains": bson.M{"$elemMatch": bson.M{"service": service}},
+	})
+	if err != nil || m == nil {
+		return nil, err
+	}
+	for i := range m.Chains {
+		if m.Chains[i].Service == service {
+			return &m.Chains[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// log, log manifest as audit entry
+func (a *Archiver) log(ctx context.Context, m *ArchiveManifest, err error) error {
+	event := lxAudit.NewEvent(ActionArchive).
+		WithResource("audit_archive", filepath.Base(m.File)).
+		WithMessage("archived audit entries").
+		WithData(m).
+		Succeeded()
+	if err != nil {
+		event.Failed().WithMessage(err.Error())
+	}
+
+	return a.audit.LogEventSyncContext(ctx, event)
+}
+
+// Start, run the archiver every Interval until Stop
+func (a *Archiver) Start() {
+	interval := a.Interval
+	if interval <= 0 {
+		interval = DefaultArchiveInterval
+	}
+
+	a.exit = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := a.Run(context.Background()); err != nil {
+					log.Printf("audit can't archive entries, error: %v\n", err)
+				}
+			case <-a.exit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop, stop a started archiver and wait for a running archive
+func (a *Archiver) Stop() {
+	if a.exit == nil {
+		return
+	}
+	close(a.exit)
+	a.wg.Wait()
+	a.exit = nil
+}
+
+// countWriter, count written bytes
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
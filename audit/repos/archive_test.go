@@ -0,0 +1,198 @@
+package lxAuditRepos_test
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/tests/fixtures"
+	"github.com/litixsoft/lx-golib/tests/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiver_Run(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 5; i++ {
+		e := lxAudit.NewEvent("user.login").WithActor("7", "user")
+		e.TimeStamp = old.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, repo.LogEventSync(e))
+	}
+	assert.NoError(t, repo.LogSync("test_user", "recent", nil))
+
+	archiver := lxAuditRepos.NewArchiver(db, repo, dir, 24*time.Hour)
+	m, err := archiver.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 5, m.Entries)
+	assert.Equal(t, old.Unix(), m.From.Unix())
+	assert.Equal(t, old.Add(4*time.Minute).Unix(), m.To.Unix())
+
+	// Archive file matches the manifest
+	data, err := ioutil.ReadFile(m.File)
+	assert.NoError(t, err)
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), m.SHA256)
+	assert.Equal(t, int64(len(data)), m.Bytes)
+	assert.Equal(t, 5, countLines(t, m.File, true))
+	tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	assert.Empty(t, tmp)
+
+	// Archived entries are deleted, the manifest is logged
+	n, err := db.GetCount(bson.M{"action": "user.login"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = db.GetCount(bson.M{"message": "recent"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var entry lxAudit.Event
+	assert.NoError(t, db.GetOne(bson.M{"action": lxAuditRepos.ActionArchive}, &entry))
+	assert.Equal(t, lxAudit.OutcomeSuccess, entry.Outcome)
+	assert.Equal(t, filepath.Base(m.File), entry.ResourceId)
+	assert.Equal(t, m.SHA256, entry.Data.(bson.M)["sha256"])
+
+	// Nothing left to archive
+	m, err = archiver.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	assert.Len(t, files, 1)
+}
+
+func TestArchiver_RunChain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost, lxAuditRepos.WithHashChain([]byte("key")))
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 3; i++ {
+		e := lxAudit.NewEvent("user.login")
+		e.TimeStamp = old.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, repo.LogEventSync(e))
+	}
+	assert.NoError(t, repo.LogSync("test_user", "recent", nil))
+
+	archiver := lxAuditRepos.NewArchiver(db, repo, dir, 24*time.Hour)
+	m, err := archiver.Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, m.Entries)
+	assert.Len(t, m.Chains, 1)
+	assert.Equal(t, ServiceName, m.Chains[0].Service)
+	assert.Equal(t, int64(3), m.Chains[0].Seq)
+
+	t.Run("Verify continues after the archived link", func(t *testing.T) {
+		// Recent entry and manifest
+		n, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		n, err = repo.Verify(ctx, ServiceName, 4, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+	t.Run("Later manifests carry the link over", func(t *testing.T) {
+		// The first manifest ages and is archived
+		_, err := db.UpdateAll(bson.M{}, bson.M{"$set": bson.M{"timestamp": old}})
+		assert.NoError(t, err)
+		m, err = archiver.Run(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, m.Entries)
+		assert.Equal(t, int64(5), m.Chains[0].Seq)
+
+		n, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+	t.Run("Expired entries break the chain", func(t *testing.T) {
+		other := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+		repo := lxAuditRepos.NewAuditMongo(other, ServiceName, ServiceHost, lxAuditRepos.WithHashChain(nil))
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, repo.LogEventSync(lxAudit.NewEvent("user.login")))
+		}
+
+		// Removed like a TTL index without a manifest
+		assert.NoError(t, other.Delete(bson.M{"seq": 1}))
+		_, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.Equal(t, &lxAudit.ChainError{Service: ServiceName, Seq: 1, Reason: lxAudit.ReasonMissing}, err)
+	})
+}
+
+func TestArchiver_RunMaxAge(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+	assert.NoError(t, repo.LogSync("test_user", "recent", nil))
+
+	for _, maxAge := range []time.Duration{0, -time.Hour} {
+		m, err := lxAuditRepos.NewArchiver(db, repo, "unused", maxAge).Run(context.Background())
+		assert.Error(t, err)
+		assert.Nil(t, m)
+	}
+
+	n, err := db.GetCount(bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestArchiver_Start(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+	e := lxAudit.NewEvent("user.login")
+	e.TimeStamp = time.Now().Add(-time.Hour)
+	assert.NoError(t, repo.LogEventSync(e))
+
+	archiver := lxAuditRepos.NewArchiver(db, repo, dir, time.Minute)
+	archiver.Interval = 10 * time.Millisecond
+	archiver.Start()
+	time.Sleep(50 * time.Millisecond)
+	archiver.Stop()
+	archiver.Stop()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl.gz"))
+	assert.Len(t, files, 1)
+	assert.True(t, strings.HasSuffix(files[0], ".jsonl.gz"))
+	f, err := os.Open(files[0])
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = gzip.NewReader(f)
+	assert.NoError(t, err)
+}
+
+func TestAuditMongo_TTL(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	mockIBaseDb := lxGoLibMocks.NewMockIBaseDb(mockCtrl)
+	repo := lxAuditRepos.NewAuditMongo(mockIBaseDb, ServiceName, ServiceHost, lxAuditRepos.WithTTL(90*24*time.Hour))
+
+	mockIBaseDb.EXPECT().Setup(gomock.Any()).DoAndReturn(func(indexes []mgo.Index) error {
+		assert.Equal(t, []string{"timestamp"}, indexes[0].Key)
+		assert.Equal(t, 90*24*time.Hour, indexes[0].ExpireAfter)
+		for _, idx := range indexes[1:] {
+			assert.Zero(t, idx.ExpireAfter)
+		}
+		return nil
+	})
+	assert.NoError(t, repo.SetupAudit())
+}
@@ -0,0 +1,422 @@
+package lxAuditRepos
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+const (
+	// PolicyBlock, Log waits for free space in the queue
+	PolicyBlock = "block"
+
+	// PolicyDrop, Log drops the entry with ErrQueueFull when the queue is full
+	PolicyDrop = "drop"
+)
+
+var (
+	// ErrQueueFull, entry was dropped by PolicyDrop
+	ErrQueueFull = errors.New("audit queue is full, entry dropped")
+
+	// ErrClosed, entry was logged after Close
+	ErrClosed = errors.New("audit writer is closed")
+)
+
+// BatchConfig, config for the batched audit writer, zero values use the defaults
+type BatchConfig struct {
+	QueueSize     int           // Max entries waiting, default 10000
+	BatchSize     int           // Max entries per bulk insert, default 100
+	FlushInterval time.Duration // Max wait for a full batch, default 1s
+	Policy        string        // PolicyBlock (default) or PolicyDrop
+
+	// Spool, durable fallback for failed inserts, spooled entries are replayed
+	// in order before new entries are inserted, optional
+	Spool *Spool
+}
+
+// BatchStats, counters of the batched audit writer
+type BatchStats struct {
+	Queued   uint64     // Entries accepted
+	Written  uint64     // Entries inserted
+	Failed   uint64     // Entries neither inserted nor spooled
+	Dropped  uint64     // Entries rejected by PolicyDrop, a done context or after Close
+	Batches  uint64     // Bulk inserts
+	Spooled  uint64     // Entries written to the spool
+	Replayed uint64     // Entries inserted from the spool
+	Depth    int        // Entries waiting in the queue
+	Spool    SpoolStats // Depth of the spool
+}
+
+// spooledEntry, entry with fixed id, replays after partial inserts skip duplicates
+type spooledEntry struct {
+	Id            bson.ObjectId `bson:"_id"`
+	lxAudit.Event `bson:",inline"`
+}
+
+// queued, entry with the channel of its caller
+type queued struct {
+	entry *lxAudit.Event
+	done  chan error
+}
+
+// AuditBatch, audit repository writing entries with bulk inserts from a bounded queue,
+// reads are served by the embedded mongo repository
+type AuditBatch struct {
+	*auditMongo
+	config BatchConfig
+
+	mu        sync.RWMutex
+	closed    bool
+	queue     chan queued
+	exit      chan struct{}
+	closing   chan struct{} // closed by Close, releases blocked senders
+	closeOnce sync.Once
+
+	queued, written, failed, dropped, batches, spooled, replayed uint64
+}
+
+// NewAuditBatch, return batched audit repository and start its writer,
+// db can be a lxDb.MongoDb or a lxDb.MemoryDb for tests
+func NewAuditBatch(db lxDb.IBaseDb, serviceName, serviceHost string, config BatchConfig, opts ...Option) *AuditBatch {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 10000
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Policy == "" {
+		config.Policy = PolicyBlock
+	}
+
+	repo := &AuditBatch{
+		auditMongo: newAuditMongo(db, serviceName, serviceHost, opts),
+		config:     config,
+		queue:      make(chan queued, config.QueueSize),
+		exit:       make(chan struct{}),
+		closing:    make(chan struct{}),
+	}
+	go repo.run()
+
+	return repo
+}
+
+// Log, queue log entry, the channel delivers the insert error or nil
+func (repo *AuditBatch) Log(user, message, data interface{}) chan error {
+	return repo.LogContext(context.Background(), user, message, data)
+}
+
+// LogContext, queue log entry, the channel delivers the insert error or nil
+func (repo *AuditBatch) LogContext(ctx context.Context, user, message, data interface{}) chan error {
+	return repo.LogEventContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogSync, queue log entry and wait for its batch
+func (repo *AuditBatch) LogSync(user, message, data interface{}) error {
+	return repo.LogSyncContext(context.Background(), user, message, data)
+}
+
+// LogSyncContext, queue log entry and wait for its batch
+func (repo *AuditBatch) LogSyncContext(ctx context.Context, user, message, data interface{}) error {
+	return repo.LogEventSyncContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogEvent, queue event, the channel delivers the insert error or nil
+func (repo *AuditBatch) LogEvent(event *lxAudit.Event) chan error {
+	return repo.LogEventContext(context.Background(), event)
+}
+
+// LogEventContext, queue event, the channel delivers the insert error or nil,
+// PolicyBlock gives up with ctx.Err() when ctx is done before the queue has space
+func (repo *AuditBatch) LogEventContext(ctx context.Context, event *lxAudit.Event) chan error {
+	entry, err := repo.entry(event)
+	item := queued{entry: entry, done: make(chan error, 1)}
+	if err != nil {
+		item.done <- err
+		close(item.done)
+		return item.done
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	if repo.closed {
+		return repo.reject(item, ErrClosed)
+	}
+
+	if repo.config.Policy == PolicyDrop {
+		select {
+		case repo.queue <- item:
+		default:
+			return repo.reject(item, ErrQueueFull)
+		}
+	} else {
+		select {
+		case repo.queue <- item:
+		case <-repo.closing:
+			return repo.reject(item, ErrClosed)
+		case <-ctx.Done():
+			return repo.reject(item, ctx.Err())
+		}
+	}
+	atomic.AddUint64(&repo.queued, 1)
+
+	return item.done
+}
+
+// reject, count dropped item and deliver err
+func (repo *AuditBatch) reject(item queued, err error) chan error {
+	atomic.AddUint64(&repo.dropped, 1)
+	item.done <- err
+	close(item.done)
+
+	return item.done
+}
+
+// LogEventSync, queue event and wait for its batch
+func (repo *AuditBatch) LogEventSync(event *lxAudit.Event) error {
+	return repo.LogEventSyncContext(context.Background(), event)
+}
+
+// LogEventSyncContext, queue event and wait for its batch, returns ctx.Err() when ctx
+// is done first, a queued entry is still written
+func (repo *AuditBatch) LogEventSyncContext(ctx context.Context, event *lxAudit.Event) error {
+	select {
+	case err := <-repo.LogEventContext(ctx, event):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats, return the counters
+func (repo *AuditBatch) Stats() BatchStats {
+	stats := BatchStats{
+		Queued:   atomic.LoadUint64(&repo.queued),
+		Written:  atomic.LoadUint64(&repo.written),
+		Failed:   atomic.LoadUint64(&repo.failed),
+		Dropped:  atomic.LoadUint64(&repo.dropped),
+		Batches:  atomic.LoadUint64(&repo.batches),
+		Spooled:  atomic.LoadUint64(&repo.spooled),
+		Replayed: atomic.LoadUint64(&repo.replayed),
+		Depth:    len(repo.queue),
+	}
+	if repo.config.Spool != nil {
+		stats.Spool = repo.config.Spool.Stats()
+	}
+
+	return stats
+}
+
+// Close, reject new entries and flush the queue,
+// returns ctx.Err() when ctx is done before the queue is flushed
+func (repo *AuditBatch) Close(ctx context.Context) error {
+	// Blocked senders give up their read lock
+	repo.closeOnce.Do(func() { close(repo.closing) })
+
+	repo.mu.Lock()
+	if !repo.closed {
+		repo.closed = true
+		close(repo.queue)
+	}
+	repo.mu.Unlock()
+
+	select {
+	case <-repo.exit:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run, collect batches until the queue is closed
+func (repo *AuditBatch) run() {
+	defer close(repo.exit)
+
+	ticker := time.NewTicker(repo.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]queued, 0, repo.config.BatchSize)
+	for {
+		select {
+		case item, ok := <-repo.queue:
+			if !ok {
+				repo.flush(batch)
+				return
+			}
+			if batch = append(batch, item); len(batch) >= repo.config.BatchSize {
+				batch = repo.flush(batch)
+			}
+		case <-ticker.C:
+			repo.replay()
+			batch = repo.flush(batch)
+		}
+	}
+}
+
+// flush, insert batch and inform the callers, failed inserts go to the spool,
+// all entries of a failed bulk insert get its error, returns the emptied batch
+func (repo *AuditBatch) flush(batch []queued) []queued {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	spool := repo.config.Spool
+	docs := make([]interface{}, len(batch))
+	for i, item := range batch {
+		if spool != nil {
+			docs[i] = &spooledEntry{Id: bson.NewObjectId(), Event: *item.entry}
+		} else {
+			docs[i] = item.entry
+		}
+	}
+
+	var err error
+	if spool != nil && spool.Stats().Entries > 0 {
+		// Keep the order, new entries wait behind the spooled ones and are linked at replay
+		err = repo.spool(docs)
+	} else {
+		err = repo.insertAll(docs)
+		atomic.AddUint64(&repo.batches, 1)
+		if err == nil {
+			atomic.AddUint64(&repo.written, uint64(len(batch)))
+		} else if spool != nil {
+			log.Printf("mongoDb can't insert %d audit entries, spool them, error: %v\n", len(batch), err)
+			err = repo.spool(docs)
+		}
+	}
+
+	if err != nil {
+		return repo.fail(batch, err)
+	}
+
+	return repo.done(batch, nil)
+}
+
+// insertAll, insert docs in one bulk, with chain the docs are linked right before the insert,
+// so only entries stored in mongo hold sequences, heads are reloaded after a failure
+func (repo *AuditBatch) insertAll(docs []interface{}) error {
+	if repo.chain == nil {
+		return repo.db.CreateAll(docs)
+	}
+
+	repo.chain.mu.Lock()
+	defer repo.chain.mu.Unlock()
+
+	for _, doc := range docs {
+		if err := repo.link(context.Background(), entryEvent(doc)); err != nil {
+			repo.chain.heads = map[string]*link{}
+			return err
+		}
+	}
+	if err := repo.db.CreateAll(docs); err != nil {
+		repo.chain.heads = map[string]*link{}
+		return err
+	}
+
+	return nil
+}
+
+// entryEvent, event of a queued or spooled document
+func entryEvent(doc interface{}) *lxAudit.Event {
+	if e, ok := doc.(*spooledEntry); ok {
+		return &e.Event
+	}
+	return doc.(*lxAudit.Event)
+}
+
+// fail, count and log failed batch and inform the callers
+func (repo *AuditBatch) fail(batch []queued, err error) []queued {
+	log.Printf("mongoDb can't insert %d audit entries, error: %v\n", len(batch), err)
+	atomic.AddUint64(&repo.failed, uint64(len(batch)))
+
+	return repo.done(batch, err)
+}
+
+// done, inform the callers of batch, returns the emptied batch
+func (repo *AuditBatch) done(batch []queued, err error) []queued {
+	for _, item := range batch {
+		item.done <- err
+		close(item.done)
+	}
+
+	return batch[:0]
+}
+
+// spool, append entries to the spool
+func (repo *AuditBatch) spool(docs []interface{}) error {
+	if err := repo.config.Spool.Append(docs); err != nil {
+		return err
+	}
+	atomic.AddUint64(&repo.spooled, uint64(len(docs)))
+
+	return nil
+}
+
+// replay, insert spooled entries, stops at the first failure until the next flush interval
+func (repo *AuditBatch) replay() {
+	spool := repo.config.Spool
+	if spool == nil || spool.Stats().Entries == 0 {
+		return
+	}
+
+	n, err := spool.Replay(repo.config.BatchSize, repo.insertOnce)
+	atomic.AddUint64(&repo.replayed, uint64(n))
+	if err != nil {
+		log.Printf("mongoDb can't replay audit spool, error: %v\n", err)
+	}
+}
+
+// insertOnce, insert spooled documents, skip documents inserted by an earlier partial replay,
+// only ids already in mongo are skipped, other duplicates fail the replay
+func (repo *AuditBatch) insertOnce(docs []interface{}) error {
+	entries := make([]interface{}, 0, len(docs))
+	ids := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		e := &spooledEntry{}
+		if err := bson.Unmarshal(raw, e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		ids = append(ids, e.Id)
+	}
+
+	var found []struct {
+		Id bson.ObjectId `bson:"_id"`
+	}
+	if _, err := repo.db.GetAll(bson.M{"_id": bson.M{"$in": ids}}, &found, nil); err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return repo.insertAll(entries)
+	}
+
+	inserted := make(map[bson.ObjectId]bool, len(found))
+	for _, f := range found {
+		inserted[f.Id] = true
+	}
+	pending := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		if !inserted[e.(*spooledEntry).Id] {
+			pending = append(pending, e)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return repo.insertAll(pending)
+}
@@ -0,0 +1,403 @@
+package lxAuditRepos_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/tests/fixtures"
+	"github.com/litixsoft/lx-golib/tests/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditBatch_Log(t *testing.T) {
+	t.Run("Entries are inserted in batches", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+		repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{BatchSize: 5, FlushInterval: time.Hour})
+
+		var done []chan error
+		for i := 0; i < 10; i++ {
+			done = append(done, repo.Log("test_user", fmt.Sprint("message ", i), nil))
+		}
+		for _, d := range done {
+			assert.NoError(t, <-d)
+		}
+
+		stats := repo.Stats()
+		assert.Equal(t, uint64(10), stats.Written)
+		assert.Equal(t, uint64(2), stats.Batches)
+
+		var result lxAudit.Event
+		assert.NoError(t, db.GetOne(bson.M{"message": "message 9"}, &result))
+		assert.Equal(t, ServiceName, result.ServiceName)
+		assert.NoError(t, repo.Close(context.Background()))
+	})
+
+	t.Run("Partial batch is inserted after flush interval", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+		repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{FlushInterval: 10 * time.Millisecond})
+		defer repo.Close(context.Background())
+
+		assert.NoError(t, repo.LogEventSync(lxAudit.NewEvent("user.login")))
+		assert.Equal(t, uint64(1), repo.Stats().Written)
+	})
+
+	t.Run("Failed insert is delivered to every entry", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		failure := errors.New("no reachable servers")
+		db := lxGoLibMocks.NewMockIBaseDb(ctrl)
+		db.EXPECT().CreateAll(gomock.Any()).Return(failure)
+
+		repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{BatchSize: 2, FlushInterval: time.Hour})
+		a := repo.Log("test_user", "a", nil)
+		b := repo.Log("test_user", "b", nil)
+		assert.Equal(t, failure, <-a)
+		assert.Equal(t, failure, <-b)
+		assert.Equal(t, uint64(2), repo.Stats().Failed)
+		assert.NoError(t, repo.Close(context.Background()))
+	})
+}
+
+func TestAuditBatch_CloseBlocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started, release := make(chan bool, 1), make(chan bool)
+	db := lxGoLibMocks.NewMockIBaseDb(ctrl)
+	db.EXPECT().CreateAll(gomock.Any()).Do(func(data []interface{}) {
+		started <- true
+		<-release
+	}).Return(nil).Times(2)
+
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{
+		QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour,
+	})
+
+	// Writer hangs, the queue is full and the third sender blocks
+	first := repo.Log("test_user", "first", nil)
+	<-started
+	second := repo.Log("test_user", "second", nil)
+	third := make(chan chan error, 1)
+	go func() { third <- repo.Log("test_user", "third", nil) }()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, repo.Close(ctx))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, lxAuditRepos.ErrClosed, <-<-third)
+
+	release <- true
+	<-started
+	release <- true
+	assert.NoError(t, <-first)
+	assert.NoError(t, <-second)
+	assert.NoError(t, repo.Close(context.Background()))
+}
+
+func TestAuditBatch_LogContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started, release := make(chan bool, 1), make(chan bool)
+	db := lxGoLibMocks.NewMockIBaseDb(ctrl)
+	db.EXPECT().CreateAll(gomock.Any()).Do(func(data []interface{}) {
+		started <- true
+		<-release
+	}).Return(nil).Times(2)
+
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{
+		QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour,
+	})
+
+	// Writer hangs and the queue is full, the blocked sender gives up with its context
+	first := repo.Log("test_user", "first", nil)
+	<-started
+	second := repo.Log("test_user", "second", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, <-repo.LogContext(ctx, "test_user", "third", nil))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, context.DeadlineExceeded, repo.LogEventSyncContext(ctx, lxAudit.NewEvent("user.login")))
+	assert.Equal(t, uint64(2), repo.Stats().Dropped)
+
+	release <- true
+	<-started
+	release <- true
+	assert.NoError(t, <-first)
+	assert.NoError(t, <-second)
+	assert.NoError(t, repo.Close(context.Background()))
+}
+
+func TestAuditBatch_Policy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	started, release := make(chan bool, 1), make(chan bool)
+	db := lxGoLibMocks.NewMockIBaseDb(ctrl)
+	db.EXPECT().CreateAll(gomock.Any()).Do(func(data []interface{}) {
+		started <- true
+		<-release
+	}).Return(nil).Times(2)
+
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{
+		QueueSize: 1, BatchSize: 1, FlushInterval: time.Hour, Policy: lxAuditRepos.PolicyDrop,
+	})
+
+	// First entry blocks the writer, second fills the queue
+	first := repo.Log("test_user", "first", nil)
+	<-started
+	second := repo.Log("test_user", "second", nil)
+
+	// Rejected entries close their channel like delivered ones
+	dropped := repo.Log("test_user", "third", nil)
+	assert.Equal(t, lxAuditRepos.ErrQueueFull, <-dropped)
+	_, open := <-dropped
+	assert.False(t, open)
+	stats := repo.Stats()
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, 1, stats.Depth)
+
+	// Close times out while the writer is blocked
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, repo.Close(ctx))
+	closed := repo.Log("test_user", "closed", nil)
+	assert.Equal(t, lxAuditRepos.ErrClosed, <-closed)
+	_, open = <-closed
+	assert.False(t, open)
+
+	release <- true
+	<-started
+	release <- true
+	assert.NoError(t, <-first)
+	assert.NoError(t, <-second)
+	assert.NoError(t, repo.Close(context.Background()))
+}
+
+func TestAuditBatch_Close(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{FlushInterval: time.Hour})
+
+	var done []chan error
+	for i := 0; i < 3; i++ {
+		done = append(done, repo.Log("test_user", "pending", nil))
+	}
+	assert.NoError(t, repo.Close(context.Background()))
+
+	for _, d := range done {
+		assert.NoError(t, <-d)
+	}
+	n, err := db.GetCount(bson.M{"message": "pending"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "lx_audit_spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+// flakyDb, memory db failing inserts while down
+type flakyDb struct {
+	*lxDb.MemoryDb
+	down int32
+}
+
+func (db *flakyDb) CreateAll(data []interface{}) error {
+	if atomic.LoadInt32(&db.down) == 1 {
+		return errors.New("no reachable servers")
+	}
+	return db.MemoryDb.CreateAll(data)
+}
+
+func TestAuditBatch_Spool(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	spool, err := lxAuditRepos.OpenSpool(dir, 0, 0)
+	assert.NoError(t, err)
+	defer spool.Close()
+
+	db := &flakyDb{MemoryDb: lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection), down: 1}
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{
+		BatchSize: 2, FlushInterval: 10 * time.Millisecond, Spool: spool,
+	})
+
+	// Database down, entries are durable in the spool
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, repo.LogSync("test_user", fmt.Sprint("message ", i), nil))
+	}
+	stats := repo.Stats()
+	assert.Equal(t, uint64(4), stats.Spooled)
+	assert.Equal(t, int64(4), stats.Spool.Entries)
+
+	// Database up, spool is replayed in order before new entries
+	atomic.StoreInt32(&db.down, 0)
+	assert.NoError(t, repo.LogSync("test_user", "message 4", nil))
+	assert.NoError(t, repo.Close(context.Background()))
+
+	stats = repo.Stats()
+	assert.Equal(t, uint64(4), stats.Replayed)
+	assert.Equal(t, int64(0), stats.Spool.Entries)
+
+	var entries []lxAudit.Event
+	_, err = db.GetAll(nil, &entries, nil)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+	for i, e := range entries {
+		assert.Equal(t, fmt.Sprint("message ", i), e.Message)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	doc := func(i int) interface{} { return bson.M{"_id": i, "message": fmt.Sprint("message ", i)} }
+
+	t.Run("Append, reopen and replay in order", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		spool, err := lxAuditRepos.OpenSpool(dir, 0, 100)
+		assert.NoError(t, err)
+		for i := 0; i < 6; i += 2 {
+			assert.NoError(t, spool.Append([]interface{}{doc(i), doc(i + 1)}))
+		}
+		assert.NoError(t, spool.Close())
+
+		spool, err = lxAuditRepos.OpenSpool(dir, 0, 100)
+		assert.NoError(t, err)
+		defer spool.Close()
+		stats := spool.Stats()
+		assert.Equal(t, int64(6), stats.Entries)
+		assert.True(t, stats.Segments > 1)
+
+		var ids []interface{}
+		n, err := spool.Replay(4, func(docs []interface{}) error {
+			assert.True(t, len(docs) <= 4)
+			for _, d := range docs {
+				ids = append(ids, d.(bson.D).Map()["_id"])
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 6, n)
+		assert.Equal(t, []interface{}{0, 1, 2, 3, 4, 5}, ids)
+		assert.Equal(t, lxAuditRepos.SpoolStats{}, spool.Stats())
+
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		assert.Empty(t, files)
+	})
+
+	t.Run("Failed replay keeps segment", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		spool, err := lxAuditRepos.OpenSpool(dir, 0, 0)
+		assert.NoError(t, err)
+		defer spool.Close()
+		assert.NoError(t, spool.Append([]interface{}{doc(1)}))
+
+		_, err = spool.Replay(10, func(docs []interface{}) error { return errors.New("down") })
+		assert.Error(t, err)
+		assert.Equal(t, int64(1), spool.Stats().Entries)
+
+		// Appends continue after the failed replay
+		assert.NoError(t, spool.Append([]interface{}{doc(2)}))
+		n, err := spool.Replay(10, func(docs []interface{}) error { return nil })
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("Torn tail is cut on open", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		spool, err := lxAuditRepos.OpenSpool(dir, 0, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, spool.Append([]interface{}{doc(1), doc(2)}))
+		assert.NoError(t, spool.Close())
+
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		f, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0600)
+		assert.NoError(t, err)
+		f.Write([]byte{0, 0, 0, 40, 1, 2, 3})
+		f.Close()
+
+		spool, err = lxAuditRepos.OpenSpool(dir, 0, 0)
+		assert.NoError(t, err)
+		defer spool.Close()
+		assert.Equal(t, int64(2), spool.Stats().Entries)
+		assert.Equal(t, uint64(1), spool.Stats().Corrupt)
+
+		assert.NoError(t, spool.Append([]interface{}{doc(3)}))
+		n, err := spool.Replay(10, func(docs []interface{}) error { return nil })
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+
+	t.Run("Corrupt record in the middle is skipped", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		spool, err := lxAuditRepos.OpenSpool(dir, 0, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, spool.Append([]interface{}{doc(1), doc(2), doc(3)}))
+		assert.NoError(t, spool.Close())
+
+		// Flip a payload byte of the second record
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		data, err := ioutil.ReadFile(files[0])
+		assert.NoError(t, err)
+		first := 8 + int(binary.BigEndian.Uint32(data))
+		data[first+20] ^= 0xff
+		assert.NoError(t, ioutil.WriteFile(files[0], data, 0600))
+
+		spool, err = lxAuditRepos.OpenSpool(dir, 0, 0)
+		assert.NoError(t, err)
+		defer spool.Close()
+		assert.Equal(t, int64(2), spool.Stats().Entries)
+
+		var ids []interface{}
+		n, err := spool.Replay(10, func(docs []interface{}) error {
+			for _, d := range docs {
+				ids = append(ids, d.(bson.D).Map()["_id"])
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []interface{}{1, 3}, ids)
+		assert.Equal(t, uint64(1), spool.Stats().Corrupt)
+	})
+
+	t.Run("Size cap", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		spool, err := lxAuditRepos.OpenSpool(dir, 100, 0)
+		assert.NoError(t, err)
+		defer spool.Close()
+
+		assert.NoError(t, spool.Append([]interface{}{doc(1)}))
+		assert.Equal(t, lxAuditRepos.ErrSpoolFull, spool.Append([]interface{}{doc(2), doc(3)}))
+		assert.Equal(t, int64(1), spool.Stats().Entries)
+	})
+}
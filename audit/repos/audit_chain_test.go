@@ -0,0 +1,218 @@
+package lxAuditRepos_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/tests/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditMongo_Chain(t *testing.T) {
+	key := []byte("secret")
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*lxDb.MemoryDb, lxAudit.IAuditRepo) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+		repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost, lxAuditRepos.WithHashChain(key))
+		assert.NoError(t, repo.SetupAudit())
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, repo.LogSync("user", fmt.Sprintf("msg %d", i), bson.M{"i": i}))
+		}
+		return db, repo
+	}
+
+	t.Run("links entries per service", func(t *testing.T) {
+		db, repo := setup(t)
+		assert.NoError(t, repo.LogEventSync(&lxAudit.Event{ServiceName: "other", Action: "x"}))
+
+		var events []lxAudit.Event
+		_, err := db.GetAll(bson.M{"servicename": ServiceName}, &events, &lxDb.Options{Sort: "seq"})
+		assert.NoError(t, err)
+		assert.Len(t, events, 5)
+		for i, e := range events {
+			assert.Equal(t, int64(i+1), e.Seq)
+			if i > 0 {
+				assert.Equal(t, events[i-1].Hash, e.PrevHash)
+			}
+		}
+
+		var other lxAudit.Event
+		assert.NoError(t, db.GetOne(bson.M{"servicename": "other"}, &other))
+		assert.Equal(t, int64(1), other.Seq)
+		assert.Empty(t, other.PrevHash)
+
+		n, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, n)
+
+		n, err = repo.Verify(ctx, ServiceName, 2, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, n)
+	})
+
+	t.Run("continues chain of earlier writer", func(t *testing.T) {
+		db, _ := setup(t)
+		repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost, lxAuditRepos.WithHashChain(key))
+		assert.NoError(t, repo.LogSync("user", "next", nil))
+
+		n, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 6, n)
+	})
+
+	t.Run("retries after concurrent writer", func(t *testing.T) {
+		db, repo := setup(t)
+		other := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost, lxAuditRepos.WithHashChain(key))
+		assert.NoError(t, other.LogSync("user", "other", nil))
+		assert.NoError(t, repo.LogSync("user", "stale head", nil))
+
+		var last lxAudit.Event
+		assert.NoError(t, db.GetOne(bson.M{"message": "stale head"}, &last))
+		assert.Equal(t, int64(7), last.Seq)
+
+		n, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 7, n)
+	})
+
+	t.Run("detects changed entry", func(t *testing.T) {
+		db, repo := setup(t)
+		_, err := db.UpdateAll(bson.M{"seq": 3}, bson.M{"$set": bson.M{"message": "changed"}})
+		assert.NoError(t, err)
+
+		n, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, &lxAudit.ChainError{Service: ServiceName, Seq: 3, Reason: lxAudit.ReasonHash}, err)
+	})
+
+	t.Run("detects missing entry", func(t *testing.T) {
+		db, repo := setup(t)
+		_, err := db.DeleteAll(bson.M{"seq": 2})
+		assert.NoError(t, err)
+
+		_, err = repo.Verify(ctx, ServiceName, 0, 0)
+		assert.Equal(t, &lxAudit.ChainError{Service: ServiceName, Seq: 2, Reason: lxAudit.ReasonMissing}, err)
+
+		_, err = repo.Verify(ctx, ServiceName, 3, 0)
+		assert.Equal(t, &lxAudit.ChainError{Service: ServiceName, Seq: 2, Reason: lxAudit.ReasonMissing}, err)
+	})
+
+	t.Run("detects relinked entry", func(t *testing.T) {
+		db, repo := setup(t)
+		_, err := db.UpdateAll(bson.M{"seq": 4}, bson.M{"$set": bson.M{"prev_hash": "forged"}})
+		assert.NoError(t, err)
+
+		_, err = repo.Verify(ctx, ServiceName, 0, 0)
+		assert.Equal(t, &lxAudit.ChainError{Service: ServiceName, Seq: 4, Reason: lxAudit.ReasonPrevHash}, err)
+	})
+
+	t.Run("needs the signing key", func(t *testing.T) {
+		db, _ := setup(t)
+		repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost, lxAuditRepos.WithHashChain([]byte("wrong")))
+
+		_, err := repo.Verify(ctx, ServiceName, 0, 0)
+		assert.Equal(t, &lxAudit.ChainError{Service: ServiceName, Seq: 1, Reason: lxAudit.ReasonHash}, err)
+	})
+}
+
+func TestAuditBatch_Chain(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost,
+		lxAuditRepos.BatchConfig{BatchSize: 3, FlushInterval: time.Hour}, lxAuditRepos.WithHashChain(nil))
+	assert.NoError(t, repo.SetupAudit())
+
+	for i := 0; i < 7; i++ {
+		repo.Log("user", fmt.Sprintf("msg %d", i), nil)
+	}
+	assert.NoError(t, repo.Close(context.Background()))
+
+	n, err := repo.Verify(context.Background(), ServiceName, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+}
+
+func TestAuditBatch_ChainSpool(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	db := &flakyDb{MemoryDb: lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)}
+	open := func() (*lxAuditRepos.AuditBatch, *lxAuditRepos.Spool) {
+		spool, err := lxAuditRepos.OpenSpool(dir, 0, 0)
+		assert.NoError(t, err)
+		repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{
+			BatchSize: 2, FlushInterval: 10 * time.Millisecond, Spool: spool,
+		}, lxAuditRepos.WithHashChain(nil))
+		assert.NoError(t, repo.SetupAudit())
+		return repo, spool
+	}
+
+	// Two entries in mongo, three in the spool while the database is down
+	repo, spool := open()
+	for i := 0; i < 5; i++ {
+		if i == 2 {
+			atomic.StoreInt32(&db.down, 1)
+		}
+		assert.NoError(t, repo.LogSync("test_user", fmt.Sprint("message ", i), nil))
+	}
+	assert.NoError(t, repo.Close(ctx))
+	assert.NoError(t, spool.Close())
+	assert.Equal(t, int64(3), repo.Stats().Spool.Entries)
+
+	// Restart, new entries wait behind the spool and are linked at replay
+	atomic.StoreInt32(&db.down, 0)
+	repo, spool = open()
+	defer spool.Close()
+	for i := 5; i < 7; i++ {
+		assert.NoError(t, repo.LogSync("test_user", fmt.Sprint("message ", i), nil))
+	}
+	assert.NoError(t, repo.Close(ctx))
+
+	var entries []lxAudit.Event
+	_, err := db.GetAll(nil, &entries, &lxDb.Options{Sort: "seq"})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 7)
+	for i, e := range entries {
+		assert.Equal(t, int64(i+1), e.Seq)
+		assert.Equal(t, fmt.Sprint("message ", i), e.Message)
+	}
+
+	n, err := repo.Verify(ctx, ServiceName, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+}
+
+func TestAuditBatch_ReplayDuplicate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	spool, err := lxAuditRepos.OpenSpool(dir, 0, 0)
+	assert.NoError(t, err)
+	defer spool.Close()
+
+	db := &flakyDb{MemoryDb: lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection), down: 1}
+	assert.NoError(t, db.Setup([]mgo.Index{{Key: []string{"message"}, Unique: true}}))
+	assert.NoError(t, db.MemoryDb.Create(bson.M{"message": "taken"}))
+
+	repo := lxAuditRepos.NewAuditBatch(db, ServiceName, ServiceHost, lxAuditRepos.BatchConfig{
+		FlushInterval: 5 * time.Millisecond, Spool: spool,
+	})
+	assert.NoError(t, repo.LogSync("test_user", "taken", nil))
+	atomic.StoreInt32(&db.down, 0)
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, repo.Close(context.Background()))
+
+	// Only ids of earlier replays are skipped, the entry stays in the spool
+	assert.Equal(t, int64(1), repo.Stats().Spool.Entries)
+	assert.Equal(t, uint64(0), repo.Stats().Replayed)
+}
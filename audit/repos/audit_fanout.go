@@ -0,0 +1,216 @@
+package lxAuditRepos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/litixsoft/lx-golib/audit"
+)
+
+const (
+	// DefaultSinkTimeout, time a fan-out waits for a sink to log an entry
+	DefaultSinkTimeout = 10 * time.Second
+
+	// MaxSinkPending, calls a sink may have running after timeouts,
+	// more entries for the sink fail with ErrSinkBusy until it returns
+	MaxSinkPending = 64
+)
+
+var (
+	// ErrSinkTimeout, sink didn't log the entry in time, it may still be written
+	ErrSinkTimeout = errors.New("audit sink timed out")
+
+	// ErrSinkBusy, sink has MaxSinkPending calls running, entry dropped for the sink
+	ErrSinkBusy = errors.New("audit sink is busy, entry dropped")
+)
+
+// FanOutError, errors of the failed sinks by sink index
+type FanOutError struct {
+	Errors map[int]error
+}
+
+func (e *FanOutError) Error() string {
+	idx := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+
+	msgs := make([]string, len(idx))
+	for n, i := range idx {
+		msgs[n] = fmt.Sprintf("sink %d: %v", i, e.Errors[i])
+	}
+
+	return "audit fan-out failed, " + strings.Join(msgs, "; ")
+}
+
+// AuditFanOut, audit backend writing every entry to all sinks,
+// a failing, panicking or slow sink doesn't stop the others
+type AuditFanOut struct {
+	Timeout time.Duration // Per sink and entry, default DefaultSinkTimeout
+
+	sinks []fanOutSink
+}
+
+// fanOutSink, sink with its running calls
+type fanOutSink struct {
+	lxAudit.IAudit
+	pending chan struct{}
+}
+
+// NewAuditFanOut, return audit backend writing to sinks
+func NewAuditFanOut(sinks ...lxAudit.IAudit) *AuditFanOut {
+	repo := &AuditFanOut{Timeout: DefaultSinkTimeout}
+	for _, sink := range sinks {
+		repo.sinks = append(repo.sinks, fanOutSink{IAudit: sink, pending: make(chan struct{}, MaxSinkPending)})
+	}
+
+	return repo
+}
+
+// SetupAudit, setup all sinks and wait for each, returns a *FanOutError for failed sinks
+func (repo *AuditFanOut) SetupAudit() error {
+	return repo.SetupAuditContext(context.Background())
+}
+
+// SetupAuditContext, setup all sinks and wait for each until ctx is done,
+// returns a *FanOutError for failed sinks
+func (repo *AuditFanOut) SetupAuditContext(ctx context.Context) error {
+	return repo.each(ctx, 0, func(ctx context.Context, sink lxAudit.IAudit) error {
+		return sink.SetupAuditContext(ctx)
+	})
+}
+
+// Log, write log entry to all sinks, the channel delivers a *FanOutError for failed sinks or nil
+func (repo *AuditFanOut) Log(user, message, data interface{}) chan error {
+	return repo.LogContext(context.Background(), user, message, data)
+}
+
+// LogContext, write log entry to all sinks, the channel delivers a *FanOutError for failed sinks or nil
+func (repo *AuditFanOut) LogContext(ctx context.Context, user, message, data interface{}) chan error {
+	return repo.LogEventContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogSync, write log entry to all sinks and wait, returns a *FanOutError for failed sinks
+func (repo *AuditFanOut) LogSync(user, message, data interface{}) error {
+	return repo.LogSyncContext(context.Background(), user, message, data)
+}
+
+// LogSyncContext, write log entry to all sinks and wait, returns a *FanOutError for failed sinks
+func (repo *AuditFanOut) LogSyncContext(ctx context.Context, user, message, data interface{}) error {
+	return repo.LogEventSyncContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogEvent, write event to all sinks, the channel delivers a *FanOutError for failed sinks or nil
+func (repo *AuditFanOut) LogEvent(event *lxAudit.Event) chan error {
+	return repo.LogEventContext(context.Background(), event)
+}
+
+// LogEventContext, write event to all sinks, the channel delivers a *FanOutError for failed sinks or nil
+func (repo *AuditFanOut) LogEventContext(ctx context.Context, event *lxAudit.Event) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- repo.LogEventSyncContext(ctx, event)
+		close(done)
+	}()
+
+	return done
+}
+
+// LogEventSync, write event to all sinks and wait up to Timeout per sink,
+// returns a *FanOutError for failed sinks
+func (repo *AuditFanOut) LogEventSync(event *lxAudit.Event) error {
+	return repo.LogEventSyncContext(context.Background(), event)
+}
+
+// LogEventSyncContext, write event to all sinks and wait up to Timeout per sink or until
+// ctx is done, returns a *FanOutError for failed sinks
+func (repo *AuditFanOut) LogEventSyncContext(ctx context.Context, event *lxAudit.Event) error {
+	timeout := repo.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSinkTimeout
+	}
+
+	return repo.each(ctx, timeout, func(ctx context.Context, sink lxAudit.IAudit) error {
+		// Own copy per sink, sinks may fill in their defaults
+		e := *event
+		return sink.LogEventSyncContext(ctx, &e)
+	})
+}
+
+// each, run fn for all sinks in parallel and collect the errors, waits up to timeout
+// per sink (0 without limit) or until ctx is done, the sinks get a context ending then,
+// calls of sinks ignoring it keep running in the background
+func (repo *AuditFanOut) each(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, sink lxAudit.IAudit) error) error {
+	// One slot per sink, goroutines never share a write
+	var wg sync.WaitGroup
+	results := make([]error, len(repo.sinks))
+
+	for i, sink := range repo.sinks {
+		// A hung sink holds at most MaxSinkPending goroutines
+		select {
+		case sink.pending <- struct{}{}:
+		default:
+			results[i] = ErrSinkBusy
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, sink fanOutSink) {
+			defer wg.Done()
+
+			sinkCtx, cancel := ctx, context.CancelFunc(func() {})
+			if timeout > 0 {
+				sinkCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			defer cancel()
+
+			res := make(chan error, 1)
+			go func() {
+				defer func() { <-sink.pending }()
+				res <- call(sinkCtx, fn, sink.IAudit)
+			}()
+
+			select {
+			case results[i] = <-res:
+			case <-sinkCtx.Done():
+				results[i] = sinkCtx.Err()
+			}
+
+			// Sinks stopped by the own timeout report ErrSinkTimeout, not the context error
+			if results[i] != nil && ctx.Err() == nil && sinkCtx.Err() != nil {
+				results[i] = ErrSinkTimeout
+			}
+		}(i, sink)
+	}
+	wg.Wait()
+
+	errs := map[int]error{}
+	for i, err := range results {
+		if err != nil {
+			errs[i] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return &FanOutError{Errors: errs}
+	}
+
+	return nil
+}
+
+// call, run fn and return a panic of the sink as error
+func call(ctx context.Context, fn func(ctx context.Context, sink lxAudit.IAudit) error, sink lxAudit.IAudit) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fn(ctx, sink)
+}
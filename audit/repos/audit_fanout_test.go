@@ -0,0 +1,178 @@
+package lxAuditRepos_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/mocks"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/tests/fixtures"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditFanOut(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	var buf bytes.Buffer
+	failing := lxAuditMocks.NewMockIAudit(mockCtrl)
+	panicking := lxAuditMocks.NewMockIAudit(mockCtrl)
+
+	repo := lxAuditRepos.NewAuditFanOut(
+		lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost),
+		failing,
+		lxAuditRepos.NewAuditStream(&buf, ServiceName, ServiceHost),
+		panicking,
+	)
+
+	t.Run("setup all sinks", func(t *testing.T) {
+		failing.EXPECT().SetupAuditContext(gomock.Any()).Return(nil)
+		panicking.EXPECT().SetupAuditContext(gomock.Any()).Return(nil)
+		assert.NoError(t, repo.SetupAudit())
+	})
+
+	t.Run("failed sinks don't stop the others", func(t *testing.T) {
+		failing.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).Return(errors.New("disk full"))
+		panicking.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, *lxAudit.Event) error {
+			panic("boom")
+		})
+
+		err := <-repo.Log("test_user", "a audit message", nil)
+		assert.IsType(t, &lxAuditRepos.FanOutError{}, err)
+		errs := err.(*lxAuditRepos.FanOutError).Errors
+		assert.Len(t, errs, 2)
+		assert.EqualError(t, errs[1], "disk full")
+		assert.EqualError(t, errs[3], "panic: boom")
+		assert.Equal(t, "audit fan-out failed, sink 1: disk full; sink 3: panic: boom", err.Error())
+
+		n, err := db.GetCount(bson.M{"message": "a audit message"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Contains(t, buf.String(), "a audit message")
+	})
+
+	t.Run("nil when all sinks succeed", func(t *testing.T) {
+		failing.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).Return(nil)
+		panicking.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).Return(nil)
+		assert.NoError(t, repo.LogEventSync(lxAudit.NewEvent("user.login")))
+	})
+}
+
+// blockingSink, hung sink, blocks every entry until release is closed and ignores ctx
+type blockingSink struct {
+	lxAudit.IAudit
+	release chan struct{}
+}
+
+func (s *blockingSink) LogEventSyncContext(ctx context.Context, event *lxAudit.Event) error {
+	<-s.release
+	return nil
+}
+
+func TestAuditFanOut_BlockingSink(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	blocking := &blockingSink{release: make(chan struct{})}
+	repo := lxAuditRepos.NewAuditFanOut(lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost), blocking)
+	repo.Timeout = 20 * time.Millisecond
+
+	t.Run("timeout doesn't wait for the blocking sink", func(t *testing.T) {
+		start := time.Now()
+		err := repo.LogSync("test_user", "a audit message", nil)
+		assert.True(t, time.Since(start) < time.Second)
+		assert.Equal(t, &lxAuditRepos.FanOutError{Errors: map[int]error{1: lxAuditRepos.ErrSinkTimeout}}, err)
+
+		n, err := db.GetCount(bson.M{"message": "a audit message"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("busy sink drops entries without waiting", func(t *testing.T) {
+		var done []chan error
+		for i := 1; i < lxAuditRepos.MaxSinkPending; i++ {
+			done = append(done, repo.LogEvent(lxAudit.NewEvent("user.login")))
+		}
+		for _, d := range done {
+			<-d
+		}
+
+		start := time.Now()
+		err := repo.LogEventSync(lxAudit.NewEvent("user.login"))
+		assert.True(t, time.Since(start) < repo.Timeout)
+		assert.Equal(t, &lxAuditRepos.FanOutError{Errors: map[int]error{1: lxAuditRepos.ErrSinkBusy}}, err)
+
+		n, err := db.GetCount(bson.M{"action": "user.login"})
+		assert.NoError(t, err)
+		assert.Equal(t, lxAuditRepos.MaxSinkPending, n)
+	})
+
+	t.Run("released sink takes entries again", func(t *testing.T) {
+		close(blocking.release)
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(t, repo.LogEventSync(lxAudit.NewEvent("user.logout")))
+	})
+}
+
+func TestAuditFanOut_LogContext(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	blocking := &blockingSink{release: make(chan struct{})}
+	defer close(blocking.release)
+	repo := lxAuditRepos.NewAuditFanOut(lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost), blocking)
+
+	t.Run("done context doesn't wait for the blocking sink", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := repo.LogSyncContext(ctx, "test_user", "a audit message", nil)
+		assert.True(t, time.Since(start) < time.Second)
+		assert.Equal(t, &lxAuditRepos.FanOutError{Errors: map[int]error{1: context.DeadlineExceeded}}, err)
+
+		n, err := db.GetCount(bson.M{"message": "a audit message"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+}
+
+func TestAuditFanOut_BusyAndFailingSinks(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	failing := lxAuditMocks.NewMockIAudit(mockCtrl)
+	failing.EXPECT().LogEventSyncContext(gomock.Any(), gomock.Any()).Return(errors.New("disk full")).AnyTimes()
+	blocking := &blockingSink{release: make(chan struct{})}
+	defer close(blocking.release)
+
+	// The failing sink runs while the busy sink is reported
+	repo := lxAuditRepos.NewAuditFanOut(failing, blocking)
+	repo.Timeout = 10 * time.Millisecond
+
+	// Fill the pending calls of the hung sink
+	var done []chan error
+	for i := 0; i < lxAuditRepos.MaxSinkPending; i++ {
+		done = append(done, repo.LogEvent(lxAudit.NewEvent("user.login")))
+	}
+	for _, d := range done {
+		<-d
+	}
+
+	// Busy and failing sinks are reported together
+	done = nil
+	for i := 0; i < 10; i++ {
+		done = append(done, repo.LogEvent(lxAudit.NewEvent("user.login")))
+	}
+	for _, d := range done {
+		err := <-d
+		assert.Equal(t, &lxAuditRepos.FanOutError{Errors: map[int]error{
+			0: errors.New("disk full"),
+			1: lxAuditRepos.ErrSinkBusy,
+		}}, err)
+	}
+}
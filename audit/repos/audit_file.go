@@ -0,0 +1,272 @@
+package lxAuditRepos
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFileMaxBytes = 100 << 20
+
+	// rotateTimeFormat, suffix of rotated files, sorts by time
+	rotateTimeFormat = "20060102T150405.000"
+)
+
+// FileConfig, rotation of audit files
+type FileConfig struct {
+	MaxBytes   int64         // Rotate before a line exceeds the size, default DefaultFileMaxBytes
+	MaxAge     time.Duration // Rotate files opened longer than MaxAge, 0 disables
+	Compress   bool          // Gzip rotated files
+	MaxBackups int           // Remove the oldest rotated files above MaxBackups, 0 keeps all
+}
+
+// NewAuditFile, return audit backend writing json lines to the file at path,
+// rotated files are renamed to name-<time>.ext, Close the backend to finish compressions
+func NewAuditFile(path, serviceName, serviceHost string, config FileConfig) (*AuditStream, error) {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultFileMaxBytes
+	}
+
+	f := &rotateFile{path: path, config: config}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+
+	return NewAuditStream(f, serviceName, serviceHost), nil
+}
+
+// rotateFile, append-only file with size and time based rotation,
+// the caller serializes Write and Close
+type rotateFile struct {
+	path   string
+	config FileConfig
+
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	bgMu sync.Mutex // serializes compression and cleanup of rotated files
+	bg   sync.WaitGroup
+}
+
+// open, open or create the file for appending, the age of an existing file starts now
+func (f *rotateFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+
+	return nil
+}
+
+// Write, append p, rotates first when p would exceed the size or the file is too old
+func (f *rotateFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.size > 0 && (f.size+int64(len(p)) > f.config.MaxBytes ||
+		f.config.MaxAge > 0 && time.Since(f.opened) >= f.config.MaxAge) {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			// Rename failed, keep writing to the reopened file
+			log.Printf("audit can't rotate %s, error: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close, close the file and wait for running compressions
+func (f *rotateFile) Close() error {
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.bg.Wait()
+
+	return err
+}
+
+// rotate, rename the file and open a new one, compression and cleanup run in the background
+func (f *rotateFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	name := f.rotatedName(time.Now())
+	if err := os.Rename(f.path, name); err != nil {
+		if oerr := f.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+
+	f.bg.Add(1)
+	go func() {
+		defer f.bg.Done()
+		f.bgMu.Lock()
+		defer f.bgMu.Unlock()
+
+		if f.config.Compress {
+			if err := compressFile(name); err != nil {
+				log.Printf("audit can't compress %s, error: %v\n", name, err)
+			}
+		}
+		if err := f.prune(); err != nil {
+			log.Printf("audit can't remove rotated files of %s, error: %v\n", f.path, err)
+		}
+	}()
+
+	return f.open()
+}
+
+// rotatedName, unused name for the file rotated at t
+func (f *rotateFile) rotatedName(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext) + "-" + t.Format(rotateTimeFormat)
+
+	name := base + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+
+	return name
+}
+
+// rotatedFile, rotated file with its rotation time and collision counter
+type rotatedFile struct {
+	name string
+	time time.Time
+	n    int
+}
+
+// rotated, rotated files of this file oldest first, files of other names
+// sharing the prefix like name-billing.ext are not matched
+func (f *rotateFile) rotated() ([]string, error) {
+	ext := filepath.Ext(f.path)
+	base := filepath.Base(strings.TrimSuffix(f.path, ext))
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(base) + `-(\d{8}T\d{6}\.\d{3})(?:-(\d+))?` +
+		regexp.QuoteMeta(ext) + `(?:\.gz)?$`)
+
+	entries, err := ioutil.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rotatedFile
+	for _, e := range entries {
+		m := re.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		t, err := time.Parse(rotateTimeFormat, m[1])
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		files = append(files, rotatedFile{name: filepath.Join(filepath.Dir(f.path), e.Name()), time: t, n: n})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].time.Equal(files[j].time) {
+			return files[i].time.Before(files[j].time)
+		}
+		return files[i].n < files[j].n
+	})
+
+	names := make([]string, len(files))
+	for i, rf := range files {
+		names[i] = rf.name
+	}
+
+	return names, nil
+}
+
+// prune, remove the oldest rotated files above MaxBackups
+func (f *rotateFile) prune() error {
+	if f.config.MaxBackups <= 0 {
+		return nil
+	}
+
+	names, err := f.rotated()
+	if err != nil {
+		return err
+	}
+	for len(names) > f.config.MaxBackups {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+
+	return nil
+}
+
+// compressFile, gzip name to name.gz and remove name
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// exists, file name exists
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,166 @@
+package lxAuditRepos_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditStream(t *testing.T) {
+	var buf bytes.Buffer
+	repo := lxAuditRepos.NewAuditStream(&buf, ServiceName, ServiceHost)
+	assert.NoError(t, repo.SetupAudit())
+
+	assert.NoError(t, <-repo.Log("test_user", "first", map[string]int{"n": 1}))
+	assert.NoError(t, repo.LogEventSync(lxAudit.NewEvent("user.login").WithActor("7", "user")))
+	assert.NoError(t, repo.Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var first, second lxAudit.Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "first", first.Message)
+	assert.Equal(t, ServiceName, first.ServiceName)
+	assert.Equal(t, ServiceHost, first.ServiceHost)
+	assert.False(t, first.TimeStamp.IsZero())
+	assert.Equal(t, "user.login", second.Action)
+	assert.Equal(t, "7", second.ActorId)
+}
+
+func TestAuditFile(t *testing.T) {
+	t.Run("rotates by size and compresses", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "logs", "audit.log")
+
+		repo, err := lxAuditRepos.NewAuditFile(path, ServiceName, ServiceHost, lxAuditRepos.FileConfig{MaxBytes: 400, Compress: true})
+		assert.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+		}
+		assert.NoError(t, repo.Close())
+
+		rotated, _ := filepath.Glob(filepath.Join(dir, "logs", "audit-*.log.gz"))
+		assert.NotEmpty(t, rotated)
+		plain, _ := filepath.Glob(filepath.Join(dir, "logs", "audit-*.log"))
+		assert.Empty(t, plain)
+
+		// No entry is lost or cut
+		n := countLines(t, path, false)
+		for _, name := range rotated {
+			n += countLines(t, name, true)
+		}
+		assert.Equal(t, 10, n)
+	})
+
+	t.Run("rotates by age and keeps backups", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		repo, err := lxAuditRepos.NewAuditFile(path, ServiceName, ServiceHost, lxAuditRepos.FileConfig{MaxAge: 5 * time.Millisecond, MaxBackups: 2})
+		assert.NoError(t, err)
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.NoError(t, repo.Close())
+
+		rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*.log"))
+		assert.Len(t, rotated, 2)
+		assert.Equal(t, 1, countLines(t, path, false))
+	})
+
+	t.Run("prunes only own rotations by time", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		others := []string{"audit-billing.log", "audit-billing-20200101T000000.000.log", "audit-20200101T000000.000.txt"}
+		old := []string{"audit-20200101T000000.000.log", "audit-20200101T000000.000-1.log.gz"}
+		for _, name := range append(others, old...) {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("{}\n"), 0644))
+		}
+
+		repo, err := lxAuditRepos.NewAuditFile(path, ServiceName, ServiceHost, lxAuditRepos.FileConfig{MaxBytes: 300, MaxBackups: 2})
+		assert.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+		}
+		assert.NoError(t, repo.Close())
+
+		for _, name := range others {
+			assert.FileExists(t, filepath.Join(dir, name))
+		}
+		_, err = os.Stat(filepath.Join(dir, old[0]))
+		assert.True(t, os.IsNotExist(err))
+		assert.FileExists(t, filepath.Join(dir, old[1]))
+	})
+
+	t.Run("keeps writing when rename fails", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		repo, err := lxAuditRepos.NewAuditFile(path, ServiceName, ServiceHost, lxAuditRepos.FileConfig{MaxBytes: 300})
+		assert.NoError(t, err)
+		assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+
+		// File removed underneath, rotation can't rename it
+		assert.NoError(t, os.Remove(path))
+		assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+		assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+		assert.NoError(t, repo.Close())
+
+		assert.True(t, countLines(t, path, false) >= 1)
+	})
+
+	t.Run("appends to existing file", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "audit.log")
+
+		for i := 0; i < 2; i++ {
+			repo, err := lxAuditRepos.NewAuditFile(path, ServiceName, ServiceHost, lxAuditRepos.FileConfig{})
+			assert.NoError(t, err)
+			assert.NoError(t, repo.LogSync("test_user", "a audit message", nil))
+			assert.NoError(t, repo.Close())
+		}
+		assert.Equal(t, 2, countLines(t, path, false))
+	})
+}
+
+// countLines, count json lines of a plain or gzip file
+func countLines(t *testing.T, name string, gz bool) int {
+	data, err := ioutil.ReadFile(name)
+	assert.NoError(t, err)
+	if gz {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		assert.NoError(t, err)
+		data, err = ioutil.ReadAll(zr)
+		assert.NoError(t, err)
+	}
+
+	n := 0
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var e lxAudit.Event
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		n++
+	}
+
+	return n
+}
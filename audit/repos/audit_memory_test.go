@@ -0,0 +1,292 @@
+package lxAuditRepos_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/globalsign/mgo/bson"
+	"github.com/golang/mock/gomock"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/helper"
+	"github.com/litixsoft/lx-golib/tests/fixtures"
+	"github.com/litixsoft/lx-golib/tests/mocks"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditMongo_LogMemory(t *testing.T) {
+	t.Run("Log entry to memory db", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+		repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+		assert.NoError(t, repo.SetupAudit())
+
+		<-repo.Log("test_user", "a audit message", lxHelper.M{"name": "test_name"})
+
+		var result lxAudit.AuditModel
+		assert.NoError(t, db.GetOne(bson.M{"user": "test_user"}, &result))
+		assert.Equal(t, ServiceName, result.ServiceName)
+		assert.Equal(t, ServiceHost, result.ServiceHost)
+		assert.Equal(t, "a audit message", result.Message)
+		assert.Equal(t, bson.M{"name": "test_name"}, result.Data)
+	})
+}
+
+func TestAuditMongo_LogContextMemory(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("Done context stops setup and writes", func(t *testing.T) {
+		assert.Equal(t, context.Canceled, repo.SetupAuditContext(ctx))
+		assert.Equal(t, context.Canceled, repo.LogSyncContext(ctx, "test_user", "a audit message", nil))
+		assert.Equal(t, context.Canceled, <-repo.LogEventContext(ctx, lxAudit.NewEvent("user.login")))
+
+		n, err := db.GetCount(bson.M{})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestAuditMongo_LogEventMemory(t *testing.T) {
+	t.Run("Log event to memory db", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+		repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+
+		<-repo.LogEvent(lxAudit.NewEvent("user.login").WithActor("7", "user").Succeeded())
+
+		var result lxAudit.Event
+		assert.NoError(t, db.GetOne(bson.M{"action": "user.login"}, &result))
+		assert.Equal(t, "7", result.ActorId)
+		assert.Equal(t, lxAudit.OutcomeSuccess, result.Outcome)
+		assert.Equal(t, ServiceName, result.ServiceName)
+		assert.False(t, result.TimeStamp.IsZero())
+
+		// Legacy entries and events share the user field
+		var legacy lxAudit.AuditModel
+		assert.NoError(t, db.GetOne(bson.M{"user": "7"}, &legacy))
+		assert.Equal(t, ServiceHost, legacy.ServiceHost)
+	})
+}
+
+// setupAuditMemory, memory repo with entries of two services one minute apart
+func setupAuditMemory(t *testing.T) (lxAudit.IAuditRepo, time.Time) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	db.CursorKey = []byte("secret")
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+	if err := repo.SetupAudit(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		event := lxAudit.NewEvent("user.update").WithActor(fmt.Sprint(i%2), "user").WithResource("user", fmt.Sprint(i))
+		event.TimeStamp = start.Add(time.Duration(i) * time.Minute)
+		if i >= 8 {
+			event.ServiceName = "other"
+			event.Action = "user.delete"
+		}
+		<-repo.LogEvent(event)
+	}
+
+	return repo, start
+}
+
+func TestAuditMongo_FindMemory(t *testing.T) {
+	ctx := context.Background()
+	repo, start := setupAuditMemory(t)
+
+	t.Run("Filter by time range and actor, newest first", func(t *testing.T) {
+		events, n, err := repo.Find(ctx, &lxAudit.Filter{
+			From:    start.Add(2 * time.Minute),
+			To:      start.Add(6 * time.Minute),
+			ActorId: "0",
+		}, &lxDb.Options{Count: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, "4", events[0].ResourceId)
+		assert.Equal(t, "2", events[1].ResourceId)
+	})
+
+	t.Run("Filter by action, resource and service", func(t *testing.T) {
+		events, _, err := repo.Find(ctx, &lxAudit.Filter{Action: "user.delete", ServiceName: "other"}, nil)
+		assert.NoError(t, err)
+		assert.Len(t, events, 2)
+
+		events, _, err = repo.Find(ctx, &lxAudit.Filter{ResourceType: "user", ResourceId: "3"}, nil)
+		assert.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("Paginate with skip, limit and cursor", func(t *testing.T) {
+		events, _, err := repo.Find(ctx, nil, &lxDb.Options{Skip: 2, Limit: 3, Sort: "timestamp"})
+		assert.NoError(t, err)
+		assert.Len(t, events, 3)
+		assert.Equal(t, "2", events[0].ResourceId)
+
+		events, page, err := repo.FindPage(ctx, nil, &lxDb.Options{Limit: 4})
+		assert.NoError(t, err)
+		assert.Equal(t, "9", events[0].ResourceId)
+		assert.NotEmpty(t, page.Next)
+
+		events, _, err = repo.FindPage(ctx, nil, &lxDb.Options{Limit: 4, Cursor: page.Next})
+		assert.NoError(t, err)
+		assert.Equal(t, "5", events[0].ResourceId)
+	})
+}
+
+func TestAuditMongo_ExportMemory(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := setupAuditMemory(t)
+	lxAuditRepos.ExportBatchSize = 3
+	defer func() { lxAuditRepos.ExportBatchSize = 1000 }()
+
+	t.Run("Export json lines in time order", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := repo.Export(ctx, &buf, &lxAudit.Filter{ServiceName: ServiceName}, lxAudit.FormatJSONL)
+		assert.NoError(t, err)
+		assert.Equal(t, 8, n)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Len(t, lines, 8)
+
+		var first lxAudit.Event
+		assert.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.Equal(t, "0", first.ResourceId)
+	})
+
+	t.Run("Export csv with header", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := repo.Export(ctx, &buf, nil, lxAudit.FormatCSV)
+		assert.NoError(t, err)
+		assert.Equal(t, 10, n)
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, rows, 11)
+		assert.Equal(t, lxAudit.CSVHeader, rows[0])
+		assert.Equal(t, "2018-05-01T12:00:00Z", rows[1][0])
+		assert.Equal(t, "user.update", rows[1][5])
+	})
+
+	t.Run("Unknown format", func(t *testing.T) {
+		_, err := repo.Export(ctx, ioutil.Discard, nil, "xml")
+		assert.Error(t, err)
+	})
+}
+
+func TestAuditMongo_ExportCSVFormulaMemory(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+	assert.NoError(t, <-repo.LogEvent(lxAudit.NewEvent("user.login").WithActor("@admin", "user").WithMessage("=HYPERLINK(\"x\")")))
+
+	var buf bytes.Buffer
+	n, err := repo.Export(context.Background(), &buf, nil, lxAudit.FormatCSV)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, "'@admin", rows[1][3])
+	assert.Equal(t, "'=HYPERLINK(\"x\")", rows[1][12])
+	assert.Equal(t, "user.login", rows[1][5])
+}
+
+// failingExportDb, fails reads after the first call
+type failingExportDb struct {
+	lxDb.IBaseDb
+	calls int
+}
+
+func (db *failingExportDb) GetAllContext(ctx context.Context, query interface{}, result interface{}, opts *lxDb.Options) (int, error) {
+	db.calls++
+	if db.calls > 1 {
+		return 0, errors.New("read failed")
+	}
+	return db.IBaseDb.GetAllContext(ctx, query, result, opts)
+}
+
+func TestAuditMongo_ExportErrorMemory(t *testing.T) {
+	memory := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, memory.Create(&lxAudit.Event{ServiceName: ServiceName, Action: "user.update", TimeStamp: time.Now()}))
+	}
+
+	lxAuditRepos.ExportBatchSize = 2
+	defer func() { lxAuditRepos.ExportBatchSize = 1000 }()
+
+	t.Run("Rows before a read error are flushed", func(t *testing.T) {
+		repo := lxAuditRepos.NewAuditMongo(&failingExportDb{IBaseDb: memory}, ServiceName, ServiceHost)
+
+		var buf bytes.Buffer
+		n, err := repo.Export(context.Background(), &buf, nil, lxAudit.FormatCSV)
+		assert.EqualError(t, err, "read failed")
+		assert.Equal(t, 2, n)
+
+		rows, err := csv.NewReader(&buf).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, rows, 3)
+	})
+	t.Run("Writer error is reported", func(t *testing.T) {
+		repo := lxAuditRepos.NewAuditMongo(memory, ServiceName, ServiceHost)
+
+		_, err := repo.Export(context.Background(), errWriter{}, nil, lxAudit.FormatCSV)
+		assert.EqualError(t, err, "write failed")
+	})
+}
+
+// errWriter, fails every write
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestAuditMongo_LogError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	failure := errors.New("no reachable servers")
+	db := lxGoLibMocks.NewMockIBaseDb(ctrl)
+	db.EXPECT().CreateContext(gomock.Any(), gomock.Any()).Return(failure).Times(2)
+	db.EXPECT().CreateContext(gomock.Any(), gomock.Any()).Return(nil)
+
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost)
+
+	t.Run("Channel delivers insert error", func(t *testing.T) {
+		assert.Equal(t, failure, <-repo.Log("test_user", "a audit message", nil))
+	})
+	t.Run("LogSync returns insert error", func(t *testing.T) {
+		assert.Equal(t, failure, repo.LogSync("test_user", "a audit message", nil))
+	})
+	t.Run("Channel delivers nil on success", func(t *testing.T) {
+		assert.NoError(t, <-repo.LogEvent(lxAudit.NewEvent("user.login")))
+	})
+}
+
+func TestAuditMongo_RedactMemory(t *testing.T) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), fixtures.TestDbName, AuditCollection)
+	r, err := lxAudit.NewRedactor(lxAudit.DefaultKeyRules...)
+	assert.NoError(t, err)
+	repo := lxAuditRepos.NewAuditMongo(db, ServiceName, ServiceHost, lxAuditRepos.WithRedactor(r), lxAuditRepos.WithHashChain(nil))
+
+	assert.NoError(t, repo.LogSync("test_user", "signup", bson.M{"name": "alice", "password": "geheim"}))
+
+	var result lxAudit.Event
+	assert.NoError(t, db.GetOne(bson.M{"user": "test_user"}, &result))
+	assert.Equal(t, bson.M{"name": "alice", "password": lxAudit.DefaultMask}, result.Data)
+
+	// Chain hashes cover the redacted data
+	n, err := repo.Verify(context.Background(), ServiceName, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
@@ -1,66 +1,468 @@
 package lxAuditRepos
 
 import (
+	"context"
 	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 	"github.com/litixsoft/lx-golib/audit"
 	"github.com/litixsoft/lx-golib/db"
+	"io"
 	"log"
+	"sync"
 	"time"
 )
 
+// ExportBatchSize, entries read per page by Export
+var ExportBatchSize = 1000
+
+// chainRetries, inserts of a chained entry when another writer took its sequence
+const chainRetries = 3
+
 // auditMongo, mongo repository
 type auditMongo struct {
 	serviceName string
 	serviceHost string
-	db          *lxDb.MongoDb
+	db          lxDb.IBaseDb
+	chain       *chain
+	redactor    *lxAudit.Redactor
+	ttl         time.Duration
+}
+
+// Option, configure the audit repository
+type Option func(repo *auditMongo)
+
+// WithHashChain, link each entry to the previous entry of its service with a hash chain,
+// entries are signed with HMAC-SHA256 when key is set, Verify needs the same key
+func WithHashChain(key []byte) Option {
+	return func(repo *auditMongo) {
+		repo.chain = &chain{key: key, heads: map[string]*link{}}
+	}
+}
+
+// WithRedactor, redact the data of entries before they are written and chained
+func WithRedactor(redactor *lxAudit.Redactor) Option {
+	return func(repo *auditMongo) {
+		repo.redactor = redactor
+	}
 }
 
-// NewAuditMongo, return instance of auditMongo repository
-func NewAuditMongo(db *lxDb.MongoDb, serviceName, serviceHost string) lxAudit.IAudit {
-	return &auditMongo{db: db, serviceName: serviceName, serviceHost: serviceHost}
+// WithTTL, SetupAudit sets a TTL index, mongo removes entries older than ttl,
+// a db with MigrateIndexes changes an existing timestamp index, use an Archiver to keep old entries,
+// expired entries break hash chains, Verify reports their links as lxAudit.ReasonMissing
+func WithTTL(ttl time.Duration) Option {
+	return func(repo *auditMongo) {
+		repo.ttl = ttl
+	}
+}
+
+// indexMigrator, db changing existing indexes like lxDb.MongoDb
+type indexMigrator interface {
+	MigrateIndexes(version string, desired []mgo.Index) (*lxDb.IndexPlan, error)
+}
+
+// chain, last link per service name
+type chain struct {
+	key   []byte
+	mu    sync.Mutex
+	heads map[string]*link
+}
+
+// link, sequence and hash of an entry
+type link struct {
+	seq  int64
+	hash string
+}
+
+// NewAuditMongo, return instance of auditMongo repository,
+// db can be a lxDb.MongoDb or a lxDb.MemoryDb for tests
+func NewAuditMongo(db lxDb.IBaseDb, serviceName, serviceHost string, opts ...Option) lxAudit.IAuditRepo {
+	return newAuditMongo(db, serviceName, serviceHost, opts)
+}
+
+// newAuditMongo, return configured auditMongo repository
+func newAuditMongo(db lxDb.IBaseDb, serviceName, serviceHost string, opts []Option) *auditMongo {
+	repo := &auditMongo{db: db, serviceName: serviceName, serviceHost: serviceHost}
+	for _, opt := range opts {
+		opt(repo)
+	}
+
+	return repo
 }
 
 // SetupAudit, set the indexes for mongoDb
 func (repo *auditMongo) SetupAudit() error {
-	// Copy mongo session (thread safe) and close after function
-	conn := repo.db.Conn.Copy()
-	defer conn.Close()
+	return repo.SetupAuditContext(context.Background())
+}
+
+// SetupAuditContext, set the indexes for mongoDb, returns ctx.Err() when ctx is done first,
+// index builds started by mongo keep running
+func (repo *auditMongo) SetupAuditContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
+	done := make(chan error, 1)
+	go func() {
+		done <- repo.setup()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// setup, set the indexes for mongoDb
+func (repo *auditMongo) setup() error {
 	// Setup indexes
-	return repo.db.Setup([]mgo.Index{
+	indexes := []mgo.Index{
 		{Key: []string{"timestamp"}},
-	})
+		{Key: []string{"servicename", "timestamp"}},
+		{Key: []string{"user", "timestamp"}},
+		{Key: []string{"action", "timestamp"}},
+		{Key: []string{"resource_type", "resource_id", "timestamp"}},
+	}
+	if repo.chain != nil {
+		// Unique sequence per service, concurrent writers can't fork the chain
+		indexes = append(indexes, mgo.Index{
+			Key:           []string{"servicename", "seq"},
+			Unique:        true,
+			PartialFilter: bson.M{"seq": bson.M{"$exists": true}},
+		})
+	}
+
+	if repo.ttl > 0 {
+		// EnsureIndex can't change the options of the existing timestamp index
+		indexes[0].ExpireAfter = repo.ttl
+		if m, ok := repo.db.(indexMigrator); ok {
+			_, err := m.MigrateIndexes("audit-ttl-"+repo.ttl.String(), indexes)
+			return err
+		}
+	}
+
+	return repo.db.Setup(indexes)
+}
+
+// Log, save log entry to mongoDb, the channel delivers the insert error or nil
+func (repo *auditMongo) Log(user, message, data interface{}) chan error {
+	return repo.LogContext(context.Background(), user, message, data)
+}
+
+// LogContext, save log entry to mongoDb, the channel delivers the insert error or nil
+func (repo *auditMongo) LogContext(ctx context.Context, user, message, data interface{}) chan error {
+	return repo.LogEventContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogSync, save log entry to mongoDb and wait for the insert
+func (repo *auditMongo) LogSync(user, message, data interface{}) error {
+	return repo.LogSyncContext(context.Background(), user, message, data)
 }
 
-// Log, save log entry to mongoDb
-func (repo *auditMongo) Log(user, message, data interface{}) chan bool {
+// LogSyncContext, save log entry to mongoDb and wait for the insert
+func (repo *auditMongo) LogSyncContext(ctx context.Context, user, message, data interface{}) error {
+	return repo.LogEventSyncContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogEvent, save event to mongoDb, the channel delivers the insert error or nil
+func (repo *auditMongo) LogEvent(event *lxAudit.Event) chan error {
+	return repo.LogEventContext(context.Background(), event)
+}
+
+// LogEventContext, save event to mongoDb, the channel delivers the insert error or nil
+func (repo *auditMongo) LogEventContext(ctx context.Context, event *lxAudit.Event) chan error {
 	// channel for done
-	done := make(chan bool, 1)
+	done := make(chan error, 1)
+	entry, err := repo.entry(event)
+	if err != nil {
+		done <- err
+		close(done)
+		return done
+	}
 
 	go func() {
-		// Copy mongo session (thread safe) and close after function
-		conn := repo.db.Conn.Copy()
-		defer conn.Close()
+		// inform when worker is done
+		done <- repo.insert(ctx, entry)
+		close(done)
+	}()
+
+	return done
+}
+
+// LogEventSync, save event to mongoDb and wait for the insert
+func (repo *auditMongo) LogEventSync(event *lxAudit.Event) error {
+	return repo.LogEventSyncContext(context.Background(), event)
+}
+
+// LogEventSyncContext, save event to mongoDb and wait for the insert
+func (repo *auditMongo) LogEventSyncContext(ctx context.Context, event *lxAudit.Event) error {
+	entry, err := repo.entry(event)
+	if err != nil {
+		return err
+	}
+
+	return repo.insert(ctx, entry)
+}
+
+// entry, copy of event with timestamp and service set when empty and redacted data,
+// the caller may reuse the event
+func (repo *auditMongo) entry(event *lxAudit.Event) (*lxAudit.Event, error) {
+	entry := newEntry(event, repo.serviceName, repo.serviceHost)
+	if repo.redactor == nil {
+		return entry, nil
+	}
+
+	return repo.redactor.RedactEvent(entry)
+}
+
+// newEntry, copy of event with timestamp and service set when empty
+func newEntry(event *lxAudit.Event, serviceName, serviceHost string) *lxAudit.Event {
+	entry := *event
+	if entry.TimeStamp.IsZero() {
+		entry.TimeStamp = time.Now()
+	}
+	if entry.ServiceName == "" {
+		entry.ServiceName = serviceName
+	}
+	if entry.ServiceHost == "" {
+		entry.ServiceHost = serviceHost
+	}
+
+	return &entry
+}
 
-		// Log entry
-		entry := &lxAudit.AuditModel{
-			TimeStamp:   time.Now(),
-			ServiceName: repo.serviceName,
-			ServiceHost: repo.serviceHost,
-			User:        user,
-			Message:     message,
-			Data:        data,
+// insert, insert entry and log failures for callers ignoring the error
+func (repo *auditMongo) insert(ctx context.Context, entry *lxAudit.Event) error {
+	var err error
+	if repo.chain == nil {
+		err = repo.db.CreateContext(ctx, entry)
+	} else {
+		err = repo.insertChained(ctx, entry)
+	}
+	if err != nil {
+		log.Printf("mongoDb can't insert audit entry, error: %v\n", err)
+	}
+
+	return err
+}
+
+// insertChained, link entry to the head of its service and insert it,
+// retries with the new head when another writer took the sequence
+func (repo *auditMongo) insertChained(ctx context.Context, entry *lxAudit.Event) error {
+	repo.chain.mu.Lock()
+	defer repo.chain.mu.Unlock()
+
+	var err error
+	for i := 0; i < chainRetries; i++ {
+		if err = repo.link(ctx, entry); err != nil {
+			return err
 		}
 
-		// Insert entry
-		if err := conn.DB(repo.db.Name).C(repo.db.Collection).Insert(entry); err != nil {
-			log.Printf("mongoDb can't insert audit entry, error: %v\n", err)
+		if err = repo.db.CreateContext(ctx, entry); err == nil {
+			return nil
 		}
 
-		// inform when worker is done
-		done <- true
+		// Head is unknown after a failed insert, reload it with the next entry
+		delete(repo.chain.heads, entry.ServiceName)
+		if !mgo.IsDup(err) {
+			return err
+		}
+	}
 
-	}()
+	return err
+}
 
-	return done
+// link, set sequence and hashes of entry after the head of its service,
+// the caller holds the chain lock
+func (repo *auditMongo) link(ctx context.Context, entry *lxAudit.Event) error {
+	head, ok := repo.chain.heads[entry.ServiceName]
+	if !ok {
+		var docs []lxAudit.Event
+		query := bson.M{"servicename": entry.ServiceName, "seq": bson.M{"$exists": true}}
+		if _, err := repo.db.GetAllContext(ctx, query, &docs, &lxDb.Options{Sort: "-seq", Limit: 1}); err != nil {
+			return err
+		}
+
+		head = &link{}
+		if len(docs) > 0 {
+			head = &link{seq: docs[0].Seq, hash: docs[0].Hash}
+		}
+	}
+
+	entry.Seq = head.seq + 1
+	entry.PrevHash = head.hash
+	hash, err := lxAudit.ChainHash(head.hash, entry, repo.chain.key)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	repo.chain.heads[entry.ServiceName] = &link{seq: entry.Seq, hash: hash}
+
+	return nil
+}
+
+// Verify, walk the chain of service from sequence from to sequence to (0 for the head),
+// returns the number of checked entries and a *lxAudit.ChainError for the first broken or missing link,
+// entries deleted at the head of the chain can't be detected, from 0 starts after the last
+// link archived by an Archiver
+func (repo *auditMongo) Verify(ctx context.Context, service string, from, to int64) (int, error) {
+	var key []byte
+	if repo.chain != nil {
+		key = repo.chain.key
+	}
+
+	// Entries up to the last archived link are gone, the chain continues after it
+	archived, err := archivedLink(ctx, repo.db, service)
+	if err != nil {
+		return 0, err
+	}
+
+	// Hash of the link before the range
+	prev := ""
+	switch {
+	case from < 1 && archived != nil:
+		from, prev = archived.Seq+1, archived.Hash
+	case from <= 1:
+		from = 1
+	default:
+		var docs []lxAudit.Event
+		if _, err := repo.db.GetAllContext(ctx, bson.M{"servicename": service, "seq": from - 1}, &docs, &lxDb.Options{Limit: 1}); err != nil {
+			return 0, err
+		}
+		switch {
+		case len(docs) > 0:
+			prev = docs[0].Hash
+		case archived != nil && archived.Seq == from-1:
+			prev = archived.Hash
+		default:
+			return 0, &lxAudit.ChainError{Service: service, Seq: from - 1, Reason: lxAudit.ReasonMissing}
+		}
+	}
+
+	n := 0
+	next := from
+	opts := &lxDb.Options{Sort: "seq", Limit: ExportBatchSize}
+	for {
+		seq := bson.M{"$gte": next}
+		if to > 0 {
+			seq["$lte"] = to
+		}
+
+		var docs []lxAudit.Event
+		if _, err := repo.db.GetAllContext(ctx, bson.M{"servicename": service, "seq": seq}, &docs, opts); err != nil {
+			return n, err
+		}
+
+		for i := range docs {
+			e := &docs[i]
+			switch {
+			case e.Seq > next:
+				return n, &lxAudit.ChainError{Service: service, Seq: next, Reason: lxAudit.ReasonMissing}
+			case e.Seq < next:
+				return n, &lxAudit.ChainError{Service: service, Seq: e.Seq, Reason: lxAudit.ReasonDuplicate}
+			case e.PrevHash != prev:
+				return n, &lxAudit.ChainError{Service: service, Seq: e.Seq, Reason: lxAudit.ReasonPrevHash}
+			}
+
+			hash, err := lxAudit.ChainHash(prev, e, key)
+			if err != nil {
+				return n, err
+			}
+			if hash != e.Hash {
+				return n, &lxAudit.ChainError{Service: service, Seq: e.Seq, Reason: lxAudit.ReasonHash}
+			}
+
+			prev = e.Hash
+			next++
+			n++
+		}
+
+		if len(docs) < ExportBatchSize {
+			return n, nil
+		}
+	}
+}
+
+// Find, return entries matching filter, newest first unless opts has a sort,
+// returns the total count when opts.Count is set
+func (repo *auditMongo) Find(ctx context.Context, filter *lxAudit.Filter, opts *lxDb.Options) ([]lxAudit.Event, int, error) {
+	var events []lxAudit.Event
+	n, err := repo.db.GetAllContext(ctx, filter.Query(), &events, sortByTime(opts, "-timestamp"))
+
+	return events, n, err
+}
+
+// FindPage, return one page of entries matching filter with continuation tokens,
+// newest first unless opts has a sort, needs the CursorKey of the lxDb repository
+func (repo *auditMongo) FindPage(ctx context.Context, filter *lxAudit.Filter, opts *lxDb.Options) ([]lxAudit.Event, *lxDb.Page, error) {
+	var events []lxAudit.Event
+	page, err := repo.db.GetPageContext(ctx, filter.Query(), &events, sortByTime(opts, "-timestamp"))
+
+	return events, page, err
+}
+
+// Export, stream entries matching filter in time order to w,
+// returns the number of exported entries
+func (repo *auditMongo) Export(ctx context.Context, w io.Writer, filter *lxAudit.Filter, format string) (int, error) {
+	exp, err := lxAudit.NewExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := repo.export(ctx, exp, filter)
+
+	// Rows written before a failure reach w, a failed write of the exporter is reported
+	if ferr := exp.Flush(); err == nil {
+		err = ferr
+	}
+
+	return n, err
+}
+
+// export, write entries matching filter to exp, keyset on timestamp and _id,
+// reads stay fast on deep exports
+func (repo *auditMongo) export(ctx context.Context, exp lxAudit.Exporter, filter *lxAudit.Filter) (int, error) {
+	n := 0
+	query := filter.Query()
+	opts := &lxDb.Options{Sort: "timestamp,_id", Limit: ExportBatchSize}
+	for {
+		var docs []struct {
+			Id            interface{} `bson:"_id"`
+			lxAudit.Event `bson:",inline"`
+		}
+		if _, err := repo.db.GetAllContext(ctx, query, &docs, opts); err != nil {
+			return n, err
+		}
+
+		for i := range docs {
+			if err := exp.Write(&docs[i].Event); err != nil {
+				return n, err
+			}
+			n++
+		}
+
+		if len(docs) < ExportBatchSize {
+			return n, nil
+		}
+
+		last := docs[len(docs)-1]
+		query = bson.M{"$and": []interface{}{filter.Query(), bson.M{"$or": []interface{}{
+			bson.M{"timestamp": bson.M{"$gt": last.TimeStamp}},
+			bson.M{"timestamp": last.TimeStamp, "_id": bson.M{"$gt": last.Id}},
+		}}}}
+	}
+}
+
+// sortByTime, copy of opts with default sort
+func sortByTime(opts *lxDb.Options, sort string) *lxDb.Options {
+	o := lxDb.Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Sort == "" {
+		o.Sort = sort
+	}
+
+	return &o
 }
@@ -8,7 +8,6 @@ import (
 	"github.com/litixsoft/lx-golib/helper"
 	"github.com/litixsoft/lx-golib/tests/fixtures"
 	"github.com/smartystreets/goconvey/convey"
-	"log"
 	"reflect"
 	"testing"
 	"time"
@@ -22,7 +21,7 @@ const (
 
 func TestNewAuditMongo(t *testing.T) {
 	// Db connect
-	conn := fixtures.GetMongoConn()
+	conn := fixtures.GetMongoConn(t)
 	defer conn.Close()
 
 	// Db base repo
@@ -42,7 +41,7 @@ func TestNewAuditMongo(t *testing.T) {
 
 func TestAuditMongo_SetupAudit(t *testing.T) {
 	// Db connect
-	conn := fixtures.GetMongoConn()
+	conn := fixtures.GetMongoConn(t)
 	defer conn.Close()
 
 	// Delete collection
@@ -66,7 +65,7 @@ func TestAuditMongo_SetupAudit(t *testing.T) {
 		},
 	}
 	if err := conn.DB(fixtures.TestDbName).C(AuditCollection).Insert(testEntry); err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 
 	// Db base, repo
@@ -77,7 +76,7 @@ func TestAuditMongo_SetupAudit(t *testing.T) {
 		convey.Convey("When check indexes before setup", func() {
 			idx, err := conn.DB(fixtures.TestDbName).C(AuditCollection).Indexes()
 			if err != nil {
-				log.Fatal(err)
+				t.Fatal(err)
 			}
 
 			convey.Convey("Then indexes should be only contain _id", func() {
@@ -91,11 +90,12 @@ func TestAuditMongo_SetupAudit(t *testing.T) {
 			convey.Convey("Then indexes should be equal to expected values", func() {
 				idx, err := conn.DB(fixtures.TestDbName).C(AuditCollection).Indexes()
 				if err != nil {
-					log.Fatal(err)
+					t.Fatal(err)
 				}
 
-				convey.So(len(idx), convey.ShouldEqual, 2)
-				convey.So(idx[1].Name, convey.ShouldEqual, "timestamp_1")
+				convey.So(len(idx), convey.ShouldEqual, 6)
+				convey.So(idx[4].Name, convey.ShouldEqual, "timestamp_1")
+				convey.So(idx[5].Name, convey.ShouldEqual, "user_1_timestamp_1")
 			})
 		})
 	})
@@ -103,7 +103,7 @@ func TestAuditMongo_SetupAudit(t *testing.T) {
 
 func TestAuditMongo_Log(t *testing.T) {
 	// Db connect
-	conn := fixtures.GetMongoConn()
+	conn := fixtures.GetMongoConn(t)
 	defer conn.Close()
 
 	// Delete collection
@@ -115,7 +115,7 @@ func TestAuditMongo_Log(t *testing.T) {
 	repo := lxAuditRepos.NewAuditMongo(db, "TestService", "localhost:3101")
 
 	if err := repo.SetupAudit(); err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 
 	convey.Convey("Given repo with deleted collection and indexes", t, func() {
@@ -128,7 +128,7 @@ func TestAuditMongo_Log(t *testing.T) {
 			convey.Convey("Then audit entry should be found in db", func() {
 				var result lxAudit.AuditModel
 				if err := conn.DB(db.Name).C(db.Collection).Find(lxHelper.M{"user": "test_user"}).One(&result); err != nil {
-					log.Fatal(err)
+					t.Fatal(err)
 				}
 
 				convey.So(result.ServiceName, convey.ShouldEqual, "TestService")
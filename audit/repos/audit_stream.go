@@ -0,0 +1,119 @@
+package lxAuditRepos
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/litixsoft/lx-golib/audit"
+)
+
+// AuditStream, audit backend writing one json object per entry and line,
+// for stdout/stderr in containers or rotating files
+type AuditStream struct {
+	serviceName string
+	serviceHost string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditStream, return audit backend writing json lines to w
+func NewAuditStream(w io.Writer, serviceName, serviceHost string) *AuditStream {
+	return &AuditStream{w: w, serviceName: serviceName, serviceHost: serviceHost}
+}
+
+// NewAuditStdout, return audit backend writing json lines to stdout
+func NewAuditStdout(serviceName, serviceHost string) *AuditStream {
+	return NewAuditStream(os.Stdout, serviceName, serviceHost)
+}
+
+// NewAuditStderr, return audit backend writing json lines to stderr
+func NewAuditStderr(serviceName, serviceHost string) *AuditStream {
+	return NewAuditStream(os.Stderr, serviceName, serviceHost)
+}
+
+// SetupAudit, nothing to set up for streams
+func (repo *AuditStream) SetupAudit() error {
+	return nil
+}
+
+// SetupAuditContext, nothing to set up for streams
+func (repo *AuditStream) SetupAuditContext(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Log, write log entry, the channel delivers the write error or nil
+func (repo *AuditStream) Log(user, message, data interface{}) chan error {
+	return repo.LogContext(context.Background(), user, message, data)
+}
+
+// LogContext, write log entry, the channel delivers the write error or nil
+func (repo *AuditStream) LogContext(ctx context.Context, user, message, data interface{}) chan error {
+	return repo.LogEventContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogSync, write log entry
+func (repo *AuditStream) LogSync(user, message, data interface{}) error {
+	return repo.LogSyncContext(context.Background(), user, message, data)
+}
+
+// LogSyncContext, write log entry
+func (repo *AuditStream) LogSyncContext(ctx context.Context, user, message, data interface{}) error {
+	return repo.LogEventSyncContext(ctx, lxAudit.LegacyEvent(user, message, data))
+}
+
+// LogEvent, write event, the channel delivers the write error or nil,
+// the entry is written before LogEvent returns to keep the order of the lines
+func (repo *AuditStream) LogEvent(event *lxAudit.Event) chan error {
+	return repo.LogEventContext(context.Background(), event)
+}
+
+// LogEventContext, write event, the channel delivers the write error or nil,
+// the entry is written before LogEventContext returns to keep the order of the lines
+func (repo *AuditStream) LogEventContext(ctx context.Context, event *lxAudit.Event) chan error {
+	done := make(chan error, 1)
+	done <- repo.LogEventSyncContext(ctx, event)
+	close(done)
+
+	return done
+}
+
+// LogEventSync, write event
+func (repo *AuditStream) LogEventSync(event *lxAudit.Event) error {
+	return repo.LogEventSyncContext(context.Background(), event)
+}
+
+// LogEventSyncContext, write event unless ctx is done, a started write isn't interrupted
+func (repo *AuditStream) LogEventSyncContext(ctx context.Context, event *lxAudit.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(newEntry(event, repo.serviceName, repo.serviceHost))
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	// One write per line, rotating files cut between lines
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	_, err = repo.w.Write(line)
+
+	return err
+}
+
+// Close, close the writer when it is an io.Closer, stdout and stderr stay open
+func (repo *AuditStream) Close() error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if c, ok := repo.w.(io.Closer); ok && c != os.Stdout && c != os.Stderr {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,371 @@
+package lxAuditRepos
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	DefaultSpoolMaxBytes     = 256 << 20
+	DefaultSpoolSegmentBytes = 8 << 20
+
+	// record header, payload length and crc32 of payload
+	recordHeader = 8
+	segmentExt   = ".seg"
+)
+
+// ErrSpoolFull, append would exceed the size cap of the spool
+var ErrSpoolFull = errors.New("audit spool is full")
+
+// SpoolStats, depth of the spool
+type SpoolStats struct {
+	Entries  int64  // Entries waiting for replay
+	Bytes    int64  // Size of all segments
+	Segments int    // Segment files
+	Corrupt  uint64 // Records skipped for bad checksums or torn writes
+}
+
+// Spool, durable write-ahead spool of bson documents in segmented append-only files,
+// every record carries its length and a crc32 checksum
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu       sync.Mutex
+	segments []int64 // sequence numbers, oldest first
+	active   *os.File
+	size     int64 // size of the active segment
+	entries  int64
+	bytes    int64
+	corrupt  uint64
+}
+
+// OpenSpool, open or create spool in dir, maxBytes caps the spool and segmentBytes
+// sets the size for starting a new segment, zero values use the defaults,
+// a torn tail of the last segment is cut
+func OpenSpool(dir string, maxBytes, segmentBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultSpoolMaxBytes
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = DefaultSpoolSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		var seq int64
+		if _, err := fmt.Sscanf(filepath.Base(name), "%016d"+segmentExt, &seq); err == nil {
+			s.segments = append(s.segments, seq)
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	for i, seq := range s.segments {
+		scan, err := s.scan(seq)
+		if err != nil {
+			return nil, err
+		}
+		s.entries += int64(scan.records)
+		s.bytes += scan.valid
+
+		// Cut torn tail of the last segment, appends continue there
+		if i == len(s.segments)-1 && scan.end < scan.size {
+			if err := os.Truncate(s.path(seq), scan.end); err != nil {
+				return nil, err
+			}
+			s.corrupt++
+		}
+	}
+
+	return s, nil
+}
+
+// Append, write documents as one durable batch, returns ErrSpoolFull when the cap is reached
+func (s *Spool) Append(docs []interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([][]byte, len(docs))
+	total := int64(0)
+	for i, doc := range docs {
+		payload, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		records[i] = make([]byte, recordHeader+len(payload))
+		binary.BigEndian.PutUint32(records[i], uint32(len(payload)))
+		binary.BigEndian.PutUint32(records[i][4:], crc32.ChecksumIEEE(payload))
+		copy(records[i][recordHeader:], payload)
+		total += int64(len(records[i]))
+	}
+	if s.bytes+total > s.maxBytes {
+		return ErrSpoolFull
+	}
+
+	for _, rec := range records {
+		if s.active == nil || (s.size > 0 && s.size+int64(len(rec)) > s.segmentBytes) {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		if _, err := s.active.Write(rec); err != nil {
+			return err
+		}
+		s.size += int64(len(rec))
+		s.bytes += int64(len(rec))
+		s.entries++
+	}
+
+	return s.active.Sync()
+}
+
+// Replay, pass spooled documents oldest first in batches to fn, a segment is removed
+// after all its batches succeeded, stops at the first error of fn, fn must be idempotent
+// because a segment is replayed again after a failure, returns the replayed entries,
+// Append and Replay must not be called concurrently
+func (s *Spool) Replay(batchSize int, fn func(docs []interface{}) error) (int, error) {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	replayed := 0
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return replayed, nil
+		}
+		seq := s.segments[0]
+		docs, size, err := s.read(seq)
+		s.mu.Unlock()
+		if err != nil {
+			return replayed, err
+		}
+
+		// Insert without lock, Stats stays available
+		for start := 0; start < len(docs); start += batchSize {
+			end := start + batchSize
+			if end > len(docs) {
+				end = len(docs)
+			}
+			if err := fn(docs[start:end]); err != nil {
+				return replayed, err
+			}
+		}
+
+		if err := s.remove(seq, len(docs), size); err != nil {
+			return replayed, err
+		}
+		replayed += len(docs)
+	}
+}
+
+// remove, delete replayed segment, the next append starts a new one
+func (s *Spool) remove(seq int64, entries int, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 1 && s.active != nil {
+		s.active.Close()
+		s.active, s.size = nil, 0
+	}
+	if err := os.Remove(s.path(seq)); err != nil {
+		return err
+	}
+	s.segments = s.segments[1:]
+	s.entries -= int64(entries)
+	s.bytes -= size
+
+	return syncDir(s.dir)
+}
+
+// Stats, return spool depth
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SpoolStats{Entries: s.entries, Bytes: s.bytes, Segments: len(s.segments), Corrupt: s.corrupt}
+}
+
+// Close, close the active segment, spooled entries stay for the next OpenSpool
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active, s.size = nil, 0
+
+	return err
+}
+
+// rotate, continue the last segment when it has space, start the next one when it is full
+func (s *Spool) rotate() error {
+	full := s.active != nil
+	if full {
+		// Records of the batch written before the rotation must be durable too
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		s.active, s.size = nil, 0
+	}
+
+	if !full && len(s.segments) > 0 {
+		path := s.path(s.segments[len(s.segments)-1])
+		if info, err := os.Stat(path); err == nil && info.Size() < s.segmentBytes {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			s.active, s.size = f, info.Size()
+			return nil
+		}
+	}
+
+	seq := int64(1)
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1] + 1
+	}
+	f, err := os.OpenFile(s.path(seq), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, seq)
+	s.active = f
+
+	// New directory entry must survive a crash
+	return syncDir(s.dir)
+}
+
+// syncDir, fsync directory entries of dir
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// read, return the valid documents of a segment and their size
+func (s *Spool) read(seq int64) ([]interface{}, int64, error) {
+	var docs []interface{}
+	scan, err := s.each(seq, true, func(payload []byte) error {
+		var doc bson.D
+		if err := bson.Unmarshal(payload, &doc); err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if scan.records != len(docs) {
+		return nil, 0, fmt.Errorf("audit spool segment %d changed while reading", seq)
+	}
+
+	return docs, scan.valid, nil
+}
+
+// scan, count valid records of a segment
+func (s *Spool) scan(seq int64) (segmentScan, error) {
+	return s.each(seq, false, func(payload []byte) error { return nil })
+}
+
+// segmentScan, result of each
+type segmentScan struct {
+	records int   // valid records
+	valid   int64 // size of the valid records
+	end     int64 // offset after the last valid record
+	size    int64 // file size
+}
+
+// each, call fn for every valid record, corrupt records are skipped by resyncing
+// at the next valid record, so one bad record doesn't lose the records behind it,
+// report counts and logs the skipped bytes
+func (s *Spool) each(seq int64, report bool, fn func(payload []byte) error) (segmentScan, error) {
+	data, err := ioutil.ReadFile(s.path(seq))
+	if err != nil {
+		return segmentScan{}, err
+	}
+
+	scan := segmentScan{size: int64(len(data))}
+	for off := 0; off < len(data); {
+		payload, ok := record(data[off:])
+		if !ok {
+			next := off + 1
+			for next < len(data) {
+				if _, ok := record(data[next:]); ok {
+					break
+				}
+				next++
+			}
+			if report {
+				s.corrupt++
+				log.Printf("audit spool segment %d skips %d corrupt bytes at offset %d\n", seq, next-off, off)
+			}
+			off = next
+			continue
+		}
+
+		if err := fn(payload); err != nil {
+			return scan, err
+		}
+		off += recordHeader + len(payload)
+		scan.records++
+		scan.valid += int64(recordHeader + len(payload))
+		scan.end = int64(off)
+	}
+
+	return scan, nil
+}
+
+// record, payload of the record at the start of data, false for torn or corrupt records
+func record(data []byte) ([]byte, bool) {
+	if len(data) < recordHeader {
+		return nil, false
+	}
+
+	// Payload is a bson document, its own length must match
+	size := binary.BigEndian.Uint32(data)
+	if size < 5 || int64(size) > int64(len(data)-recordHeader) {
+		return nil, false
+	}
+	payload := data[recordHeader : recordHeader+int(size)]
+	if binary.LittleEndian.Uint32(payload) != size || crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, false
+	}
+
+	return payload, true
+}
+
+// path, file of segment
+func (s *Spool) path(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
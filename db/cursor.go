@@ -0,0 +1,289 @@
+package lxDb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var (
+	// ErrInvalidCursor, cursor token is malformed, has a wrong signature or doesn't match the sort
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrNoCursorKey, cursor pagination needs a signing key
+	ErrNoCursorKey = errors.New("cursor key is not set")
+)
+
+// Page, continuation tokens for cursor pagination
+type Page struct {
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Total int    `json:"total,omitempty"`
+}
+
+// cursor, payload of a continuation token
+type cursor struct {
+	Sort  string      `bson:"s"`
+	Value interface{} `bson:"v,omitempty"`
+	Id    interface{} `bson:"i"`
+	Prev  bool        `bson:"p,omitempty"`
+}
+
+// keyset, prepared query for one page
+type keyset struct {
+	filter interface{}
+	sort   []string
+	limit  int
+	cursor *cursor
+	sortBy string
+}
+
+// encodeCursor, serialize and sign cursor
+func encodeCursor(c *cursor, key []byte) (string, error) {
+	payload, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeCursor, verify signature and deserialize cursor
+func decodeCursor(token string, key []byte) (*cursor, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := bson.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// newKeyset, build filter and sort for the page requested with opts
+func newKeyset(query interface{}, opts *Options, key []byte) (*keyset, error) {
+	if len(key) == 0 {
+		return nil, ErrNoCursorKey
+	}
+
+	keys := opts.SortKeys()
+	if len(keys) > 1 {
+		return nil, &OptionsError{Option: "sort", Field: opts.Sort, Reason: "cursor supports only one sort key"}
+	}
+
+	ks := &keyset{filter: query, limit: opts.Limit}
+	if len(keys) == 1 && strings.TrimPrefix(keys[0], "-") != "_id" {
+		ks.sortBy = keys[0]
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor, key)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != ks.sortBy {
+			return nil, ErrInvalidCursor
+		}
+		ks.cursor = c
+	}
+
+	// Direction of sort key and _id, inverted for previous page
+	desc := len(keys) == 1 && strings.HasPrefix(keys[0], "-")
+	if ks.cursor != nil && ks.cursor.Prev {
+		desc = !desc
+	}
+	sign, op := "", "$gt"
+	if desc {
+		sign, op = "-", "$lt"
+	}
+
+	name := strings.TrimPrefix(ks.sortBy, "-")
+	if name != "" {
+		ks.sort = append(ks.sort, sign+name)
+	}
+	ks.sort = append(ks.sort, sign+"_id")
+
+	// Keyset condition behind the cursor position
+	if ks.cursor != nil {
+		var cond bson.M
+		if name == "" {
+			cond = bson.M{"_id": bson.M{op: ks.cursor.Id}}
+		} else {
+			cond = keysetCond(name, op, ks.cursor.Value, ks.cursor.Id)
+		}
+
+		if query == nil {
+			ks.filter = cond
+		} else {
+			ks.filter = bson.M{"$and": []interface{}{query, cond}}
+		}
+	}
+
+	return ks, nil
+}
+
+// keysetCond, documents behind value and id in the order of op, null and missing values sort
+// first but $gt and $lt don't match them, they are matched explicitly
+func keysetCond(name, op string, value, id interface{}) bson.M {
+	tie := bson.M{name: value, "_id": bson.M{op: id}}
+	switch {
+	case value == nil && op == "$gt":
+		return bson.M{"$or": []interface{}{tie, bson.M{name: bson.M{"$ne": nil}}}}
+	case value == nil:
+		return tie
+	case op == "$lt":
+		return bson.M{"$or": []interface{}{bson.M{name: bson.M{op: value}}, tie, bson.M{name: nil}}}
+	}
+
+	return bson.M{"$or": []interface{}{bson.M{name: bson.M{op: value}}, tie}}
+}
+
+// fetchLimit, limit for the query, one more to detect further documents
+func (ks *keyset) fetchLimit() int {
+	if ks.limit <= 0 {
+		return 0
+	}
+
+	return ks.limit + 1
+}
+
+// selector, projection of opts which keeps the fields needed for the tokens
+func (ks *keyset) selector(opts *Options) bson.M {
+	sel := opts.Selector()
+	if sel == nil {
+		return nil
+	}
+
+	include := false
+	for k, v := range sel {
+		if k != "_id" && v == 1 {
+			include = true
+		}
+	}
+
+	for _, k := range []string{strings.TrimPrefix(ks.sortBy, "-"), "_id"} {
+		if k == "" {
+			continue
+		}
+		if v, ok := sel[k]; ok && v == 0 {
+			delete(sel, k)
+		} else if include {
+			sel[k] = 1
+		}
+	}
+
+	return sel
+}
+
+// page, trim fetched documents to display order and build the tokens
+func (ks *keyset) page(docs []bson.Raw, key []byte) ([]bson.Raw, *Page, error) {
+	more := ks.limit > 0 && len(docs) > ks.limit
+	if more {
+		docs = docs[:ks.limit]
+	}
+
+	prev := ks.cursor != nil && ks.cursor.Prev
+	if prev {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+
+	p := &Page{}
+	if len(docs) == 0 {
+		return docs, p, nil
+	}
+
+	var err error
+	if (!prev && more) || (prev && ks.cursor != nil) {
+		if p.Next, err = ks.token(docs[len(docs)-1], false, key); err != nil {
+			return nil, nil, err
+		}
+	}
+	if (prev && more) || (!prev && ks.cursor != nil) {
+		if p.Prev, err = ks.token(docs[0], true, key); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return docs, p, nil
+}
+
+// token, build the continuation token for a document
+func (ks *keyset) token(doc bson.Raw, prev bool, key []byte) (string, error) {
+	var m bson.M
+	if err := doc.Unmarshal(&m); err != nil {
+		return "", err
+	}
+
+	c := &cursor{Sort: ks.sortBy, Id: m["_id"], Prev: prev}
+	if ks.sortBy != "" {
+		c.Value = lookup(m, strings.TrimPrefix(ks.sortBy, "-"))
+	}
+
+	return encodeCursor(c, key)
+}
+
+// lookup, get value of dotted path from document
+func lookup(m bson.M, path string) interface{} {
+	var v interface{} = m
+	for _, part := range strings.Split(path, ".") {
+		doc, ok := v.(bson.M)
+		if !ok {
+			return nil
+		}
+		v = doc[part]
+	}
+
+	return v
+}
+
+// decodeAll, unmarshal raw documents into result, a pointer to a slice
+func decodeAll(docs []bson.Raw, result interface{}) error {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return errors.New("result argument must be a slice address")
+	}
+
+	slice := rv.Elem()
+	elemType := slice.Type().Elem()
+	out := reflect.MakeSlice(slice.Type(), 0, len(docs))
+
+	for _, doc := range docs {
+		ep := reflect.New(elemType)
+		if err := doc.Unmarshal(ep.Interface()); err != nil {
+			return err
+		}
+		out = reflect.Append(out, ep.Elem())
+	}
+	slice.Set(out)
+
+	return nil
+}
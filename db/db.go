@@ -1,5 +1,62 @@
 package lxDb
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrNotFound, returned when no document matches the query
+var ErrNotFound = mgo.ErrNotFound
+
+type actorKey struct{}
+
+// WithActor, return ctx carrying the id of the acting user
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext, return the id of the acting user, empty when unknown
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// IBaseDb, interface for base db repositories
+type IBaseDb interface {
+	Setup(indexes []mgo.Index) error
+	Create(data interface{}) error
+	CreateAll(data []interface{}) error
+	GetOne(query interface{}, result interface{}) error
+	GetAll(query interface{}, result interface{}, opts *Options) (int, error)
+	GetPage(query interface{}, result interface{}, opts *Options) (*Page, error)
+	GetCount(query interface{}) (int, error)
+	Update(query interface{}, data interface{}) error
+	UpdateAll(query interface{}, data interface{}) (ChangeInfo, error)
+	Delete(query interface{}) error
+	DeleteAll(query interface{}) (ChangeInfo, error)
+	Restore(query interface{}) (ChangeInfo, error)
+	Purge(olderThan time.Duration) (ChangeInfo, error)
+
+	// Variants bound to the deadline and cancellation of ctx
+	CreateContext(ctx context.Context, data interface{}) error
+	CreateAllContext(ctx context.Context, data []interface{}) error
+	GetOneContext(ctx context.Context, query interface{}, result interface{}) error
+	GetAllContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (int, error)
+	GetPageContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (*Page, error)
+	GetCountContext(ctx context.Context, query interface{}) (int, error)
+	UpdateContext(ctx context.Context, query interface{}, data interface{}) error
+	UpdateAllContext(ctx context.Context, query interface{}, data interface{}) (ChangeInfo, error)
+	DeleteContext(ctx context.Context, query interface{}) error
+	DeleteAllContext(ctx context.Context, query interface{}) (ChangeInfo, error)
+	RestoreContext(ctx context.Context, query interface{}) (ChangeInfo, error)
+	PurgeContext(ctx context.Context, olderThan time.Duration) (ChangeInfo, error)
+}
+
 // ChangeInfo holds details about the outcome of an update operation.
 type ChangeInfo struct {
 	Updated int // Number of documents updated
@@ -7,8 +64,127 @@ type ChangeInfo struct {
 	Matched int // Number of documents matched but not necessarily changed
 }
 
+// Options, options for read operations
 type Options struct {
-	Skip  int  `json:"skip"`
-	Limit int  `json:"limit"`
-	Count bool `json:"count"`
-}
\ No newline at end of file
+	Skip   int    `json:"skip"`
+	Limit  int    `json:"limit"`
+	Count  bool   `json:"count"`
+	Sort   string `json:"sort,omitempty"`   // Comma separated keys, prefix with dash (-) for descending order
+	Fields string `json:"fields,omitempty"` // Comma separated fields, prefix with dash (-) for exclusion
+	Cursor string `json:"cursor,omitempty"` // Continuation token from Page, only used by GetPage
+}
+
+// OptionsError, error for invalid sort or fields options
+type OptionsError struct {
+	Option string
+	Field  string
+	Reason string
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("invalid %s field %q: %s", e.Option, e.Field, e.Reason)
+}
+
+// SortKeys, return the sort keys in mgo notation
+func (o *Options) SortKeys() []string {
+	return splitKeys(o.Sort)
+}
+
+// Selector, return the projection for the fields option,
+// returns nil when all fields are requested
+func (o *Options) Selector() bson.M {
+	keys := splitKeys(o.Fields)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sel := bson.M{}
+	for _, k := range keys {
+		if strings.HasPrefix(k, "-") {
+			sel[k[1:]] = 0
+		} else {
+			sel[k] = 1
+		}
+	}
+
+	return sel
+}
+
+// Validate, check sort and fields syntax,
+// when allowed is not empty every field must be part of it
+func (o *Options) Validate(allowed []string) error {
+	if o.Skip < 0 {
+		return &OptionsError{Option: "skip", Field: fmt.Sprint(o.Skip), Reason: "must not be negative"}
+	}
+	if o.Limit < 0 {
+		return &OptionsError{Option: "limit", Field: fmt.Sprint(o.Limit), Reason: "must not be negative"}
+	}
+
+	for _, k := range o.SortKeys() {
+		if err := validateKey("sort", k, allowed); err != nil {
+			return err
+		}
+	}
+
+	// Mongo can't mix inclusion and exclusion, except for _id
+	include, exclude := false, false
+	for _, k := range splitKeys(o.Fields) {
+		if err := validateKey("fields", k, allowed); err != nil {
+			return err
+		}
+		if strings.TrimPrefix(k, "-") == "_id" {
+			continue
+		}
+		if strings.HasPrefix(k, "-") {
+			exclude = true
+		} else {
+			include = true
+		}
+		if include && exclude {
+			return &OptionsError{Option: "fields", Field: k, Reason: "can't mix inclusion and exclusion"}
+		}
+	}
+
+	return nil
+}
+
+// splitKeys, split comma separated keys and trim spaces
+func splitKeys(s string) []string {
+	var keys []string
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// validateKey, check a single sort or fields key
+func validateKey(option, key string, allowed []string) error {
+	name := strings.TrimPrefix(key, "-")
+
+	if name == "" || strings.HasPrefix(name, "-") {
+		return &OptionsError{Option: option, Field: key, Reason: "malformed name"}
+	}
+	if strings.ContainsAny(name, "$ \t") {
+		return &OptionsError{Option: option, Field: key, Reason: "illegal character"}
+	}
+	for _, part := range strings.Split(name, ".") {
+		if part == "" {
+			return &OptionsError{Option: option, Field: key, Reason: "malformed name"}
+		}
+	}
+
+	// _id is always allowed
+	if len(allowed) == 0 || name == "_id" {
+		return nil
+	}
+	for _, a := range allowed {
+		if a == name {
+			return nil
+		}
+	}
+
+	return &OptionsError{Option: option, Field: key, Reason: "not allowed"}
+}
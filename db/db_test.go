@@ -0,0 +1,56 @@
+package lxDb_test
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOptions_SortKeys(t *testing.T) {
+	t.Run("Return trimmed multi-key sort in order", func(t *testing.T) {
+		opts := lxDb.Options{Sort: " -created, name ,,"}
+		assert.Equal(t, []string{"-created", "name"}, opts.SortKeys())
+	})
+}
+
+func TestOptions_Selector(t *testing.T) {
+	t.Run("Return nil without fields", func(t *testing.T) {
+		opts := lxDb.Options{}
+		assert.Nil(t, opts.Selector())
+	})
+
+	t.Run("Return projection for included and excluded fields", func(t *testing.T) {
+		opts := lxDb.Options{Fields: "name,email,-_id"}
+		assert.Equal(t, bson.M{"name": 1, "email": 1, "_id": 0}, opts.Selector())
+	})
+}
+
+func TestOptions_Validate(t *testing.T) {
+	allowed := []string{"name", "email", "created"}
+
+	t.Run("Valid options with and without allow-list", func(t *testing.T) {
+		opts := lxDb.Options{Sort: "-created,name,_id", Fields: "name,email"}
+		assert.NoError(t, opts.Validate(nil))
+		assert.NoError(t, opts.Validate(allowed))
+	})
+
+	t.Run("Return options error for field not in allow-list", func(t *testing.T) {
+		opts := lxDb.Options{Sort: "-password"}
+		err := opts.Validate(allowed)
+		if assert.IsType(t, &lxDb.OptionsError{}, err) {
+			assert.Equal(t, "-password", err.(*lxDb.OptionsError).Field)
+		}
+	})
+
+	t.Run("Return error for malformed options", func(t *testing.T) {
+		assert.Error(t, (&lxDb.Options{Sort: "--name"}).Validate(nil))
+		assert.Error(t, (&lxDb.Options{Sort: "$where"}).Validate(nil))
+		assert.Error(t, (&lxDb.Options{Fields: "a..b"}).Validate(nil))
+		assert.Error(t, (&lxDb.Options{Limit: -1}).Validate(nil))
+	})
+
+	t.Run("Return error for mixed inclusion and exclusion", func(t *testing.T) {
+		assert.Error(t, (&lxDb.Options{Fields: "name,-email"}).Validate(nil))
+	})
+}
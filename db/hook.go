@@ -0,0 +1,304 @@
+package lxDb
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+// Op, repository operation type
+type Op string
+
+const (
+	OpCreate    Op = "create"
+	OpCreateAll Op = "create_all"
+	OpGetOne    Op = "get_one"
+	OpGetAll    Op = "get_all"
+	OpGetPage   Op = "get_page"
+	OpGetCount  Op = "get_count"
+	OpUpdate    Op = "update"
+	OpUpdateAll Op = "update_all"
+	OpDelete    Op = "delete"
+	OpDeleteAll Op = "delete_all"
+	OpRestore   Op = "restore"
+	OpPurge     Op = "purge"
+)
+
+// AllOps, all operation types
+var AllOps = []Op{
+	OpCreate, OpCreateAll, OpGetOne, OpGetAll, OpGetPage, OpGetCount,
+	OpUpdate, OpUpdateAll, OpDelete, OpDeleteAll, OpRestore, OpPurge,
+}
+
+// Operation, arguments and outcome of an operation passed to hooks,
+// before hooks may replace Query, Data and Options
+type Operation struct {
+	Op         Op
+	Collection string
+	Query      interface{}   // Filter of reads, updates and deletes
+	Data       interface{}   // Created document, []interface{} of CreateAll or update
+	Options    *Options      // Options of GetAll and GetPage
+	OlderThan  time.Duration // Age of Purge
+	Result     interface{}   // Result pointer of reads
+	Page       *Page         // Page of GetPage
+	Count      int           // Count of GetAll and GetCount
+	Info       ChangeInfo    // Change info of bulk operations
+	Err        error         // Error of the operation, set for after hooks
+}
+
+// Hook, called before or after an operation, before hooks veto the operation with an error
+type Hook func(ctx context.Context, op *Operation) error
+
+// HookDb, repository decorator running hooks around every operation of db
+type HookDb struct {
+	Db         IBaseDb
+	Collection string
+
+	before map[Op][]Hook
+	after  map[Op][]Hook
+}
+
+// NewHookDb, return hook decorator for db, collection is passed to the hooks
+func NewHookDb(db IBaseDb, collection string) *HookDb {
+	return &HookDb{
+		Db:         db,
+		Collection: collection,
+		before:     map[Op][]Hook{},
+		after:      map[Op][]Hook{},
+	}
+}
+
+// Before, add hook called before ops, before all operations when ops is empty,
+// hooks must be added before the repository is used
+func (db *HookDb) Before(hook Hook, ops ...Op) {
+	addHook(db.before, hook, ops)
+}
+
+// After, add hook called after ops, after all operations when ops is empty,
+// after hooks run also for failed operations, their first error is returned
+func (db *HookDb) After(hook Hook, ops ...Op) {
+	addHook(db.after, hook, ops)
+}
+
+// addHook, register hook for ops
+func addHook(hooks map[Op][]Hook, hook Hook, ops []Op) {
+	if len(ops) == 0 {
+		ops = AllOps
+	}
+	for _, op := range ops {
+		hooks[op] = append(hooks[op], hook)
+	}
+}
+
+// run, call before hooks, fn and after hooks
+func (db *HookDb) run(ctx context.Context, op *Operation, fn func() error) error {
+	op.Collection = db.Collection
+
+	for _, hook := range db.before[op.Op] {
+		if err := hook(ctx, op); err != nil {
+			return err
+		}
+	}
+
+	op.Err = fn()
+
+	err := op.Err
+	for _, hook := range db.after[op.Op] {
+		if hookErr := hook(ctx, op); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+
+	return err
+}
+
+// Setup, create indexes, without hooks
+func (db *HookDb) Setup(indexes []mgo.Index) error {
+	return db.Db.Setup(indexes)
+}
+
+// Create, insert a new document in collection
+func (db *HookDb) Create(data interface{}) error {
+	return db.CreateContext(context.Background(), data)
+}
+
+// CreateContext, insert a new document in collection
+func (db *HookDb) CreateContext(ctx context.Context, data interface{}) error {
+	op := &Operation{Op: OpCreate, Data: data}
+	return db.run(ctx, op, func() error {
+		return db.Db.CreateContext(ctx, op.Data)
+	})
+}
+
+// CreateAll, insert documents in one bulk
+func (db *HookDb) CreateAll(data []interface{}) error {
+	return db.CreateAllContext(context.Background(), data)
+}
+
+// CreateAllContext, insert documents in one bulk
+func (db *HookDb) CreateAllContext(ctx context.Context, data []interface{}) error {
+	op := &Operation{Op: OpCreateAll, Data: data}
+	return db.run(ctx, op, func() error {
+		docs, _ := op.Data.([]interface{})
+		return db.Db.CreateAllContext(ctx, docs)
+	})
+}
+
+// GetOne, find the first document matching the query
+func (db *HookDb) GetOne(query interface{}, result interface{}) error {
+	return db.GetOneContext(context.Background(), query, result)
+}
+
+// GetOneContext, find the first document matching the query
+func (db *HookDb) GetOneContext(ctx context.Context, query interface{}, result interface{}) error {
+	op := &Operation{Op: OpGetOne, Query: query, Result: result}
+	return db.run(ctx, op, func() error {
+		return db.Db.GetOneContext(ctx, op.Query, op.Result)
+	})
+}
+
+// GetAll, find all documents matching the query
+func (db *HookDb) GetAll(query interface{}, result interface{}, opts *Options) (int, error) {
+	return db.GetAllContext(context.Background(), query, result, opts)
+}
+
+// GetAllContext, find all documents matching the query
+func (db *HookDb) GetAllContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (int, error) {
+	op := &Operation{Op: OpGetAll, Query: query, Result: result, Options: opts}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Count, err = db.Db.GetAllContext(ctx, op.Query, op.Result, op.Options)
+		return err
+	})
+
+	return op.Count, err
+}
+
+// GetPage, find one page of documents matching the query with continuation tokens
+func (db *HookDb) GetPage(query interface{}, result interface{}, opts *Options) (*Page, error) {
+	return db.GetPageContext(context.Background(), query, result, opts)
+}
+
+// GetPageContext, find one page of documents matching the query with continuation tokens
+func (db *HookDb) GetPageContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (*Page, error) {
+	op := &Operation{Op: OpGetPage, Query: query, Result: result, Options: opts}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Page, err = db.Db.GetPageContext(ctx, op.Query, op.Result, op.Options)
+		return err
+	})
+
+	return op.Page, err
+}
+
+// GetCount, count documents matching the query
+func (db *HookDb) GetCount(query interface{}) (int, error) {
+	return db.GetCountContext(context.Background(), query)
+}
+
+// GetCountContext, count documents matching the query
+func (db *HookDb) GetCountContext(ctx context.Context, query interface{}) (int, error) {
+	op := &Operation{Op: OpGetCount, Query: query}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Count, err = db.Db.GetCountContext(ctx, op.Query)
+		return err
+	})
+
+	return op.Count, err
+}
+
+// Update, update the first document matching the query
+func (db *HookDb) Update(query interface{}, data interface{}) error {
+	return db.UpdateContext(context.Background(), query, data)
+}
+
+// UpdateContext, update the first document matching the query
+func (db *HookDb) UpdateContext(ctx context.Context, query interface{}, data interface{}) error {
+	op := &Operation{Op: OpUpdate, Query: query, Data: data}
+	return db.run(ctx, op, func() error {
+		return db.Db.UpdateContext(ctx, op.Query, op.Data)
+	})
+}
+
+// UpdateAll, update all documents matching the query
+func (db *HookDb) UpdateAll(query interface{}, data interface{}) (ChangeInfo, error) {
+	return db.UpdateAllContext(context.Background(), query, data)
+}
+
+// UpdateAllContext, update all documents matching the query
+func (db *HookDb) UpdateAllContext(ctx context.Context, query interface{}, data interface{}) (ChangeInfo, error) {
+	op := &Operation{Op: OpUpdateAll, Query: query, Data: data}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Info, err = db.Db.UpdateAllContext(ctx, op.Query, op.Data)
+		return err
+	})
+
+	return op.Info, err
+}
+
+// Delete, remove the first document matching the query
+func (db *HookDb) Delete(query interface{}) error {
+	return db.DeleteContext(context.Background(), query)
+}
+
+// DeleteContext, remove the first document matching the query
+func (db *HookDb) DeleteContext(ctx context.Context, query interface{}) error {
+	op := &Operation{Op: OpDelete, Query: query}
+	return db.run(ctx, op, func() error {
+		return db.Db.DeleteContext(ctx, op.Query)
+	})
+}
+
+// DeleteAll, remove all documents matching the query
+func (db *HookDb) DeleteAll(query interface{}) (ChangeInfo, error) {
+	return db.DeleteAllContext(context.Background(), query)
+}
+
+// DeleteAllContext, remove all documents matching the query
+func (db *HookDb) DeleteAllContext(ctx context.Context, query interface{}) (ChangeInfo, error) {
+	op := &Operation{Op: OpDeleteAll, Query: query}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Info, err = db.Db.DeleteAllContext(ctx, op.Query)
+		return err
+	})
+
+	return op.Info, err
+}
+
+// Restore, restore soft deleted documents matching the query
+func (db *HookDb) Restore(query interface{}) (ChangeInfo, error) {
+	return db.RestoreContext(context.Background(), query)
+}
+
+// RestoreContext, restore soft deleted documents matching the query
+func (db *HookDb) RestoreContext(ctx context.Context, query interface{}) (ChangeInfo, error) {
+	op := &Operation{Op: OpRestore, Query: query}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Info, err = db.Db.RestoreContext(ctx, op.Query)
+		return err
+	})
+
+	return op.Info, err
+}
+
+// Purge, remove documents soft deleted longer than olderThan
+func (db *HookDb) Purge(olderThan time.Duration) (ChangeInfo, error) {
+	return db.PurgeContext(context.Background(), olderThan)
+}
+
+// PurgeContext, remove documents soft deleted longer than olderThan
+func (db *HookDb) PurgeContext(ctx context.Context, olderThan time.Duration) (ChangeInfo, error) {
+	op := &Operation{Op: OpPurge, OlderThan: olderThan}
+	err := db.run(ctx, op, func() error {
+		var err error
+		op.Info, err = db.Db.PurgeContext(ctx, op.OlderThan)
+		return err
+	})
+
+	return op.Info, err
+}
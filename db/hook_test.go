@@ -0,0 +1,110 @@
+package lxDb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHookDb(t *testing.T) {
+	var _ lxDb.IBaseDb = &lxDb.HookDb{}
+
+	t.Run("Hooks run in order with operation", func(t *testing.T) {
+		mem, users := setupMemory(t)
+		db := lxDb.NewHookDb(mem, TestCollection)
+
+		var calls []string
+		db.Before(func(ctx context.Context, op *lxDb.Operation) error {
+			calls = append(calls, "before "+string(op.Op))
+			return nil
+		})
+		db.After(func(ctx context.Context, op *lxDb.Operation) error {
+			calls = append(calls, "after "+string(op.Op))
+			assert.Equal(t, TestCollection, op.Collection)
+			assert.NoError(t, op.Err)
+			return nil
+		}, lxDb.OpGetCount)
+
+		n, err := db.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users), n)
+
+		assert.NoError(t, db.Update(bson.M{"_id": users[0].Id}, bson.M{"$set": bson.M{"name": "x"}}))
+		assert.Equal(t, []string{"before get_count", "after get_count", "before update"}, calls)
+	})
+
+	t.Run("Before hook vetoes operation", func(t *testing.T) {
+		mem, users := setupMemory(t)
+		db := lxDb.NewHookDb(mem, TestCollection)
+		veto := errors.New("read only")
+		db.Before(func(ctx context.Context, op *lxDb.Operation) error {
+			return veto
+		}, lxDb.OpDelete, lxDb.OpDeleteAll)
+
+		_, err := db.DeleteAll(nil)
+		assert.Equal(t, veto, err)
+
+		n, err := db.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users), n)
+	})
+
+	t.Run("Before hook changes data and query", func(t *testing.T) {
+		mem, users := setupMemory(t)
+		db := lxDb.NewHookDb(mem, TestCollection)
+		db.Before(func(ctx context.Context, op *lxDb.Operation) error {
+			op.Data = bson.M{"$set": bson.M{"name": "changed"}}
+			return nil
+		}, lxDb.OpUpdate)
+		db.Before(func(ctx context.Context, op *lxDb.Operation) error {
+			op.Query = bson.M{"name": "changed"}
+			return nil
+		}, lxDb.OpGetOne)
+
+		assert.NoError(t, db.Update(bson.M{"_id": users[0].Id}, bson.M{"$set": bson.M{"name": "x"}}))
+
+		var result TestUser
+		assert.NoError(t, db.GetOne(nil, &result))
+		assert.Equal(t, users[0].Id, result.Id)
+	})
+
+	t.Run("After hook sees result and error", func(t *testing.T) {
+		mem, _ := setupMemory(t)
+		db := lxDb.NewHookDb(mem, TestCollection)
+
+		var seen error
+		db.After(func(ctx context.Context, op *lxDb.Operation) error {
+			seen = op.Err
+			return nil
+		}, lxDb.OpGetOne)
+		db.After(func(ctx context.Context, op *lxDb.Operation) error {
+			op.Result.(*TestUser).Name = "decrypted"
+			return nil
+		}, lxDb.OpGetOne)
+
+		var result TestUser
+		assert.Equal(t, lxDb.ErrNotFound, db.GetOne(bson.M{"_id": "missing"}, &result))
+		assert.Equal(t, lxDb.ErrNotFound, seen)
+
+		assert.NoError(t, db.GetOne(nil, &result))
+		assert.Equal(t, "decrypted", result.Name)
+		assert.NoError(t, seen)
+	})
+
+	t.Run("After hook error is returned", func(t *testing.T) {
+		mem, _ := setupMemory(t)
+		db := lxDb.NewHookDb(mem, TestCollection)
+		fail := errors.New("cache invalidation failed")
+		db.After(func(ctx context.Context, op *lxDb.Operation) error {
+			return fail
+		}, lxDb.OpUpdateAll)
+
+		info, err := db.UpdateAll(nil, bson.M{"$set": bson.M{"is_active": true}})
+		assert.Equal(t, fail, err)
+		assert.NotZero(t, info.Matched)
+	})
+}
@@ -0,0 +1,262 @@
+package lxDb
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// MigrationsCollection, collection for the applied migrations of all collections
+var MigrationsCollection = "migrations"
+
+// IndexChange, existing index with different options than desired
+type IndexChange struct {
+	Name     string
+	Existing mgo.Index
+	Desired  mgo.Index
+	Options  []string // Names of the changed options
+}
+
+// IndexPlan, differences between desired and existing indexes
+type IndexPlan struct {
+	Add    []mgo.Index
+	Remove []mgo.Index
+	Change []IndexChange
+}
+
+// IndexMigration, record of an applied index migration
+type IndexMigration struct {
+	Id         string    `json:"id" bson:"_id"`
+	Kind       string    `json:"kind" bson:"kind"`
+	Collection string    `json:"collection" bson:"collection"`
+	Version    string    `json:"version" bson:"version"`
+	AppliedAt  time.Time `json:"applied_at" bson:"applied_at"`
+	Changes    []string  `json:"changes" bson:"changes"`
+}
+
+// Empty, true when existing indexes equal the desired indexes
+func (p *IndexPlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Remove) == 0 && len(p.Change) == 0
+}
+
+// Changes, human readable list of the differences
+func (p *IndexPlan) Changes() []string {
+	var changes []string
+	for _, idx := range p.Add {
+		changes = append(changes, "add "+indexName(idx))
+	}
+	for _, idx := range p.Remove {
+		changes = append(changes, "remove "+indexName(idx))
+	}
+	for _, c := range p.Change {
+		changes = append(changes, fmt.Sprintf("change %s (%s)", c.Name, strings.Join(c.Options, ", ")))
+	}
+
+	return changes
+}
+
+// DiffIndexes, compare desired with existing indexes by name, the _id index is never removed
+func DiffIndexes(desired, existing []mgo.Index) *IndexPlan {
+	plan := &IndexPlan{}
+
+	current := map[string]mgo.Index{}
+	for _, idx := range existing {
+		current[indexName(idx)] = idx
+	}
+
+	wanted := map[string]bool{}
+	for _, idx := range desired {
+		name := indexName(idx)
+		wanted[name] = true
+
+		old, ok := current[name]
+		if !ok {
+			plan.Add = append(plan.Add, idx)
+			continue
+		}
+		if opts := indexDiff(old, idx); len(opts) > 0 {
+			plan.Change = append(plan.Change, IndexChange{Name: name, Existing: old, Desired: idx, Options: opts})
+		}
+	}
+
+	for _, idx := range existing {
+		if name := indexName(idx); name != "_id_" && !wanted[name] {
+			plan.Remove = append(plan.Remove, idx)
+		}
+	}
+
+	return plan
+}
+
+// indexDiff, names of options which differ
+func indexDiff(existing, desired mgo.Index) []string {
+	var opts []string
+
+	if !reflect.DeepEqual(normalizeKey(existing.Key), normalizeKey(desired.Key)) {
+		opts = append(opts, "key")
+	}
+	if existing.Unique != desired.Unique {
+		opts = append(opts, "unique")
+	}
+	if existing.Sparse != desired.Sparse {
+		opts = append(opts, "sparse")
+	}
+	if existing.ExpireAfter != desired.ExpireAfter {
+		opts = append(opts, "expire_after")
+	}
+
+	ef, _ := toDoc(existing.PartialFilter)
+	df, _ := toDoc(desired.PartialFilter)
+	if !reflect.DeepEqual(ef, df) {
+		opts = append(opts, "partial_filter")
+	}
+
+	// Server fills collation defaults, compare the values set in desired
+	if dc := desired.Collation; dc != nil {
+		ec := existing.Collation
+		if ec == nil || ec.Locale != dc.Locale ||
+			(dc.Strength != 0 && ec.Strength != dc.Strength) ||
+			(dc.CaseFirst != "" && ec.CaseFirst != dc.CaseFirst) {
+			opts = append(opts, "collation")
+		}
+	} else if existing.Collation != nil {
+		opts = append(opts, "collation")
+	}
+
+	return opts
+}
+
+// normalizeKey, remove optional plus sign of ascending keys
+func normalizeKey(key []string) []string {
+	out := make([]string, len(key))
+	for i, k := range key {
+		out[i] = strings.TrimPrefix(k, "+")
+	}
+
+	return out
+}
+
+// PlanIndexes, compare desired indexes with the indexes of the collection
+func (db *MongoDb) PlanIndexes(desired []mgo.Index) (*IndexPlan, error) {
+	// Copy mongo session (thread safe) and close after function
+	conn := db.Conn.Copy()
+	defer conn.Close()
+
+	existing, err := conn.DB(db.Name).C(db.Collection).Indexes()
+	if err != nil && !isNamespaceNotFound(err) {
+		return nil, err
+	}
+
+	return DiffIndexes(desired, existing), nil
+}
+
+// MigrateIndexes, apply the differences to desired indexes and record the version,
+// changed indexes are dropped and created again
+func (db *MongoDb) MigrateIndexes(version string, desired []mgo.Index) (*IndexPlan, error) {
+	plan, err := db.PlanIndexes(desired)
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy mongo session (thread safe) and close after function
+	conn := db.Conn.Copy()
+	defer conn.Close()
+
+	col := conn.DB(db.Name).C(db.Collection)
+
+	for _, idx := range plan.Remove {
+		if err := col.DropIndexName(indexName(idx)); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range plan.Change {
+		if err := col.DropIndexName(c.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	// EnsureIndex caches known indexes, reset after drop
+	conn.ResetIndexCache()
+
+	for _, idx := range plan.Add {
+		if err := col.EnsureIndex(idx); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range plan.Change {
+		if err := col.EnsureIndex(c.Desired); err != nil {
+			return nil, err
+		}
+	}
+
+	// Record applied version
+	record := IndexMigration{
+		Id:         db.Collection + ":indexes:" + version,
+		Kind:       "indexes",
+		Collection: db.Collection,
+		Version:    version,
+		AppliedAt:  time.Now(),
+		Changes:    plan.Changes(),
+	}
+	if _, err := conn.DB(db.Name).C(MigrationsCollection).UpsertId(record.Id, record); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// IndexMigrations, applied index migrations of the collection ordered by time
+func (db *MongoDb) IndexMigrations() ([]IndexMigration, error) {
+	// Copy mongo session (thread safe) and close after function
+	conn := db.Conn.Copy()
+	defer conn.Close()
+
+	var records []IndexMigration
+	err := conn.DB(db.Name).C(MigrationsCollection).
+		Find(bson.M{"kind": "indexes", "collection": db.Collection}).
+		Sort("applied_at").
+		All(&records)
+
+	return records, err
+}
+
+// isNamespaceNotFound, collection doesn't exist yet
+func isNamespaceNotFound(err error) bool {
+	if qe, ok := err.(*mgo.QueryError); ok && qe.Code == 26 {
+		return true
+	}
+
+	return strings.Contains(err.Error(), "ns does not exist") || strings.Contains(err.Error(), "ns not found")
+}
+
+// indexName, name of index like mgo generates it
+func indexName(idx mgo.Index) string {
+	if idx.Name != "" {
+		return idx.Name
+	}
+
+	parts := make([]string, 0, len(idx.Key))
+	for _, k := range idx.Key {
+		if strings.HasPrefix(k, "$") {
+			if c := strings.Index(k, ":"); c > 1 {
+				parts = append(parts, k[c+1:]+"_"+k[1:c])
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(k, "@"):
+			parts = append(parts, k[1:]+"_2d")
+		case strings.HasPrefix(k, "-"):
+			parts = append(parts, k[1:]+"_-1")
+		default:
+			parts = append(parts, strings.TrimPrefix(k, "+")+"_1")
+		}
+	}
+
+	return strings.Join(parts, "_")
+}
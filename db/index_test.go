@@ -0,0 +1,68 @@
+package lxDb_test
+
+import (
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDiffIndexes(t *testing.T) {
+	existing := []mgo.Index{
+		{Name: "_id_", Key: []string{"_id"}},
+		{Name: "email_1", Key: []string{"email"}, Unique: true},
+		{Name: "name_1", Key: []string{"name"}},
+		{Name: "old_1", Key: []string{"old"}},
+		{Name: "created_1", Key: []string{"created"}, ExpireAfter: time.Hour},
+	}
+
+	t.Run("Report additions, removals and option changes", func(t *testing.T) {
+		desired := []mgo.Index{
+			{Key: []string{"email"}, Unique: true},
+			{Key: []string{"name"}, Unique: true},
+			{Key: []string{"created"}, ExpireAfter: 2 * time.Hour},
+			{Key: []string{"gender", "-age"}, PartialFilter: bson.M{"age": bson.M{"$gt": 18}}},
+		}
+
+		plan := lxDb.DiffIndexes(desired, existing)
+		assert.False(t, plan.Empty())
+		assert.Equal(t, []mgo.Index{desired[3]}, plan.Add)
+		assert.Equal(t, []mgo.Index{existing[3]}, plan.Remove)
+		assert.Len(t, plan.Change, 2)
+		assert.Equal(t, "name_1", plan.Change[0].Name)
+		assert.Equal(t, []string{"unique"}, plan.Change[0].Options)
+		assert.Equal(t, "created_1", plan.Change[1].Name)
+		assert.Equal(t, []string{"expire_after"}, plan.Change[1].Options)
+		assert.Equal(t, []string{
+			"add gender_1_age_-1",
+			"remove old_1",
+			"change name_1 (unique)",
+			"change created_1 (expire_after)",
+		}, plan.Changes())
+	})
+
+	t.Run("Return empty plan without drift", func(t *testing.T) {
+		desired := []mgo.Index{
+			{Key: []string{"+email"}, Unique: true},
+			{Key: []string{"name"}},
+			{Key: []string{"old"}},
+			{Key: []string{"created"}, ExpireAfter: time.Hour},
+		}
+
+		assert.True(t, lxDb.DiffIndexes(desired, existing).Empty())
+	})
+
+	t.Run("Compare partial filters and collations", func(t *testing.T) {
+		current := []mgo.Index{{Name: "a_1", Key: []string{"a"}, PartialFilter: bson.M{"a": bson.M{"$exists": true}},
+			Collation: &mgo.Collation{Locale: "de", Strength: 3}}}
+
+		same := []mgo.Index{{Key: []string{"a"}, PartialFilter: bson.M{"a": bson.M{"$exists": true}},
+			Collation: &mgo.Collation{Locale: "de"}}}
+		assert.True(t, lxDb.DiffIndexes(same, current).Empty())
+
+		changed := []mgo.Index{{Key: []string{"a"}, Collation: &mgo.Collation{Locale: "de", Strength: 2}}}
+		assert.Equal(t, []string{"partial_filter", "collation"}, lxDb.DiffIndexes(changed, current).Change[0].Options)
+	})
+}
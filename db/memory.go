@@ -0,0 +1,669 @@
+package lxDb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// MemoryStore, in-memory database server, shared by MemoryDb instances like a mgo session
+type MemoryStore struct {
+	mu          sync.Mutex
+	collections map[string]*memoryCollection
+}
+
+// memoryCollection, documents and indexes of one collection
+type memoryCollection struct {
+	mu      sync.RWMutex
+	docs    []bson.M
+	indexes []mgo.Index
+}
+
+// NewMemoryStore, return a new empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{collections: map[string]*memoryCollection{}}
+}
+
+// DropCollection, remove collection with documents and indexes
+func (s *MemoryStore) DropCollection(dbName, collection string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.collections, dbName+"."+collection)
+}
+
+// collection, return collection and create it when needed
+func (s *MemoryStore) collection(dbName, collection string) *memoryCollection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := dbName + "." + collection
+	c, ok := s.collections[name]
+	if !ok {
+		c = &memoryCollection{}
+		s.collections[name] = c
+	}
+
+	return c
+}
+
+// MemoryDb, in-memory implementation of IBaseDb for tests without mongodb
+type MemoryDb struct {
+	Store      *MemoryStore
+	Name       string
+	Collection string
+
+	// AllowedFields, allow-list for sort and fields options,
+	// all fields are allowed when empty
+	AllowedFields []string
+
+	// CursorKey, secret for signing the continuation tokens of GetPage
+	CursorKey []byte
+
+	// VersionField, field incremented on every update, UpdateContext checks it
+	// against the version of WithVersion, disabled when empty
+	VersionField string
+
+	// SoftDelete, Delete marks documents with deleted_at and deleted_by,
+	// reads and updates skip them unless ctx is WithDeleted
+	SoftDelete bool
+
+	// Stamps, fields stamped with Clock and the actor of WithActor on write,
+	// e.g. DefaultStamps, disabled when nil
+	Stamps *Stamps
+
+	// Clock, time source for stamps and soft delete, time.Now when nil
+	Clock func() time.Time
+}
+
+func NewMemoryDb(store *MemoryStore, dbName, collection string) *MemoryDb {
+	return &MemoryDb{
+		Store:      store,
+		Name:       dbName,
+		Collection: collection,
+	}
+}
+
+// Setup, register indexes, unique indexes are enforced on write
+func (db *MemoryDb) Setup(indexes []mgo.Index) error {
+	col := db.Store.collection(db.Name, db.Collection)
+	col.mu.Lock()
+	defer col.mu.Unlock()
+
+	for _, i := range indexes {
+		if len(i.Key) == 0 {
+			return fmt.Errorf("invalid index key: no fields provided")
+		}
+
+		// Existing documents must not violate the new index
+		if i.Unique {
+			for n, doc := range col.docs {
+				if err := checkUnique([]mgo.Index{i}, col.docs[:n], doc, -1); err != nil {
+					return err
+				}
+			}
+		}
+
+		col.indexes = append(col.indexes, i)
+	}
+
+	return nil
+}
+
+// Create, insert a new document in collection
+func (db *MemoryDb) Create(data interface{}) error {
+	return db.CreateContext(context.Background(), data)
+}
+
+// CreateContext, insert a new document in collection
+func (db *MemoryDb) CreateContext(ctx context.Context, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := db.Stamps.create(ctx, clockTime(db.Clock), data)
+	if err != nil {
+		return err
+	}
+	data, err = versionCreate(db.VersionField, data)
+	if err != nil {
+		return err
+	}
+	doc, err := toDoc(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectId()
+	}
+
+	col := db.Store.collection(db.Name, db.Collection)
+	col.mu.Lock()
+	defer col.mu.Unlock()
+
+	if err := checkUnique(col.indexes, col.docs, doc, -1); err != nil {
+		return err
+	}
+	col.docs = append(col.docs, doc)
+
+	return nil
+}
+
+// CreateAll, insert documents in order, stops at the first failed document
+func (db *MemoryDb) CreateAll(data []interface{}) error {
+	return db.CreateAllContext(context.Background(), data)
+}
+
+// CreateAllContext, insert documents in order, stops at the first failed document
+func (db *MemoryDb) CreateAllContext(ctx context.Context, data []interface{}) error {
+	for _, d := range data {
+		if err := db.CreateContext(ctx, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetOne, find the first document matching the query
+func (db *MemoryDb) GetOne(query interface{}, result interface{}) error {
+	return db.GetOneContext(context.Background(), query, result)
+}
+
+// GetOneContext, find the first document matching the query
+func (db *MemoryDb) GetOneContext(ctx context.Context, query interface{}, result interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return err
+	}
+
+	docs, err := db.find(query, 1)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return ErrNotFound
+	}
+
+	raw, err := toRaw(docs[0])
+	if err != nil {
+		return err
+	}
+
+	return raw.Unmarshal(result)
+}
+
+// GetAll, find all documents matching the query,
+// returns the total count of matching documents when opts.Count is set
+func (db *MemoryDb) GetAll(query interface{}, result interface{}, opts *Options) (int, error) {
+	return db.GetAllContext(context.Background(), query, result, opts)
+}
+
+// GetAllContext, find all documents matching the query,
+// returns the total count of matching documents when opts.Count is set
+func (db *MemoryDb) GetAllContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := opts.Validate(db.AllowedFields); err != nil {
+		return 0, err
+	}
+
+	docs, err := db.find(query, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	if opts.Count {
+		n = len(docs)
+	}
+
+	sortDocs(docs, opts.SortKeys())
+	docs = window(docs, opts.Skip, opts.Limit)
+
+	return n, decodeDocs(docs, opts.Selector(), result)
+}
+
+// GetPage, find one page of documents matching the query with continuation tokens,
+// pages are ordered by the single sort key of opts and _id, skip is ignored
+func (db *MemoryDb) GetPage(query interface{}, result interface{}, opts *Options) (*Page, error) {
+	return db.GetPageContext(context.Background(), query, result, opts)
+}
+
+// GetPageContext, find one page of documents matching the query with continuation tokens,
+// pages are ordered by the single sort key of opts and _id, skip is ignored
+func (db *MemoryDb) GetPageContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := opts.Validate(db.AllowedFields); err != nil {
+		return nil, err
+	}
+
+	ks, err := newKeyset(query, opts, db.CursorKey)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	if opts.Count {
+		if total, err = db.GetCountContext(ctx, query); err != nil {
+			return nil, err
+		}
+	}
+
+	docs, err := db.find(ks.filter, -1)
+	if err != nil {
+		return nil, err
+	}
+	sortDocs(docs, ks.sort)
+	docs = window(docs, 0, ks.fetchLimit())
+
+	raws := make([]bson.Raw, len(docs))
+	for i, doc := range docs {
+		if raws[i], err = toRaw(project(doc, ks.selector(opts))); err != nil {
+			return nil, err
+		}
+	}
+
+	raws, page, err := ks.page(raws, db.CursorKey)
+	if err != nil {
+		return nil, err
+	}
+	page.Total = total
+
+	return page, decodeAll(raws, result)
+}
+
+// GetCount, count documents matching the query
+func (db *MemoryDb) GetCount(query interface{}) (int, error) {
+	return db.GetCountContext(context.Background(), query)
+}
+
+// GetCountContext, count documents matching the query
+func (db *MemoryDb) GetCountContext(ctx context.Context, query interface{}) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return 0, err
+	}
+
+	docs, err := db.find(query, -1)
+	return len(docs), err
+}
+
+// Update, update the first document matching the query
+func (db *MemoryDb) Update(query interface{}, data interface{}) error {
+	return db.UpdateContext(context.Background(), query, data)
+}
+
+// UpdateContext, update the first document matching the query,
+// returns *ConflictError when the version of WithVersion is outdated
+func (db *MemoryDb) UpdateContext(ctx context.Context, query interface{}, data interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	q, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return err
+	}
+	q, data, err = db.Stamps.update(ctx, clockTime(db.Clock), q, data, func(q interface{}, result interface{}) error {
+		return db.GetOneContext(ctx, q, result)
+	})
+	if err != nil {
+		return err
+	}
+	q, u, version, checked, err := versionUpdate(ctx, db.VersionField, q, data, true)
+	if err != nil {
+		return err
+	}
+
+	info, err := db.update(q, u, 1)
+	if err == nil && info.Matched == 0 {
+		if checked {
+			n, err := db.GetCountContext(ctx, query)
+			return versionConflict(n, err, version)
+		}
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// UpdateAll, update all documents matching the query
+func (db *MemoryDb) UpdateAll(query interface{}, data interface{}) (ChangeInfo, error) {
+	return db.UpdateAllContext(context.Background(), query, data)
+}
+
+// UpdateAllContext, update all documents matching the query
+func (db *MemoryDb) UpdateAllContext(ctx context.Context, query interface{}, data interface{}) (ChangeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ChangeInfo{}, err
+	}
+
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+	query, data, err = db.Stamps.update(ctx, clockTime(db.Clock), query, data, nil)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+	query, data, _, _, err = versionUpdate(ctx, db.VersionField, query, data, false)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	return db.update(query, data, -1)
+}
+
+// Delete, remove the first document matching the query
+func (db *MemoryDb) Delete(query interface{}) error {
+	return db.DeleteContext(context.Background(), query)
+}
+
+// DeleteContext, remove the first document matching the query
+func (db *MemoryDb) DeleteContext(ctx context.Context, query interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := db.delete(ctx, query, 1)
+	if err == nil && info.Removed == 0 {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// DeleteAll, remove all documents matching the query
+func (db *MemoryDb) DeleteAll(query interface{}) (ChangeInfo, error) {
+	return db.DeleteAllContext(context.Background(), query)
+}
+
+// DeleteAllContext, remove all documents matching the query
+func (db *MemoryDb) DeleteAllContext(ctx context.Context, query interface{}) (ChangeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ChangeInfo{}, err
+	}
+
+	return db.delete(ctx, query, -1)
+}
+
+// Restore, restore soft deleted documents matching the query
+func (db *MemoryDb) Restore(query interface{}) (ChangeInfo, error) {
+	return db.RestoreContext(context.Background(), query)
+}
+
+// RestoreContext, restore soft deleted documents matching the query
+func (db *MemoryDb) RestoreContext(ctx context.Context, query interface{}) (ChangeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ChangeInfo{}, err
+	}
+	if !db.SoftDelete {
+		return ChangeInfo{}, ErrNoSoftDelete
+	}
+
+	query, err := onlyDeleted(query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	return db.update(query, restoreUpdate(), -1)
+}
+
+// Purge, remove documents soft deleted longer than olderThan
+func (db *MemoryDb) Purge(olderThan time.Duration) (ChangeInfo, error) {
+	return db.PurgeContext(context.Background(), olderThan)
+}
+
+// PurgeContext, remove documents soft deleted longer than olderThan
+func (db *MemoryDb) PurgeContext(ctx context.Context, olderThan time.Duration) (ChangeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ChangeInfo{}, err
+	}
+	if !db.SoftDelete {
+		return ChangeInfo{}, ErrNoSoftDelete
+	}
+
+	return db.remove(purgeQuery(clockTime(db.Clock), olderThan), -1)
+}
+
+// delete, remove or soft delete matching documents, limit -1 for all
+func (db *MemoryDb) delete(ctx context.Context, query interface{}, limit int) (ChangeInfo, error) {
+	if !db.SoftDelete {
+		return db.remove(query, limit)
+	}
+
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	info, err := db.update(query, deleteUpdate(ctx, clockTime(db.Clock)), limit)
+	info.Removed, info.Updated = info.Updated, 0
+
+	return info, err
+}
+
+// find, return copies of matching documents in insertion order, limit -1 for all
+func (db *MemoryDb) find(query interface{}, limit int) ([]bson.M, error) {
+	q, err := toDoc(query)
+	if err != nil {
+		return nil, err
+	}
+
+	col := db.Store.collection(db.Name, db.Collection)
+	col.mu.RLock()
+	defer col.mu.RUnlock()
+
+	var docs []bson.M
+	for _, doc := range col.docs {
+		if limit >= 0 && len(docs) >= limit {
+			break
+		}
+
+		ok, err := match(doc, q)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, copyDoc(doc))
+		}
+	}
+
+	return docs, nil
+}
+
+// update, apply update to matching documents, limit -1 for all
+func (db *MemoryDb) update(query interface{}, data interface{}, limit int) (ChangeInfo, error) {
+	q, err := toDoc(query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+	u, err := toDoc(data)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	col := db.Store.collection(db.Name, db.Collection)
+	col.mu.Lock()
+	defer col.mu.Unlock()
+
+	info := ChangeInfo{}
+	for i, doc := range col.docs {
+		if limit >= 0 && info.Matched >= limit {
+			break
+		}
+
+		ok, err := match(doc, q)
+		if err != nil {
+			return info, err
+		}
+		if !ok {
+			continue
+		}
+		info.Matched++
+
+		updated, err := applyUpdate(doc, u)
+		if err != nil {
+			return info, err
+		}
+		if err := checkUnique(col.indexes, col.docs, updated, i); err != nil {
+			return info, err
+		}
+		if !equalValues(doc, updated) {
+			col.docs[i] = updated
+			info.Updated++
+		}
+	}
+
+	return info, nil
+}
+
+// remove, remove matching documents, limit -1 for all
+func (db *MemoryDb) remove(query interface{}, limit int) (ChangeInfo, error) {
+	q, err := toDoc(query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	col := db.Store.collection(db.Name, db.Collection)
+	col.mu.Lock()
+	defer col.mu.Unlock()
+
+	info := ChangeInfo{}
+	kept := col.docs[:0:0]
+	for _, doc := range col.docs {
+		if limit < 0 || info.Removed < limit {
+			ok, err := match(doc, q)
+			if err != nil {
+				return ChangeInfo{}, err
+			}
+			if ok {
+				info.Removed++
+				info.Matched++
+				continue
+			}
+		}
+		kept = append(kept, doc)
+	}
+	col.docs = kept
+
+	return info, nil
+}
+
+// window, apply skip and limit
+func window(docs []bson.M, skip, limit int) []bson.M {
+	if skip >= len(docs) {
+		return nil
+	}
+	if skip > 0 {
+		docs = docs[skip:]
+	}
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+
+	return docs
+}
+
+// decodeDocs, project documents and unmarshal them into result
+func decodeDocs(docs []bson.M, sel bson.M, result interface{}) error {
+	raws := make([]bson.Raw, len(docs))
+	for i, doc := range docs {
+		var err error
+		if raws[i], err = toRaw(project(doc, sel)); err != nil {
+			return err
+		}
+	}
+
+	return decodeAll(raws, result)
+}
+
+// checkUnique, check document against unique indexes and _id,
+// skip is the position of the document itself on update
+func checkUnique(indexes []mgo.Index, docs []bson.M, doc bson.M, skip int) error {
+	all := append([]mgo.Index{{Key: []string{"_id"}, Unique: true, Name: "_id_"}}, indexes...)
+
+	for _, idx := range all {
+		if !idx.Unique {
+			continue
+		}
+
+		key, ok := indexKey(idx, doc)
+		if !ok {
+			continue
+		}
+
+		for i, other := range docs {
+			if i == skip {
+				continue
+			}
+			if otherKey, ok := indexKey(idx, other); ok && equalValues(key, otherKey) {
+				return &mgo.LastError{
+					Code: 11000,
+					Err:  fmt.Sprintf("E11000 duplicate key error index: %s dup key: %v", indexName(idx), key),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexKey, values of the index fields, false if document is not part of the index
+func indexKey(idx mgo.Index, doc bson.M) ([]interface{}, bool) {
+	if idx.PartialFilter != nil {
+		if ok, _ := match(doc, bson.M(idx.PartialFilter)); !ok {
+			return nil, false
+		}
+	}
+
+	key := make([]interface{}, len(idx.Key))
+	found := false
+	for i, k := range idx.Key {
+		k = strings.TrimLeft(k, "-+")
+		if strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+
+		v, ok := lookupPath(doc, k)
+		found = found || ok
+		key[i] = v
+	}
+
+	return key, found || !idx.Sparse
+}
@@ -0,0 +1,671 @@
+package lxDb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// toDoc, normalize document or query to bson.M with bson types
+func toDoc(v interface{}) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+	if raw, ok := v.(bson.Raw); ok {
+		var m bson.M
+		return m, raw.Unmarshal(&m)
+	}
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// toRaw, serialize document for decoding into results
+func toRaw(doc bson.M) (bson.Raw, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return bson.Raw{}, err
+	}
+
+	return bson.Raw{Kind: 3, Data: data}, nil
+}
+
+// match, check if document matches query
+func match(doc bson.M, query bson.M) (bool, error) {
+	for key, cond := range query {
+		var ok bool
+		var err error
+
+		switch key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("lxDb: unsupported query operator %s", key)
+			}
+			ok, err = matchField(doc, key, cond)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// matchLogical, match $and, $or and $nor
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	list, ok := cond.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("lxDb: %s needs an array", op)
+	}
+
+	for _, item := range list {
+		sub, ok := item.(bson.M)
+		if !ok {
+			return false, fmt.Errorf("lxDb: %s needs an array of documents", op)
+		}
+
+		ok, err := match(doc, sub)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case op == "$and" && !ok:
+			return false, nil
+		case op == "$or" && ok:
+			return true, nil
+		case op == "$nor" && ok:
+			return false, nil
+		}
+	}
+
+	return op != "$or", nil
+}
+
+// matchField, match condition for a field
+func matchField(doc bson.M, path string, cond interface{}) (bool, error) {
+	value, exists := lookupPath(doc, path)
+
+	// Operator document or plain equality
+	ops, isOps := cond.(bson.M)
+	if isOps && len(ops) > 0 {
+		for k := range ops {
+			if !strings.HasPrefix(k, "$") {
+				isOps = false
+				break
+			}
+		}
+	}
+	if !isOps || len(ops) == 0 {
+		if re, ok := cond.(bson.RegEx); ok {
+			return matchRegex(value, re)
+		}
+		return matchEq(value, exists, cond), nil
+	}
+
+	for op, arg := range ops {
+		ok, err := matchOp(value, exists, op, arg, ops)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// matchOp, match a single comparison operator
+func matchOp(value interface{}, exists bool, op string, arg interface{}, ops bson.M) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEq(value, exists, arg), nil
+	case "$ne":
+		return !matchEq(value, exists, arg), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		if !exists {
+			return false, nil
+		}
+		return anyValue(value, func(v interface{}) bool {
+			if typeRank(v) != typeRank(arg) {
+				return false
+			}
+			c := compareValues(v, arg)
+			switch op {
+			case "$gt":
+				return c > 0
+			case "$gte":
+				return c >= 0
+			case "$lt":
+				return c < 0
+			}
+			return c <= 0
+		}), nil
+	case "$in", "$nin":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("lxDb: %s needs an array", op)
+		}
+		in := false
+		for _, item := range list {
+			if matchEq(value, exists, item) {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in"), nil
+	case "$all":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("lxDb: $all needs an array")
+		}
+		for _, item := range list {
+			if !matchEq(value, exists, item) {
+				return false, nil
+			}
+		}
+		return exists && len(list) > 0, nil
+	case "$exists":
+		want, _ := arg.(bool)
+		return exists == want, nil
+	case "$size":
+		list, ok := value.([]interface{})
+		return ok && compareValues(len(list), arg) == 0, nil
+	case "$regex":
+		re := bson.RegEx{}
+		switch p := arg.(type) {
+		case string:
+			re.Pattern = p
+		case bson.RegEx:
+			re = p
+		default:
+			return false, fmt.Errorf("lxDb: $regex needs a string")
+		}
+		if options, ok := ops["$options"].(string); ok {
+			re.Options = options
+		}
+		return matchRegex(value, re)
+	case "$options":
+		return true, nil
+	case "$not":
+		var ok bool
+		var err error
+		switch a := arg.(type) {
+		case bson.M:
+			ok, err = matchField(bson.M{"v": value}, "v", a)
+			if !exists {
+				ok = false
+			}
+		case bson.RegEx:
+			ok, err = matchRegex(value, a)
+		default:
+			return false, fmt.Errorf("lxDb: $not needs a document or regex")
+		}
+		return !ok, err
+	case "$elemMatch":
+		sub, ok := arg.(bson.M)
+		list, isList := value.([]interface{})
+		if !ok || !isList {
+			return false, nil
+		}
+		for _, item := range list {
+			var matched bool
+			var err error
+			if elem, isDoc := item.(bson.M); isDoc && !isOperatorDoc(sub) {
+				matched, err = match(elem, sub)
+			} else {
+				matched, err = matchField(bson.M{"v": item}, "v", sub)
+			}
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	return false, fmt.Errorf("lxDb: unsupported query operator %s", op)
+}
+
+// matchEq, equality with array contains semantics
+func matchEq(value interface{}, exists bool, arg interface{}) bool {
+	if arg == nil {
+		return !exists || value == nil
+	}
+	if !exists {
+		return false
+	}
+
+	return anyValue(value, func(v interface{}) bool { return equalValues(v, arg) }) || equalValues(value, arg)
+}
+
+// matchRegex, match string value against regex with mongo options
+func matchRegex(value interface{}, re bson.RegEx) (bool, error) {
+	flags := ""
+	for _, o := range re.Options {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+
+	pattern := re.Pattern
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	return anyValue(value, func(v interface{}) bool {
+		s, ok := v.(string)
+		return ok && rx.MatchString(s)
+	}), nil
+}
+
+// anyValue, check value or any element of array value
+func anyValue(value interface{}, fn func(v interface{}) bool) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, v := range list {
+			if fn(v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return fn(value)
+}
+
+func isOperatorDoc(m bson.M) bool {
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookupPath, get value of dotted path
+func lookupPath(doc bson.M, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(bson.M)
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// setPath, set value of dotted path, creates embedded documents
+func setPath(doc bson.M, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	m := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part]
+		if !ok {
+			sub := bson.M{}
+			m[part] = sub
+			m = sub
+			continue
+		}
+		if m, ok = next.(bson.M); !ok {
+			return fmt.Errorf("lxDb: can't set %s, %s is not a document", path, part)
+		}
+	}
+	m[parts[len(parts)-1]] = value
+
+	return nil
+}
+
+// unsetPath, remove value of dotted path
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	m := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(bson.M)
+		if !ok {
+			return
+		}
+		m = next
+	}
+	delete(m, parts[len(parts)-1])
+}
+
+// typeRank, bson sort order of types
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 1
+	case int, int32, int64, float64, float32:
+		return 2
+	case string, bson.Symbol:
+		return 3
+	case bson.M, bson.D:
+		return 4
+	case []interface{}:
+		return 5
+	case []byte, bson.Binary:
+		return 6
+	case bson.ObjectId:
+		return 7
+	case bool:
+		return 8
+	case time.Time:
+		return 9
+	case bson.MongoTimestamp:
+		return 10
+	case bson.RegEx:
+		return 11
+	}
+
+	return 12
+}
+
+// sortValue, value in its comparable form, symbols compare as strings and binaries as bytes
+func sortValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case bson.Symbol:
+		return string(x)
+	case bson.Binary:
+		return x.Data
+	}
+
+	return v
+}
+
+// compareValues, compare values in bson sort order
+func compareValues(a, b interface{}) int {
+	a, b = sortValue(a), sortValue(b)
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return ra - rb
+	}
+
+	switch x := a.(type) {
+	case nil:
+		return 0
+	case string:
+		return strings.Compare(x, b.(string))
+	case bson.ObjectId:
+		return strings.Compare(string(x), string(b.(bson.ObjectId)))
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case time.Time:
+		y := b.(time.Time)
+		switch {
+		case x.Before(y):
+			return -1
+		case x.After(y):
+			return 1
+		}
+		return 0
+	case []byte:
+		return bytes.Compare(x, b.([]byte))
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return len(x) - len(y)
+	}
+
+	if ra == 2 {
+		fa, fb := toFloat(a), toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+
+	// Documents and others compare by their bson representation
+	da, _ := bson.Marshal(bson.M{"v": a})
+	db, _ := bson.Marshal(bson.M{"v": b})
+
+	return bytes.Compare(da, db)
+}
+
+// equalValues, check values for equality, numbers are equal across types
+func equalValues(a, b interface{}) bool {
+	if typeRank(a) != typeRank(b) {
+		return false
+	}
+	if ma, ok := a.(bson.M); ok {
+		return reflect.DeepEqual(ma, b)
+	}
+
+	return compareValues(a, b) == 0
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	case float64:
+		return n
+	}
+
+	return 0
+}
+
+// sortDocs, stable sort documents by mgo sort keys
+func sortDocs(docs []bson.M, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			desc := strings.HasPrefix(key, "-")
+			name := strings.TrimPrefix(strings.TrimPrefix(key, "-"), "+")
+
+			a, _ := lookupPath(docs[i], name)
+			b, _ := lookupPath(docs[j], name)
+			c := compareValues(a, b)
+			if c == 0 {
+				continue
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// project, apply selector to document
+func project(doc bson.M, sel bson.M) bson.M {
+	if len(sel) == 0 {
+		return doc
+	}
+
+	include := false
+	for k, v := range sel {
+		if k != "_id" && v == 1 {
+			include = true
+		}
+	}
+
+	if !include {
+		out := copyDoc(doc)
+		for k := range sel {
+			unsetPath(out, k)
+		}
+		return out
+	}
+
+	out := bson.M{}
+	if v, ok := sel["_id"]; !ok || v != 0 {
+		if id, ok := doc["_id"]; ok {
+			out["_id"] = id
+		}
+	}
+	for k, v := range sel {
+		if k == "_id" || v != 1 {
+			continue
+		}
+		if value, ok := lookupPath(doc, k); ok {
+			setPath(out, k, value)
+		}
+	}
+
+	return out
+}
+
+// copyDoc, deep copy of document
+func copyDoc(doc bson.M) bson.M {
+	out, _ := toDoc(doc)
+	return out
+}
+
+// applyUpdate, apply update operators or replacement to a copy of the document
+func applyUpdate(doc bson.M, update bson.M) (bson.M, error) {
+	isOps := false
+	for k := range update {
+		if strings.HasPrefix(k, "$") {
+			isOps = true
+			break
+		}
+	}
+
+	// Replacement keeps the _id
+	if !isOps {
+		out := copyDoc(update)
+		out["_id"] = doc["_id"]
+		return out, nil
+	}
+
+	out := copyDoc(doc)
+	for op, arg := range update {
+		fields, ok := arg.(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("lxDb: %s needs a document", op)
+		}
+
+		for path, v := range fields {
+			if path == "_id" && op != "$setOnInsert" {
+				return nil, fmt.Errorf("lxDb: can't modify _id")
+			}
+
+			current, exists := lookupPath(out, path)
+
+			var err error
+			switch op {
+			case "$set":
+				err = setPath(out, path, v)
+			case "$unset":
+				unsetPath(out, path)
+			case "$setOnInsert":
+			case "$inc":
+				if exists && typeRank(current) != 2 || typeRank(v) != 2 {
+					return nil, fmt.Errorf("lxDb: $inc needs numbers for %s", path)
+				}
+				err = setPath(out, path, addNumbers(current, v))
+			case "$currentDate":
+				err = setPath(out, path, time.Now())
+			case "$push", "$addToSet":
+				list, _ := current.([]interface{})
+				if exists && list == nil {
+					return nil, fmt.Errorf("lxDb: %s needs an array for %s", op, path)
+				}
+				items := []interface{}{v}
+				if each, ok := v.(bson.M); ok {
+					if e, ok := each["$each"].([]interface{}); ok {
+						items = e
+					}
+				}
+				for _, item := range items {
+					if op == "$addToSet" && matchEq(list, true, item) {
+						continue
+					}
+					list = append(list, item)
+				}
+				err = setPath(out, path, list)
+			case "$pull":
+				list, _ := current.([]interface{})
+				kept := []interface{}{}
+				for _, item := range list {
+					if !equalValues(item, v) {
+						kept = append(kept, item)
+					}
+				}
+				if exists {
+					err = setPath(out, path, kept)
+				}
+			default:
+				return nil, fmt.Errorf("lxDb: unsupported update operator %s", op)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// addNumbers, add numbers and keep integer type when possible
+func addNumbers(a, b interface{}) interface{} {
+	switch x := a.(type) {
+	case nil:
+		return b
+	case int:
+		if y, ok := b.(int); ok {
+			return x + y
+		}
+	case int64:
+		switch y := b.(type) {
+		case int:
+			return x + int64(y)
+		case int64:
+			return x + y
+		}
+	}
+
+	return toFloat(a) + toFloat(b)
+}
@@ -0,0 +1,497 @@
+package lxDb_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+)
+
+// setupMemory, create memory db with test data
+func setupMemory(t *testing.T) (*lxDb.MemoryDb, []TestUser) {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+	if err := db.Setup([]mgo.Index{
+		{Key: []string{"name"}},
+		{Key: []string{"email"}, Unique: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile("../tests/fixtures/MOCK_DATA.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var users []TestUser
+	if err := json.Unmarshal(raw, &users); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range users {
+		users[i].Id = bson.NewObjectId()
+		if err := db.Create(users[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db, users
+}
+
+func countUsers(users []TestUser, fn func(u TestUser) bool) int {
+	n := 0
+	for _, u := range users {
+		if fn(u) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMemoryDb_Create(t *testing.T) {
+	t.Run("Create user and find it", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		user := TestUser{Id: bson.NewObjectId(), Name: "Otto", Email: "otto@example.com"}
+		assert.NoError(t, db.Create(&user))
+
+		var result TestUser
+		assert.NoError(t, db.GetOne(bson.M{"_id": user.Id}, &result))
+		assert.Equal(t, user, result)
+	})
+
+	t.Run("Generate _id when missing", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		assert.NoError(t, db.Create(bson.M{"name": "Otto"}))
+
+		var result bson.M
+		assert.NoError(t, db.GetOne(nil, &result))
+		assert.IsType(t, bson.ObjectId(""), result["_id"])
+	})
+
+	t.Run("Return duplicate error for unique index", func(t *testing.T) {
+		db, users := setupMemory(t)
+		err := db.Create(TestUser{Id: bson.NewObjectId(), Email: users[0].Email})
+		assert.True(t, mgo.IsDup(err))
+
+		err = db.Create(users[1])
+		assert.True(t, mgo.IsDup(err))
+	})
+
+	t.Run("Share documents between instances of the same store", func(t *testing.T) {
+		store := lxDb.NewMemoryStore()
+		assert.NoError(t, lxDb.NewMemoryDb(store, TestDbName, TestCollection).Create(bson.M{"name": "Otto"}))
+
+		n, err := lxDb.NewMemoryDb(store, TestDbName, TestCollection).GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		store.DropCollection(TestDbName, TestCollection)
+		n, _ = lxDb.NewMemoryDb(store, TestDbName, TestCollection).GetCount(nil)
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestMemoryDb_CreateAll(t *testing.T) {
+	db, users := setupMemory(t)
+
+	t.Run("Insert documents in order", func(t *testing.T) {
+		assert.NoError(t, db.CreateAll([]interface{}{
+			&TestUser{Id: bson.NewObjectId(), Name: "Otto", Email: "otto@example.com"},
+			&TestUser{Id: bson.NewObjectId(), Name: "Emil", Email: "emil@example.com"},
+		}))
+
+		n, err := db.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users)+2, n)
+	})
+
+	t.Run("Stop at first duplicate", func(t *testing.T) {
+		err := db.CreateAll([]interface{}{
+			&TestUser{Id: bson.NewObjectId(), Name: "Hans", Email: "hans@example.com"},
+			&TestUser{Id: bson.NewObjectId(), Name: "Otto", Email: "otto@example.com"},
+			&TestUser{Id: bson.NewObjectId(), Name: "Karl", Email: "karl@example.com"},
+		})
+		assert.True(t, mgo.IsDup(err))
+
+		n, err := db.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users)+3, n)
+	})
+}
+
+func TestMemoryDb_Setup(t *testing.T) {
+	t.Run("Return error when existing documents violate unique index", func(t *testing.T) {
+		db, _ := setupMemory(t)
+		err := db.Setup([]mgo.Index{{Key: []string{"gender"}, Unique: true}})
+		assert.True(t, mgo.IsDup(err))
+	})
+
+	t.Run("Ignore documents without fields for sparse index", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		assert.NoError(t, db.Setup([]mgo.Index{{Key: []string{"login"}, Unique: true, Sparse: true}}))
+		assert.NoError(t, db.Create(bson.M{"name": "a"}))
+		assert.NoError(t, db.Create(bson.M{"name": "b"}))
+		assert.NoError(t, db.Create(bson.M{"login": "c"}))
+		assert.True(t, mgo.IsDup(db.Create(bson.M{"login": "c"})))
+	})
+}
+
+func TestMemoryDb_GetOne(t *testing.T) {
+	db, users := setupMemory(t)
+
+	t.Run("Find user by email", func(t *testing.T) {
+		var result TestUser
+		assert.NoError(t, db.GetOne(bson.M{"email": users[3].Email}, &result))
+		assert.Equal(t, users[3], result)
+	})
+
+	t.Run("Return not found", func(t *testing.T) {
+		var result TestUser
+		assert.Equal(t, lxDb.ErrNotFound, db.GetOne(bson.M{"email": "unknown"}, &result))
+	})
+}
+
+func TestMemoryDb_GetAll(t *testing.T) {
+	db, users := setupMemory(t)
+
+	t.Run("Find all users with count, sort, skip and limit", func(t *testing.T) {
+		var result []TestUser
+		n, err := db.GetAll(nil, &result, &lxDb.Options{Skip: 5, Limit: 10, Count: true, Sort: "-name"})
+		assert.NoError(t, err)
+		assert.Equal(t, len(users), n)
+
+		names := make([]string, len(users))
+		for i, u := range users {
+			names[i] = u.Name
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+		assert.Len(t, result, 10)
+		for i, u := range result {
+			assert.Equal(t, names[i+5], u.Name)
+		}
+	})
+
+	t.Run("Return only selected fields", func(t *testing.T) {
+		var result []bson.M
+		_, err := db.GetAll(bson.M{"email": users[0].Email}, &result, &lxDb.Options{Fields: "name"})
+		assert.NoError(t, err)
+		assert.Equal(t, []bson.M{{"_id": users[0].Id, "name": users[0].Name}}, result)
+	})
+
+	t.Run("Reject fields not in allow-list", func(t *testing.T) {
+		db.AllowedFields = []string{"name"}
+		defer func() { db.AllowedFields = nil }()
+
+		var result []TestUser
+		_, err := db.GetAll(nil, &result, &lxDb.Options{Sort: "email"})
+		assert.IsType(t, &lxDb.OptionsError{}, err)
+	})
+
+	t.Run("Match query operators", func(t *testing.T) {
+		tests := []struct {
+			query    bson.M
+			expected int
+		}{
+			{bson.M{"is_active": true}, countUsers(users, func(u TestUser) bool { return u.IsActive })},
+			{bson.M{"gender": bson.M{"$ne": "Male"}}, countUsers(users, func(u TestUser) bool { return u.Gender != "Male" })},
+			{bson.M{"gender": bson.M{"$in": []string{"Male", "Female"}}}, len(users)},
+			{bson.M{"name": bson.M{"$gte": "M"}}, countUsers(users, func(u TestUser) bool { return u.Name >= "M" })},
+			{bson.M{"name": bson.RegEx{Pattern: "^a", Options: "i"}}, countUsers(users, func(u TestUser) bool { return u.Name[0] == 'A' || u.Name[0] == 'a' })},
+			{bson.M{"$or": []bson.M{{"gender": "Male"}, {"is_active": true}}}, countUsers(users, func(u TestUser) bool { return u.Gender == "Male" || u.IsActive })},
+			{bson.M{"$and": []bson.M{{"gender": "Male"}, {"is_active": true}}}, countUsers(users, func(u TestUser) bool { return u.Gender == "Male" && u.IsActive })},
+			{bson.M{"$nor": []bson.M{{"gender": "Male"}}}, countUsers(users, func(u TestUser) bool { return u.Gender != "Male" })},
+			{bson.M{"missing": bson.M{"$exists": false}}, len(users)},
+			{bson.M{"missing": nil}, len(users)},
+			{bson.M{"name": bson.M{"$not": bson.M{"$regex": "^A"}}}, countUsers(users, func(u TestUser) bool { return u.Name[0] != 'A' })},
+		}
+
+		for _, test := range tests {
+			n, err := db.GetCount(test.query)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, n, "%v", test.query)
+		}
+	})
+
+	t.Run("Match array fields", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		assert.NoError(t, db.Create(bson.M{"tags": []string{"a", "b"}, "scores": []bson.M{{"v": 1}, {"v": 5}}}))
+
+		for _, q := range []bson.M{
+			{"tags": "a"},
+			{"tags": bson.M{"$all": []string{"a", "b"}}},
+			{"tags": bson.M{"$size": 2}},
+			{"scores": bson.M{"$elemMatch": bson.M{"v": bson.M{"$gt": 3}}}},
+			{"scores.v": nil},
+		} {
+			n, err := db.GetCount(q)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n, "%v", q)
+		}
+	})
+
+	t.Run("Compare symbols with strings and binaries with bytes", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		assert.NoError(t, db.CreateAll([]interface{}{
+			bson.M{"name": "c", "data": []byte{3}},
+			bson.M{"name": bson.Symbol("b"), "data": bson.Binary{Kind: 0x80, Data: []byte{2}}},
+			bson.M{"name": "a", "data": []byte{1}},
+		}))
+
+		for _, key := range []string{"name", "data"} {
+			var result []bson.M
+			_, err := db.GetAll(nil, &result, &lxDb.Options{Sort: key})
+			assert.NoError(t, err)
+			assert.Len(t, result, 3)
+			assert.Equal(t, "a", result[0]["name"])
+			assert.Equal(t, bson.Symbol("b"), result[1]["name"])
+			assert.Equal(t, "c", result[2]["name"])
+		}
+
+		n, err := db.GetCount(bson.M{"name": bson.M{"$gt": "a"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+
+	t.Run("Return error for unsupported operator", func(t *testing.T) {
+		_, err := db.GetCount(bson.M{"$where": "true"})
+		assert.Error(t, err)
+	})
+}
+
+func TestMemoryDb_GetPage(t *testing.T) {
+	db, users := setupMemory(t)
+	db.CursorKey = []byte("secret")
+
+	t.Run("Page forward and back through all users", func(t *testing.T) {
+		var names []string
+		var pages []string
+		opts := &lxDb.Options{Sort: "name", Limit: 10, Count: true}
+		for {
+			var result []TestUser
+			page, err := db.GetPage(nil, &result, opts)
+			assert.NoError(t, err)
+			assert.Equal(t, len(users), page.Total)
+			for _, u := range result {
+				names = append(names, u.Name)
+			}
+			if page.Next == "" {
+				break
+			}
+			pages = append(pages, page.Next)
+			opts.Cursor = page.Next
+		}
+		assert.Len(t, names, len(users))
+		assert.True(t, sort.StringsAreSorted(names))
+		assert.Len(t, pages, 2)
+
+		// Back from the second page to the first
+		var second, first []TestUser
+		page, err := db.GetPage(nil, &second, &lxDb.Options{Sort: "name", Limit: 10, Cursor: pages[0]})
+		assert.NoError(t, err)
+		assert.Equal(t, names[10], second[0].Name)
+
+		page, err = db.GetPage(nil, &first, &lxDb.Options{Sort: "name", Limit: 10, Cursor: page.Prev})
+		assert.NoError(t, err)
+		assert.Len(t, first, 10)
+		assert.Equal(t, names[0], first[0].Name)
+		assert.Equal(t, names[9], first[9].Name)
+		assert.Empty(t, page.Prev)
+		assert.NotEmpty(t, page.Next)
+	})
+
+	t.Run("Page by _id descending with filter", func(t *testing.T) {
+		var all []TestUser
+		opts := &lxDb.Options{Sort: "-_id", Limit: 4}
+		for {
+			var result []TestUser
+			page, err := db.GetPage(bson.M{"gender": "Male"}, &result, opts)
+			assert.NoError(t, err)
+			all = append(all, result...)
+			if page.Next == "" {
+				break
+			}
+			opts.Cursor = page.Next
+		}
+
+		assert.Len(t, all, countUsers(users, func(u TestUser) bool { return u.Gender == "Male" }))
+		assert.True(t, sort.SliceIsSorted(all, func(i, j int) bool { return all[i].Id > all[j].Id }))
+	})
+
+	t.Run("Page over null and missing sort values", func(t *testing.T) {
+		db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		db.CursorKey = []byte("secret")
+		for i, name := range []interface{}{"b", nil, "a", nil, "c", nil} {
+			doc := bson.M{"_id": i}
+			if name != nil || i == 1 {
+				doc["name"] = name
+			}
+			assert.NoError(t, db.Create(doc))
+		}
+
+		for _, sortBy := range []string{"name", "-name"} {
+			var ids []int
+			var cursors []string
+			opts := &lxDb.Options{Sort: sortBy, Limit: 2}
+			for {
+				var result []bson.M
+				page, err := db.GetPage(nil, &result, opts)
+				assert.NoError(t, err)
+				for _, doc := range result {
+					ids = append(ids, doc["_id"].(int))
+				}
+				if page.Next == "" {
+					break
+				}
+				cursors = append(cursors, page.Next)
+				opts.Cursor = page.Next
+			}
+
+			expected := []int{1, 3, 5, 2, 0, 4}
+			if sortBy == "-name" {
+				expected = []int{4, 0, 2, 5, 3, 1}
+			}
+			assert.Equal(t, expected, ids, sortBy)
+
+			// Back over the null values
+			var result []bson.M
+			page, err := db.GetPage(nil, &result, &lxDb.Options{Sort: sortBy, Limit: 2, Cursor: cursors[1]})
+			assert.NoError(t, err)
+			result = nil
+			_, err = db.GetPage(nil, &result, &lxDb.Options{Sort: sortBy, Limit: 2, Cursor: page.Prev})
+			assert.NoError(t, err)
+			assert.Len(t, result, 2, sortBy)
+			assert.Equal(t, expected[2], result[0]["_id"], sortBy)
+		}
+	})
+
+	t.Run("Reject tampered and mismatching cursors", func(t *testing.T) {
+		var result []TestUser
+		page, err := db.GetPage(nil, &result, &lxDb.Options{Sort: "name", Limit: 10})
+		assert.NoError(t, err)
+
+		_, err = db.GetPage(nil, &result, &lxDb.Options{Sort: "name", Limit: 10, Cursor: page.Next + "x"})
+		assert.Equal(t, lxDb.ErrInvalidCursor, err)
+
+		_, err = db.GetPage(nil, &result, &lxDb.Options{Sort: "email", Limit: 10, Cursor: page.Next})
+		assert.Equal(t, lxDb.ErrInvalidCursor, err)
+
+		other := lxDb.NewMemoryDb(db.Store, TestDbName, TestCollection)
+		other.CursorKey = []byte("other")
+		_, err = other.GetPage(nil, &result, &lxDb.Options{Sort: "name", Limit: 10, Cursor: page.Next})
+		assert.Equal(t, lxDb.ErrInvalidCursor, err)
+	})
+
+	t.Run("Return error without cursor key or with multiple sort keys", func(t *testing.T) {
+		var result []TestUser
+		_, err := lxDb.NewMemoryDb(db.Store, TestDbName, TestCollection).GetPage(nil, &result, nil)
+		assert.Equal(t, lxDb.ErrNoCursorKey, err)
+
+		_, err = db.GetPage(nil, &result, &lxDb.Options{Sort: "name,email"})
+		assert.IsType(t, &lxDb.OptionsError{}, err)
+	})
+}
+
+func TestMemoryDb_Update(t *testing.T) {
+	t.Run("Update one user with operators", func(t *testing.T) {
+		db, users := setupMemory(t)
+		err := db.Update(bson.M{"_id": users[0].Id}, bson.M{
+			"$set":  bson.M{"name": "Updated", "address.city": "Berlin"},
+			"$inc":  bson.M{"logins": 2},
+			"$push": bson.M{"tags": "a"},
+		})
+		assert.NoError(t, err)
+
+		var result bson.M
+		assert.NoError(t, db.GetOne(bson.M{"_id": users[0].Id}, &result))
+		assert.Equal(t, "Updated", result["name"])
+		assert.Equal(t, bson.M{"city": "Berlin"}, result["address"])
+		assert.Equal(t, 2, result["logins"])
+		assert.Equal(t, []interface{}{"a"}, result["tags"])
+	})
+
+	t.Run("Replace document and keep _id", func(t *testing.T) {
+		db, users := setupMemory(t)
+		assert.NoError(t, db.Update(bson.M{"_id": users[0].Id}, bson.M{"name": "Replaced"}))
+
+		var result bson.M
+		assert.NoError(t, db.GetOne(bson.M{"_id": users[0].Id}, &result))
+		assert.Equal(t, bson.M{"_id": users[0].Id, "name": "Replaced"}, result)
+	})
+
+	t.Run("Return not found and duplicate errors", func(t *testing.T) {
+		db, users := setupMemory(t)
+		assert.Equal(t, lxDb.ErrNotFound, db.Update(bson.M{"name": "unknown"}, bson.M{"$set": bson.M{"a": 1}}))
+
+		err := db.Update(bson.M{"_id": users[0].Id}, bson.M{"$set": bson.M{"email": users[1].Email}})
+		assert.True(t, mgo.IsDup(err))
+	})
+
+	t.Run("Update all male users", func(t *testing.T) {
+		db, users := setupMemory(t)
+		info, err := db.UpdateAll(bson.M{"gender": "Male"}, bson.M{"$set": bson.M{"is_active": true}})
+		assert.NoError(t, err)
+		assert.Equal(t, countUsers(users, func(u TestUser) bool { return u.Gender == "Male" }), info.Matched)
+		assert.Equal(t, countUsers(users, func(u TestUser) bool { return u.Gender == "Male" && !u.IsActive }), info.Updated)
+	})
+}
+
+func TestMemoryDb_Delete(t *testing.T) {
+	t.Run("Delete one user", func(t *testing.T) {
+		db, users := setupMemory(t)
+		assert.NoError(t, db.Delete(bson.M{"_id": users[0].Id}))
+		assert.Equal(t, lxDb.ErrNotFound, db.Delete(bson.M{"_id": users[0].Id}))
+
+		n, _ := db.GetCount(nil)
+		assert.Equal(t, len(users)-1, n)
+	})
+
+	t.Run("Delete all female users", func(t *testing.T) {
+		db, users := setupMemory(t)
+		info, err := db.DeleteAll(bson.M{"gender": "Female"})
+		assert.NoError(t, err)
+		assert.Equal(t, countUsers(users, func(u TestUser) bool { return u.Gender == "Female" }), info.Removed)
+
+		n, _ := db.GetCount(bson.M{"gender": "Female"})
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestMemoryDb_IBaseDb(t *testing.T) {
+	t.Run("Implement IBaseDb", func(t *testing.T) {
+		var db lxDb.IBaseDb = lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+		assert.NotNil(t, db)
+	})
+}
+
+func TestMemoryDb_Context(t *testing.T) {
+	db, users := setupMemory(t)
+
+	t.Run("Return error of done context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		var result []TestUser
+		_, err := db.GetAllContext(ctx, nil, &result, nil)
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, context.Canceled, db.CreateContext(ctx, bson.M{"name": "Otto"}))
+		assert.Equal(t, context.Canceled, db.DeleteContext(ctx, bson.M{"_id": users[0].Id}))
+
+		n, _ := db.GetCount(nil)
+		assert.Equal(t, len(users), n)
+	})
+
+	t.Run("Run operations with active context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		var result TestUser
+		assert.NoError(t, db.GetOneContext(ctx, bson.M{"_id": users[0].Id}, &result))
+		assert.Equal(t, users[0], result)
+	})
+}
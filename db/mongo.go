@@ -1,20 +1,46 @@
 package lxDb
 
 import (
+	"context"
+	"time"
+
 	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 )
 
 // Db struct for mongodb
 type MongoDb struct {
-	Conn *mgo.Session
-	Name string
+	Conn       *mgo.Session
+	Name       string
 	Collection string
+
+	// AllowedFields, allow-list for sort and fields options,
+	// all fields are allowed when empty
+	AllowedFields []string
+
+	// CursorKey, secret for signing the continuation tokens of GetPage
+	CursorKey []byte
+
+	// VersionField, field incremented on every update, UpdateContext checks it
+	// against the version of WithVersion, disabled when empty
+	VersionField string
+
+	// SoftDelete, Delete marks documents with deleted_at and deleted_by,
+	// reads and updates skip them unless ctx is WithDeleted
+	SoftDelete bool
+
+	// Stamps, fields stamped with Clock and the actor of WithActor on write,
+	// e.g. DefaultStamps, disabled when nil
+	Stamps *Stamps
+
+	// Clock, time source for stamps and soft delete, time.Now when nil
+	Clock func() time.Time
 }
 
 func NewMongoDb(connection *mgo.Session, dbName, collection string) *MongoDb {
 	return &MongoDb{
-		Conn: connection,
-		Name: dbName,
+		Conn:       connection,
+		Name:       dbName,
 		Collection: collection,
 	}
 }
@@ -35,4 +61,444 @@ func (db *MongoDb) Setup(indexes []mgo.Index) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Create, insert a new document in collection
+func (db *MongoDb) Create(data interface{}) error {
+	return db.CreateContext(context.Background(), data)
+}
+
+// CreateContext, insert a new document in collection
+func (db *MongoDb) CreateContext(ctx context.Context, data interface{}) error {
+	data, err := db.Stamps.create(ctx, clockTime(db.Clock), data)
+	if err != nil {
+		return err
+	}
+	data, err = versionCreate(db.VersionField, data)
+	if err != nil {
+		return err
+	}
+
+	return db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		return col.Insert(data)
+	})
+}
+
+// CreateAll, insert documents in one bulk, stops at the first failed document
+func (db *MongoDb) CreateAll(data []interface{}) error {
+	return db.CreateAllContext(context.Background(), data)
+}
+
+// CreateAllContext, insert documents in one bulk, stops at the first failed document
+func (db *MongoDb) CreateAllContext(ctx context.Context, data []interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(data))
+	for i, d := range data {
+		d, err := db.Stamps.create(ctx, clockTime(db.Clock), d)
+		if err != nil {
+			return err
+		}
+		if docs[i], err = versionCreate(db.VersionField, d); err != nil {
+			return err
+		}
+	}
+
+	return db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		return col.Insert(docs...)
+	})
+}
+
+// GetOne, find the first document matching the query
+func (db *MongoDb) GetOne(query interface{}, result interface{}) error {
+	return db.GetOneContext(context.Background(), query, result)
+}
+
+// GetOneContext, find the first document matching the query
+func (db *MongoDb) GetOneContext(ctx context.Context, query interface{}, result interface{}) error {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return err
+	}
+
+	var raw bson.Raw
+	err = db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		return find(col, query, maxTime).One(&raw)
+	})
+	if err != nil {
+		return err
+	}
+
+	return raw.Unmarshal(result)
+}
+
+// GetAll, find all documents matching the query,
+// returns the total count of matching documents when opts.Count is set
+func (db *MongoDb) GetAll(query interface{}, result interface{}, opts *Options) (int, error) {
+	return db.GetAllContext(context.Background(), query, result, opts)
+}
+
+// GetAllContext, find all documents matching the query,
+// returns the total count of matching documents when opts.Count is set
+func (db *MongoDb) GetAllContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (int, error) {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return 0, err
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := opts.Validate(db.AllowedFields); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var docs []bson.Raw
+	err = db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		// Count without skip and limit
+		if opts.Count {
+			var err error
+			if n, err = find(col, query, maxTime).Count(); err != nil {
+				return err
+			}
+		}
+
+		q := find(col, query, maxTime)
+		if opts.Skip > 0 {
+			q = q.Skip(opts.Skip)
+		}
+		if opts.Limit > 0 {
+			q = q.Limit(opts.Limit)
+		}
+		if keys := opts.SortKeys(); len(keys) > 0 {
+			q = q.Sort(keys...)
+		}
+		if sel := opts.Selector(); sel != nil {
+			q = q.Select(sel)
+		}
+
+		return q.All(&docs)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, decodeAll(docs, result)
+}
+
+// GetPage, find one page of documents matching the query with continuation tokens,
+// pages are ordered by the single sort key of opts and _id, skip is ignored
+func (db *MongoDb) GetPage(query interface{}, result interface{}, opts *Options) (*Page, error) {
+	return db.GetPageContext(context.Background(), query, result, opts)
+}
+
+// GetPageContext, find one page of documents matching the query with continuation tokens,
+// pages are ordered by the single sort key of opts and _id, skip is ignored
+func (db *MongoDb) GetPageContext(ctx context.Context, query interface{}, result interface{}, opts *Options) (*Page, error) {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := opts.Validate(db.AllowedFields); err != nil {
+		return nil, err
+	}
+
+	ks, err := newKeyset(query, opts, db.CursorKey)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	var docs []bson.Raw
+	err = db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		// Count without cursor and limit
+		if opts.Count {
+			var err error
+			if total, err = find(col, query, maxTime).Count(); err != nil {
+				return err
+			}
+		}
+
+		q := find(col, ks.filter, maxTime).Sort(ks.sort...)
+		if limit := ks.fetchLimit(); limit > 0 {
+			q = q.Limit(limit)
+		}
+		if sel := ks.selector(opts); sel != nil {
+			q = q.Select(sel)
+		}
+
+		return q.All(&docs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	docs, page, err := ks.page(docs, db.CursorKey)
+	if err != nil {
+		return nil, err
+	}
+	page.Total = total
+
+	return page, decodeAll(docs, result)
+}
+
+// GetCount, count documents matching the query
+func (db *MongoDb) GetCount(query interface{}) (int, error) {
+	return db.GetCountContext(context.Background(), query)
+}
+
+// GetCountContext, count documents matching the query
+func (db *MongoDb) GetCountContext(ctx context.Context, query interface{}) (int, error) {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := db.runValue(ctx, func(col *mgo.Collection, maxTime time.Duration) (interface{}, error) {
+		return find(col, query, maxTime).Count()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return v.(int), nil
+}
+
+// Update, update the first document matching the query
+func (db *MongoDb) Update(query interface{}, data interface{}) error {
+	return db.UpdateContext(context.Background(), query, data)
+}
+
+// UpdateContext, update the first document matching the query,
+// returns *ConflictError when the version of WithVersion is outdated
+func (db *MongoDb) UpdateContext(ctx context.Context, query interface{}, data interface{}) error {
+	q, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return err
+	}
+	q, data, err = db.Stamps.update(ctx, clockTime(db.Clock), q, data, func(q interface{}, result interface{}) error {
+		return db.GetOneContext(ctx, q, result)
+	})
+	if err != nil {
+		return err
+	}
+	q, u, version, checked, err := versionUpdate(ctx, db.VersionField, q, data, true)
+	if err != nil {
+		return err
+	}
+
+	err = db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		return col.Update(q, u)
+	})
+	if err == ErrNotFound && checked {
+		n, err := db.GetCountContext(ctx, query)
+		return versionConflict(n, err, version)
+	}
+
+	return err
+}
+
+// UpdateAll, update all documents matching the query
+func (db *MongoDb) UpdateAll(query interface{}, data interface{}) (ChangeInfo, error) {
+	return db.UpdateAllContext(context.Background(), query, data)
+}
+
+// UpdateAllContext, update all documents matching the query
+func (db *MongoDb) UpdateAllContext(ctx context.Context, query interface{}, data interface{}) (ChangeInfo, error) {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+	query, data, err = db.Stamps.update(ctx, clockTime(db.Clock), query, data, nil)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+	query, data, _, _, err = versionUpdate(ctx, db.VersionField, query, data, false)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	return db.runChange(ctx, func(col *mgo.Collection) (*mgo.ChangeInfo, error) {
+		return col.UpdateAll(query, data)
+	})
+}
+
+// Delete, remove the first document matching the query
+func (db *MongoDb) Delete(query interface{}) error {
+	return db.DeleteContext(context.Background(), query)
+}
+
+// DeleteContext, remove the first document matching the query
+func (db *MongoDb) DeleteContext(ctx context.Context, query interface{}) error {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return err
+	}
+
+	return db.run(ctx, func(col *mgo.Collection, maxTime time.Duration) error {
+		if db.SoftDelete {
+			return col.Update(query, deleteUpdate(ctx, clockTime(db.Clock)))
+		}
+		return col.Remove(query)
+	})
+}
+
+// DeleteAll, remove all documents matching the query
+func (db *MongoDb) DeleteAll(query interface{}) (ChangeInfo, error) {
+	return db.DeleteAllContext(context.Background(), query)
+}
+
+// DeleteAllContext, remove all documents matching the query
+func (db *MongoDb) DeleteAllContext(ctx context.Context, query interface{}) (ChangeInfo, error) {
+	query, err := notDeleted(ctx, db.SoftDelete, query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	return db.runChange(ctx, func(col *mgo.Collection) (*mgo.ChangeInfo, error) {
+		if db.SoftDelete {
+			info, err := col.UpdateAll(query, deleteUpdate(ctx, clockTime(db.Clock)))
+			if info != nil {
+				info.Removed, info.Updated = info.Updated, 0
+			}
+			return info, err
+		}
+		return col.RemoveAll(query)
+	})
+}
+
+// Restore, restore soft deleted documents matching the query
+func (db *MongoDb) Restore(query interface{}) (ChangeInfo, error) {
+	return db.RestoreContext(context.Background(), query)
+}
+
+// RestoreContext, restore soft deleted documents matching the query
+func (db *MongoDb) RestoreContext(ctx context.Context, query interface{}) (ChangeInfo, error) {
+	if !db.SoftDelete {
+		return ChangeInfo{}, ErrNoSoftDelete
+	}
+
+	query, err := onlyDeleted(query)
+	if err != nil {
+		return ChangeInfo{}, err
+	}
+
+	return db.runChange(ctx, func(col *mgo.Collection) (*mgo.ChangeInfo, error) {
+		return col.UpdateAll(query, restoreUpdate())
+	})
+}
+
+// Purge, remove documents soft deleted longer than olderThan
+func (db *MongoDb) Purge(olderThan time.Duration) (ChangeInfo, error) {
+	return db.PurgeContext(context.Background(), olderThan)
+}
+
+// PurgeContext, remove documents soft deleted longer than olderThan
+func (db *MongoDb) PurgeContext(ctx context.Context, olderThan time.Duration) (ChangeInfo, error) {
+	if !db.SoftDelete {
+		return ChangeInfo{}, ErrNoSoftDelete
+	}
+
+	return db.runChange(ctx, func(col *mgo.Collection) (*mgo.ChangeInfo, error) {
+		return col.RemoveAll(purgeQuery(clockTime(db.Clock), olderThan))
+	})
+}
+
+// runResult, value and error of fn, passed from the worker of runValue
+type runResult struct {
+	value interface{}
+	err   error
+}
+
+// run, execute fn with a copied session bound to the deadline of ctx,
+// returns ctx.Err() as soon as ctx is done, a started write may still complete
+func (db *MongoDb) run(ctx context.Context, fn func(col *mgo.Collection, maxTime time.Duration) error) error {
+	_, err := db.runValue(ctx, func(col *mgo.Collection, maxTime time.Duration) (interface{}, error) {
+		return nil, fn(col, maxTime)
+	})
+
+	return err
+}
+
+// runChange, run fn returning change info, empty info when ctx is done
+func (db *MongoDb) runChange(ctx context.Context, fn func(col *mgo.Collection) (*mgo.ChangeInfo, error)) (ChangeInfo, error) {
+	v, err := db.runValue(ctx, func(col *mgo.Collection, maxTime time.Duration) (interface{}, error) {
+		return fn(col)
+	})
+	info, _ := v.(*mgo.ChangeInfo)
+	return toChangeInfo(info), err
+}
+
+// runValue, run fn like run, the value of fn is passed with its error through the done channel,
+// returns nil and ctx.Err() when ctx is done first, the worker result is dropped
+func (db *MongoDb) runValue(ctx context.Context, fn func(col *mgo.Collection, maxTime time.Duration) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Copy mongo session (thread safe) and close after function
+	conn := db.Conn.Copy()
+
+	// Map deadline to socket timeout and server side max time
+	var maxTime time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if maxTime = time.Until(deadline); maxTime <= 0 {
+			conn.Close()
+			return nil, context.DeadlineExceeded
+		}
+		conn.SetSyncTimeout(maxTime)
+		conn.SetSocketTimeout(maxTime)
+	}
+
+	col := conn.DB(db.Name).C(db.Collection)
+
+	// Not cancelable, run without goroutine
+	if ctx.Done() == nil {
+		defer conn.Close()
+		return fn(col, maxTime)
+	}
+
+	done := make(chan runResult, 1)
+	go func() {
+		defer conn.Close()
+		v, err := fn(col, maxTime)
+		done <- runResult{value: v, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// find, query with server side max time
+func find(col *mgo.Collection, query interface{}, maxTime time.Duration) *mgo.Query {
+	q := col.Find(query)
+	if maxTime > 0 {
+		q = q.SetMaxTime(maxTime)
+	}
+
+	return q
+}
+
+// toChangeInfo, convert mgo change info
+func toChangeInfo(info *mgo.ChangeInfo) ChangeInfo {
+	if info == nil {
+		return ChangeInfo{}
+	}
+
+	return ChangeInfo{
+		Updated: info.Updated,
+		Removed: info.Removed,
+		Matched: info.Matched,
+	}
+}
@@ -1,6 +1,7 @@
 package lxDb_test
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -12,6 +13,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 )
 
 // TestUser, struct for test users
@@ -28,7 +30,11 @@ const (
 	TestCollection = "users"
 )
 
-func getConn() *mgo.Session {
+// mongoErr, dial error of an unreachable mongod, later tests skip without waiting
+var mongoErr error
+
+// getConn, get a new db connection, skips the test when mongod is not reachable
+func getConn(t *testing.T) *mgo.Session {
 
 	// Check DbHost environment
 	dbHost := os.Getenv("DBHOST")
@@ -38,12 +44,17 @@ func getConn() *mgo.Session {
 		dbHost = "localhost:27017"
 	}
 
+	if mongoErr != nil {
+		t.Skipf("mongoDb %s not reachable: %v", dbHost, mongoErr)
+	}
+
 	log.Println("DBHOST:", dbHost)
 
 	// Create new connection
-	conn, err := mgo.Dial(dbHost)
+	conn, err := mgo.DialWithTimeout(dbHost, 2*time.Second)
 	if err != nil {
-		log.Fatal(err)
+		mongoErr = err
+		t.Skipf("mongoDb %s not reachable: %v", dbHost, err)
 	}
 	conn.SetMode(mgo.Monotonic, true)
 
@@ -51,7 +62,7 @@ func getConn() *mgo.Session {
 }
 
 // setupData, create the test data and prepare the database
-func setupData(conn *mgo.Session) []TestUser {
+func setupData(t *testing.T, conn *mgo.Session) []TestUser {
 	// Delete collection if exists
 	conn.DB(TestDbName).C(TestCollection).DropCollection()
 
@@ -65,20 +76,20 @@ func setupData(conn *mgo.Session) []TestUser {
 	col := conn.DB(TestDbName).C(TestCollection)
 	for _, i := range indexes {
 		if err := col.EnsureIndex(i); err != nil {
-			log.Fatal(err)
+			t.Fatal(err)
 		}
 	}
 
 	// Load test data from json file
 	raw, err := ioutil.ReadFile("../tests/fixtures/MOCK_DATA.json")
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 
 	// Convert
 	var users []TestUser
 	if err := json.Unmarshal(raw, &users); err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 
 	// Make Test users map and insert test data in db
@@ -87,7 +98,7 @@ func setupData(conn *mgo.Session) []TestUser {
 
 		// Insert user
 		if err := conn.DB(TestDbName).C(TestCollection).Insert(users[i]); err != nil {
-			log.Fatal(err)
+			t.Fatal(err)
 		}
 	}
 
@@ -101,7 +112,7 @@ func setupData(conn *mgo.Session) []TestUser {
 }
 
 func TestNewMongoDb(t *testing.T) {
-	conn := getConn()
+	conn := getConn(t)
 	defer conn.Close()
 
 	convey.Convey("Given mongoDb connection", t, func() {
@@ -113,7 +124,7 @@ func TestNewMongoDb(t *testing.T) {
 				convey.So(chkT.String(), convey.ShouldEqual, "*lxDb.MongoDb")
 			})
 			convey.Convey("And then test query should equal expected", func() {
-				expected := setupData(conn)
+				expected := setupData(t, conn)
 
 				var result []TestUser
 				db.Conn.DB(db.Name).C(db.Collection).Find(nil).All(&result)
@@ -131,7 +142,7 @@ func TestNewMongoDb(t *testing.T) {
 }
 
 func TestMongoDb_Setup(t *testing.T) {
-	conn := getConn()
+	conn := getConn(t)
 	defer conn.Close()
 
 	// Delete collection if exists
@@ -156,4 +167,524 @@ func TestMongoDb_Setup(t *testing.T) {
 			})
 		})
 	})
-}
\ No newline at end of file
+}
+func TestMongoDb_Create(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	// Delete collection if exists
+	conn.DB(TestDbName).C(TestCollection).DropCollection()
+
+	convey.Convey("Given mongoDb connection with drop collection", t, func() {
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When create a new user", func() {
+			user := TestUser{Id: bson.NewObjectId(), Name: "Otto", Email: "otto@example.com"}
+			convey.So(db.Create(&user), convey.ShouldBeNil)
+
+			convey.Convey("Then user should be found in db", func() {
+				var result TestUser
+				convey.So(db.Conn.DB(db.Name).C(db.Collection).FindId(user.Id).One(&result), convey.ShouldBeNil)
+				convey.So(result, convey.ShouldResemble, user)
+			})
+		})
+	})
+}
+
+func TestMongoDb_CreateAll(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	// Delete collection if exists
+	conn.DB(TestDbName).C(TestCollection).DropCollection()
+
+	convey.Convey("Given mongoDb connection with drop collection", t, func() {
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When create two users in one bulk", func() {
+			convey.So(db.CreateAll([]interface{}{
+				&TestUser{Id: bson.NewObjectId(), Name: "Otto", Email: "otto@example.com"},
+				&TestUser{Id: bson.NewObjectId(), Name: "Emil", Email: "emil@example.com"},
+			}), convey.ShouldBeNil)
+
+			convey.Convey("Then both users should be found in db", func() {
+				n, err := db.GetCount(nil)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestMongoDb_GetOne(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When get one user by email", func() {
+			var result TestUser
+			err := db.GetOne(bson.M{"email": expected[0].Email}, &result)
+
+			convey.Convey("Then result should equal expected user", func() {
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(result, convey.ShouldResemble, expected[0])
+			})
+		})
+		convey.Convey("When get one user with unknown email", func() {
+			var result TestUser
+			err := db.GetOne(bson.M{"email": "unknown@example.com"}, &result)
+
+			convey.Convey("Then error should be not found", func() {
+				convey.So(err, convey.ShouldEqual, mgo.ErrNotFound)
+			})
+		})
+	})
+}
+
+func TestMongoDb_GetAll(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When get all users without options", func() {
+			var result []TestUser
+			n, err := db.GetAll(nil, &result, nil)
+
+			convey.Convey("Then all users should be returned without count", func() {
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, 0)
+				convey.So(len(result), convey.ShouldEqual, len(expected))
+			})
+		})
+		convey.Convey("When get all users with skip, limit and count", func() {
+			var result []TestUser
+			n, err := db.GetAll(bson.M{}, &result, &lxDb.Options{Skip: 5, Limit: 10, Count: true})
+
+			convey.Convey("Then result should be limited and count should be total", func() {
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, len(expected))
+				convey.So(len(result), convey.ShouldEqual, 10)
+			})
+		})
+	})
+}
+
+func TestMongoDb_GetCount(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When count active users", func() {
+			n, err := db.GetCount(bson.M{"is_active": true})
+
+			convey.Convey("Then count should equal active test users", func() {
+				active := 0
+				for _, u := range expected {
+					if u.IsActive {
+						active++
+					}
+				}
+
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, active)
+			})
+		})
+	})
+}
+
+func TestMongoDb_Update(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When update one user", func() {
+			err := db.Update(bson.M{"_id": expected[0].Id}, bson.M{"$set": bson.M{"name": "Updated"}})
+
+			convey.Convey("Then user should be updated in db", func() {
+				var result TestUser
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(db.Conn.DB(db.Name).C(db.Collection).FindId(expected[0].Id).One(&result), convey.ShouldBeNil)
+				convey.So(result.Name, convey.ShouldEqual, "Updated")
+			})
+		})
+		convey.Convey("When update all male users", func() {
+			info, err := db.UpdateAll(bson.M{"gender": "Male"}, bson.M{"$set": bson.M{"is_active": true}})
+
+			convey.Convey("Then change info should contain all male users", func() {
+				male := 0
+				for _, u := range expected {
+					if u.Gender == "Male" {
+						male++
+					}
+				}
+
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(info.Matched, convey.ShouldEqual, male)
+			})
+		})
+	})
+}
+
+func TestMongoDb_Delete(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When delete one user", func() {
+			err := db.Delete(bson.M{"_id": expected[0].Id})
+
+			convey.Convey("Then user should be removed from db", func() {
+				convey.So(err, convey.ShouldBeNil)
+				n, _ := db.Conn.DB(db.Name).C(db.Collection).FindId(expected[0].Id).Count()
+				convey.So(n, convey.ShouldEqual, 0)
+			})
+		})
+		convey.Convey("When delete all female users", func() {
+			info, err := db.DeleteAll(bson.M{"gender": "Female"})
+
+			convey.Convey("Then change info should contain removed female users", func() {
+				female := 0
+				for _, u := range expected {
+					if u.Gender == "Female" {
+						female++
+					}
+				}
+
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(info.Removed, convey.ShouldEqual, female)
+			})
+		})
+	})
+}
+
+func TestMongoDb_GetAllSortAndFields(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data and allow-list", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+		db.AllowedFields = []string{"name", "email", "gender"}
+
+		convey.Convey("When get all users sorted by name descending with fields", func() {
+			var result []TestUser
+			_, err := db.GetAll(nil, &result, &lxDb.Options{Sort: "-name", Fields: "name"})
+
+			convey.Convey("Then result should be sorted and contain only name", func() {
+				names := make([]string, len(expected))
+				for i, u := range expected {
+					names[i] = u.Name
+				}
+				sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(len(result), convey.ShouldEqual, len(expected))
+				for i, u := range result {
+					convey.So(u.Name, convey.ShouldEqual, names[i])
+					convey.So(u.Email, convey.ShouldBeEmpty)
+				}
+			})
+		})
+		convey.Convey("When get all users sorted by a field not in allow-list", func() {
+			var result []TestUser
+			_, err := db.GetAll(nil, &result, &lxDb.Options{Sort: "is_active"})
+
+			convey.Convey("Then error should be options error", func() {
+				convey.So(err, convey.ShouldHaveSameTypeAs, &lxDb.OptionsError{})
+			})
+		})
+	})
+}
+
+func TestMongoDb_GetPage(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data and cursor key", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+		db.CursorKey = []byte("secret")
+
+		convey.Convey("When page through all users sorted by name", func() {
+			var names []string
+			opts := &lxDb.Options{Sort: "name", Limit: 10}
+			pages := 0
+			for {
+				var result []TestUser
+				page, err := db.GetPage(nil, &result, opts)
+				convey.So(err, convey.ShouldBeNil)
+				for _, u := range result {
+					names = append(names, u.Name)
+				}
+				pages++
+				if page.Next == "" {
+					break
+				}
+				opts.Cursor = page.Next
+			}
+
+			convey.Convey("Then all users should be returned in order", func() {
+				convey.So(pages, convey.ShouldEqual, 3)
+				convey.So(len(names), convey.ShouldEqual, len(expected))
+				convey.So(sort.StringsAreSorted(names), convey.ShouldBeTrue)
+			})
+		})
+		convey.Convey("When go back from the second page", func() {
+			var first, second, back []TestUser
+			page, _ := db.GetPage(nil, &first, &lxDb.Options{Sort: "-name", Limit: 10})
+			page, _ = db.GetPage(nil, &second, &lxDb.Options{Sort: "-name", Limit: 10, Cursor: page.Next})
+			page, err := db.GetPage(nil, &back, &lxDb.Options{Sort: "-name", Limit: 10, Cursor: page.Prev})
+
+			convey.Convey("Then result should equal the first page", func() {
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(back, convey.ShouldResemble, first)
+				convey.So(page.Prev, convey.ShouldBeEmpty)
+				convey.So(page.Next, convey.ShouldNotBeEmpty)
+			})
+		})
+		convey.Convey("When use a tampered cursor", func() {
+			var result []TestUser
+			page, _ := db.GetPage(nil, &result, &lxDb.Options{Limit: 10})
+			_, err := db.GetPage(nil, &result, &lxDb.Options{Limit: 10, Cursor: page.Next + "x"})
+
+			convey.Convey("Then error should be invalid cursor", func() {
+				convey.So(err, convey.ShouldEqual, lxDb.ErrInvalidCursor)
+			})
+		})
+	})
+}
+
+func TestMongoDb_Context(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+
+		convey.Convey("When get all users with canceled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			var result []TestUser
+			_, err := db.GetAllContext(ctx, nil, &result, nil)
+
+			convey.Convey("Then error should be context canceled", func() {
+				convey.So(err, convey.ShouldEqual, context.Canceled)
+				convey.So(result, convey.ShouldBeEmpty)
+			})
+		})
+		convey.Convey("When get all users with deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var result []TestUser
+			_, err := db.GetAllContext(ctx, nil, &result, nil)
+
+			convey.Convey("Then all users should be returned", func() {
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(len(result), convey.ShouldEqual, len(expected))
+			})
+		})
+		convey.Convey("When query runs longer than the deadline", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := db.GetCountContext(ctx, bson.M{"$where": "sleep(100) || true"})
+
+			convey.Convey("Then an error should be returned", func() {
+				convey.So(err, convey.ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestConnect_Mongo(t *testing.T) {
+	// Skip without test server
+	getConn(t).Close()
+
+	dbHost := os.Getenv("DBHOST")
+	if dbHost == "" {
+		dbHost = "localhost:27017"
+	}
+
+	convey.Convey("Given config for the test server", t, func() {
+		cfg := lxDb.Config{Hosts: []string{dbHost}, Database: TestDbName, ReadMode: "monotonic", HealthInterval: time.Second}
+
+		convey.Convey("When connect", func() {
+			c, err := lxDb.Connect(cfg)
+			convey.So(err, convey.ShouldBeNil)
+			defer c.Close()
+
+			convey.Convey("Then ping and health check should succeed", func() {
+				convey.So(c.Ping(), convey.ShouldBeNil)
+
+				status := c.Check()
+				convey.So(status.Healthy, convey.ShouldBeTrue)
+				convey.So(c.Health(), convey.ShouldResemble, status)
+			})
+			convey.Convey("And then repository should use the configured database", func() {
+				db := c.NewMongoDb(TestCollection)
+				convey.So(db.Name, convey.ShouldEqual, TestDbName)
+				_, err := db.GetCount(nil)
+				convey.So(err, convey.ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestMongoDb_MigrateIndexes(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	// Delete collection and migrations if exists
+	conn.DB(TestDbName).C(TestCollection).DropCollection()
+	conn.DB(TestDbName).C(lxDb.MigrationsCollection).DropCollection()
+
+	convey.Convey("Given mongoDb with drifted indexes", t, func() {
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+		convey.So(db.Setup([]mgo.Index{
+			{Key: []string{"name"}},
+			{Key: []string{"email"}},
+		}), convey.ShouldBeNil)
+
+		desired := []mgo.Index{
+			{Key: []string{"email"}, Unique: true},
+			{Key: []string{"created"}, ExpireAfter: time.Hour},
+			{Key: []string{"gender"}, PartialFilter: bson.M{"is_active": true}},
+		}
+
+		convey.Convey("When plan indexes", func() {
+			plan, err := db.PlanIndexes(desired)
+
+			convey.Convey("Then drift should be reported", func() {
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(len(plan.Add), convey.ShouldEqual, 2)
+				convey.So(len(plan.Remove), convey.ShouldEqual, 1)
+				convey.So(len(plan.Change), convey.ShouldEqual, 1)
+			})
+		})
+		convey.Convey("When migrate indexes", func() {
+			_, err := db.MigrateIndexes("1", desired)
+			convey.So(err, convey.ShouldBeNil)
+
+			convey.Convey("Then no drift should be left and version should be recorded", func() {
+				plan, err := db.PlanIndexes(desired)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(plan.Empty(), convey.ShouldBeTrue)
+
+				records, err := db.IndexMigrations()
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(len(records), convey.ShouldEqual, 1)
+				convey.So(records[0].Version, convey.ShouldEqual, "1")
+			})
+		})
+	})
+}
+
+func TestMongoDb_Version(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given versioned mongoDb with test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+		db.VersionField = "version"
+		_, err := db.UpdateAll(nil, bson.M{"$set": bson.M{"version": 1}})
+		convey.So(err, convey.ShouldBeNil)
+
+		convey.Convey("When update user with current version", func() {
+			ctx := lxDb.WithVersion(context.Background(), 1)
+			err := db.UpdateContext(ctx, bson.M{"_id": expected[0].Id}, bson.M{"$set": bson.M{"name": "Updated"}})
+
+			convey.Convey("Then version should be incremented", func() {
+				convey.So(err, convey.ShouldBeNil)
+				n, err := db.GetCount(bson.M{"_id": expected[0].Id, "version": 2})
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, 1)
+			})
+			convey.Convey("Then update with outdated version should conflict", func() {
+				err := db.UpdateContext(ctx, bson.M{"_id": expected[0].Id}, bson.M{"$set": bson.M{"name": "Again"}})
+				convey.So(lxDb.IsConflict(err), convey.ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestMongoDb_SoftDelete(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with soft delete and test data", t, func() {
+		expected := setupData(t, conn)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+		db.SoftDelete = true
+
+		convey.Convey("When delete one user", func() {
+			err := db.DeleteContext(lxDb.WithActor(context.Background(), "admin"), bson.M{"_id": expected[0].Id})
+
+			convey.Convey("Then user should be marked and hidden", func() {
+				convey.So(err, convey.ShouldBeNil)
+
+				n, err := db.GetCount(nil)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(n, convey.ShouldEqual, len(expected)-1)
+
+				var result bson.M
+				convey.So(db.Conn.DB(db.Name).C(db.Collection).FindId(expected[0].Id).One(&result), convey.ShouldBeNil)
+				convey.So(result[lxDb.DeletedByField], convey.ShouldEqual, "admin")
+			})
+			convey.Convey("Then user should be restored", func() {
+				info, err := db.Restore(bson.M{"_id": expected[0].Id})
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(info.Updated, convey.ShouldEqual, 1)
+			})
+			convey.Convey("Then purge should keep recently deleted user", func() {
+				info, err := db.Purge(time.Hour)
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(info.Removed, convey.ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestMongoDb_Stamps(t *testing.T) {
+	conn := getConn(t)
+	defer conn.Close()
+
+	convey.Convey("Given mongoDb with stamps and test data", t, func() {
+		expected := setupData(t, conn)
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		db := lxDb.NewMongoDb(conn, TestDbName, TestCollection)
+		db.Stamps = lxDb.DefaultStamps
+		db.Clock = func() time.Time { return now }
+
+		convey.Convey("When update one user with actor", func() {
+			ctx := lxDb.WithActor(context.Background(), "admin")
+			err := db.UpdateContext(ctx, bson.M{"_id": expected[0].Id}, bson.M{"$set": bson.M{"name": "Updated"}})
+
+			convey.Convey("Then updated fields should be stamped", func() {
+				var result bson.M
+				convey.So(err, convey.ShouldBeNil)
+				convey.So(db.Conn.DB(db.Name).C(db.Collection).FindId(expected[0].Id).One(&result), convey.ShouldBeNil)
+				convey.So(result["updated_by"], convey.ShouldEqual, "admin")
+				convey.So(result["updated_at"].(time.Time).Equal(now), convey.ShouldBeTrue)
+			})
+		})
+	})
+}
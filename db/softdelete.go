@@ -0,0 +1,69 @@
+package lxDb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	DeletedAtField = "deleted_at"
+	DeletedByField = "deleted_by"
+)
+
+// ErrNoSoftDelete, Restore and Purge need soft delete enabled
+var ErrNoSoftDelete = errors.New("soft delete is not enabled for collection")
+
+type deletedKey struct{}
+
+// WithDeleted, return ctx for reads including soft deleted documents
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deletedKey{}, true)
+}
+
+// notDeleted, exclude soft deleted documents from query
+func notDeleted(ctx context.Context, enabled bool, query interface{}) (interface{}, error) {
+	if !enabled {
+		return query, nil
+	}
+	if include, _ := ctx.Value(deletedKey{}).(bool); include {
+		return query, nil
+	}
+
+	return andQuery(query, bson.M{DeletedAtField: bson.M{"$exists": false}})
+}
+
+// onlyDeleted, restrict query to soft deleted documents
+func onlyDeleted(query interface{}) (interface{}, error) {
+	return andQuery(query, bson.M{DeletedAtField: bson.M{"$exists": true}})
+}
+
+// deleteUpdate, mark documents as deleted by the actor of ctx
+func deleteUpdate(ctx context.Context, now time.Time) bson.M {
+	return bson.M{"$set": bson.M{DeletedAtField: now, DeletedByField: ActorFromContext(ctx)}}
+}
+
+// restoreUpdate, remove the deleted marks
+func restoreUpdate() bson.M {
+	return bson.M{"$unset": bson.M{DeletedAtField: "", DeletedByField: ""}}
+}
+
+// purgeQuery, documents deleted before olderThan
+func purgeQuery(now time.Time, olderThan time.Duration) bson.M {
+	return bson.M{DeletedAtField: bson.M{"$lt": now.Add(-olderThan)}}
+}
+
+// andQuery, combine query with condition
+func andQuery(query interface{}, cond bson.M) (interface{}, error) {
+	q, err := toDoc(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(q) == 0 {
+		return cond, nil
+	}
+
+	return bson.M{"$and": []interface{}{q, cond}}, nil
+}
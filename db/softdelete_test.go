@@ -0,0 +1,93 @@
+package lxDb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDb_SoftDelete(t *testing.T) {
+	ctx := lxDb.WithActor(context.Background(), "admin")
+
+	t.Run("Delete marks document and hides it from reads", func(t *testing.T) {
+		db, users := setupMemory(t)
+		db.SoftDelete = true
+
+		assert.NoError(t, db.DeleteContext(ctx, bson.M{"_id": users[0].Id}))
+
+		var result TestUser
+		assert.Equal(t, lxDb.ErrNotFound, db.GetOne(bson.M{"_id": users[0].Id}, &result))
+
+		n, err := db.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users)-1, n)
+
+		var all []TestUser
+		_, err = db.GetAll(nil, &all, nil)
+		assert.NoError(t, err)
+		assert.Len(t, all, len(users)-1)
+
+		var deleted bson.M
+		assert.NoError(t, db.GetOneContext(lxDb.WithDeleted(ctx), bson.M{"_id": users[0].Id}, &deleted))
+		assert.Equal(t, "admin", deleted[lxDb.DeletedByField])
+		assert.IsType(t, time.Time{}, deleted[lxDb.DeletedAtField])
+
+		// Deleted documents can't be updated or deleted again
+		assert.Equal(t, lxDb.ErrNotFound, db.Update(bson.M{"_id": users[0].Id}, bson.M{"$set": bson.M{"name": "x"}}))
+		assert.Equal(t, lxDb.ErrNotFound, db.Delete(bson.M{"_id": users[0].Id}))
+	})
+
+	t.Run("DeleteAll and Restore", func(t *testing.T) {
+		db, users := setupMemory(t)
+		db.SoftDelete = true
+		male := countUsers(users, func(u TestUser) bool { return u.Gender == "Male" })
+
+		info, err := db.DeleteAllContext(ctx, bson.M{"gender": "Male"})
+		assert.NoError(t, err)
+		assert.Equal(t, male, info.Removed)
+
+		info, err = db.Restore(bson.M{"gender": "Male"})
+		assert.NoError(t, err)
+		assert.Equal(t, male, info.Updated)
+
+		n, err := db.GetCount(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users), n)
+
+		n, err = db.GetCountContext(lxDb.WithDeleted(ctx), bson.M{lxDb.DeletedAtField: bson.M{"$exists": true}})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("Purge removes old deleted documents", func(t *testing.T) {
+		db, users := setupMemory(t)
+		db.SoftDelete = true
+
+		assert.NoError(t, db.Delete(bson.M{"_id": users[0].Id}))
+		assert.NoError(t, db.Delete(bson.M{"_id": users[1].Id}))
+		_, err := db.UpdateAllContext(lxDb.WithDeleted(ctx), bson.M{"_id": users[0].Id},
+			bson.M{"$set": bson.M{lxDb.DeletedAtField: time.Now().Add(-48 * time.Hour)}})
+		assert.NoError(t, err)
+
+		info, err := db.Purge(24 * time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, info.Removed)
+
+		n, err := db.GetCountContext(lxDb.WithDeleted(ctx), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(users)-1, n)
+	})
+
+	t.Run("Restore and Purge need soft delete", func(t *testing.T) {
+		db, _ := setupMemory(t)
+
+		_, err := db.Restore(nil)
+		assert.Equal(t, lxDb.ErrNoSoftDelete, err)
+		_, err = db.Purge(time.Hour)
+		assert.Equal(t, lxDb.ErrNoSoftDelete, err)
+	})
+}
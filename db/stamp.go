@@ -0,0 +1,110 @@
+package lxDb
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Stamps, fields stamped with time and actor on write, empty names are skipped
+type Stamps struct {
+	CreatedAt string
+	CreatedBy string
+	UpdatedAt string
+	UpdatedBy string
+}
+
+// DefaultStamps, created_at, created_by, updated_at and updated_by
+var DefaultStamps = &Stamps{
+	CreatedAt: "created_at",
+	CreatedBy: "created_by",
+	UpdatedAt: "updated_at",
+	UpdatedBy: "updated_by",
+}
+
+// create, stamp new document, created and updated fields are set
+func (s *Stamps) create(ctx context.Context, now time.Time, data interface{}) (interface{}, error) {
+	if s == nil {
+		return data, nil
+	}
+
+	doc, err := toDoc(data)
+	if err != nil {
+		return nil, err
+	}
+	s.set(doc, s.CreatedAt, now)
+	s.set(doc, s.CreatedBy, ActorFromContext(ctx))
+	s.set(doc, s.UpdatedAt, now)
+	s.set(doc, s.UpdatedBy, ActorFromContext(ctx))
+
+	return doc, nil
+}
+
+// update, stamp updated fields with $set, replacements get them directly and keep the created
+// stamps of the stored document, find loads it and the query is pinned to its _id,
+// find is nil for multi updates which don't replace
+func (s *Stamps) update(ctx context.Context, now time.Time, query, data interface{}, find func(query interface{}, result interface{}) error) (interface{}, interface{}, error) {
+	if s == nil {
+		return query, data, nil
+	}
+
+	u, err := toDoc(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc := u
+	if isOperatorDoc(u) {
+		set, _ := u["$set"].(bson.M)
+		if set == nil {
+			set = bson.M{}
+		}
+		u["$set"] = set
+		doc = set
+	} else if find != nil && (s.CreatedAt != "" || s.CreatedBy != "") {
+		if query, err = s.keepCreated(query, u, find); err != nil {
+			return nil, nil, err
+		}
+	}
+	s.set(doc, s.UpdatedAt, now)
+	s.set(doc, s.UpdatedBy, ActorFromContext(ctx))
+
+	return query, u, nil
+}
+
+// keepCreated, copy the created stamps of the stored document into the replacement,
+// returns query pinned to the stored document, unchanged without a match
+func (s *Stamps) keepCreated(query interface{}, replacement bson.M, find func(query interface{}, result interface{}) error) (interface{}, error) {
+	var stored bson.M
+	if err := find(query, &stored); err == ErrNotFound {
+		return query, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, field := range []string{s.CreatedAt, s.CreatedBy} {
+		if v, ok := stored[field]; ok && field != "" {
+			replacement[field] = v
+		}
+	}
+
+	return andQuery(query, bson.M{"_id": stored["_id"]})
+}
+
+// set, set field unless its name or an actor is missing
+func (s *Stamps) set(doc bson.M, field string, value interface{}) {
+	if field == "" || value == "" {
+		return
+	}
+	doc[field] = value
+}
+
+// clockTime, current time of clock, time.Now when nil
+func clockTime(clock func() time.Time) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+
+	return clock()
+}
@@ -0,0 +1,81 @@
+package lxDb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type stampedDoc struct {
+	Id        string    `bson:"_id"`
+	Name      string    `bson:"name"`
+	CreatedAt time.Time `bson:"created_at"`
+	CreatedBy string    `bson:"created_by"`
+	UpdatedAt time.Time `bson:"updated_at"`
+	UpdatedBy string    `bson:"updated_by"`
+}
+
+func TestMemoryDb_Stamps(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+	db.Stamps = lxDb.DefaultStamps
+	db.Clock = func() time.Time { return now }
+
+	t.Run("Create stamps created and updated fields", func(t *testing.T) {
+		assert.NoError(t, db.CreateContext(lxDb.WithActor(context.Background(), "otto"), &stampedDoc{Id: "a", Name: "a"}))
+
+		var doc stampedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.True(t, now.Equal(doc.CreatedAt))
+		assert.True(t, now.Equal(doc.UpdatedAt))
+		assert.Equal(t, "otto", doc.CreatedBy)
+		assert.Equal(t, "otto", doc.UpdatedBy)
+	})
+
+	t.Run("Update stamps updated fields only", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		ctx := lxDb.WithActor(context.Background(), "emil")
+		assert.NoError(t, db.UpdateContext(ctx, bson.M{"_id": "a"}, bson.M{"$set": bson.M{"name": "b"}}))
+
+		var doc stampedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.Equal(t, "b", doc.Name)
+		assert.True(t, now.Add(-time.Hour).Equal(doc.CreatedAt))
+		assert.Equal(t, "otto", doc.CreatedBy)
+		assert.True(t, now.Equal(doc.UpdatedAt))
+		assert.Equal(t, "emil", doc.UpdatedBy)
+	})
+
+	t.Run("Replacement keeps created stamps", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		ctx := lxDb.WithActor(context.Background(), "emil")
+		assert.NoError(t, db.CreateContext(lxDb.WithActor(context.Background(), "otto"), &stampedDoc{Id: "r", Name: "r"}))
+		assert.NoError(t, db.UpdateContext(ctx, bson.M{"_id": "r"}, &stampedDoc{Id: "r", Name: "replaced"}))
+		assert.NoError(t, db.UpdateContext(ctx, bson.M{"_id": "r"}, bson.M{"name": "again"}))
+
+		var doc stampedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "r"}, &doc))
+		assert.Equal(t, "again", doc.Name)
+		assert.True(t, now.Equal(doc.CreatedAt))
+		assert.Equal(t, "otto", doc.CreatedBy)
+		assert.True(t, now.Equal(doc.UpdatedAt))
+		assert.Equal(t, "emil", doc.UpdatedBy)
+
+		assert.Equal(t, lxDb.ErrNotFound, db.Update(bson.M{"_id": "missing"}, bson.M{"name": "x"}))
+	})
+
+	t.Run("UpdateAll without actor keeps updated_by", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		_, err := db.UpdateAll(nil, bson.M{"$inc": bson.M{"count": 1}})
+		assert.NoError(t, err)
+
+		var doc stampedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.True(t, now.Equal(doc.UpdatedAt))
+		assert.Equal(t, "emil", doc.UpdatedBy)
+	})
+}
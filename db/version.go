@@ -0,0 +1,130 @@
+package lxDb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrMissingVersion, replacement of a versioned document without expected version
+var ErrMissingVersion = errors.New("replacement needs the expected document version")
+
+// ConflictError, returned by Update when the document was changed since the expected version
+type ConflictError struct {
+	Version int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict: document was changed since version %d", e.Version)
+}
+
+// IsConflict, check if err is a version conflict
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+type versionKey struct{}
+
+// WithVersion, return ctx carrying the expected document version for UpdateContext
+func WithVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// VersionFromContext, return the expected document version of ctx
+func VersionFromContext(ctx context.Context) (int64, bool) {
+	version, ok := ctx.Value(versionKey{}).(int64)
+	return version, ok
+}
+
+// versionCreate, start new documents at version 1
+func versionCreate(field string, data interface{}) (interface{}, error) {
+	if field == "" {
+		return data, nil
+	}
+
+	doc, err := toDoc(data)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := toVersion(doc[field]); !ok || v <= 0 {
+		doc[field] = int64(1)
+	}
+
+	return doc, nil
+}
+
+// versionUpdate, add version check and increment to query and update,
+// the expected version is taken from ctx or the version field of a replacement
+func versionUpdate(ctx context.Context, field string, query, data interface{}, check bool) (interface{}, interface{}, int64, bool, error) {
+	if field == "" {
+		return query, data, 0, false, nil
+	}
+
+	u, err := toDoc(data)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	version, checked := VersionFromContext(ctx)
+	if isOperatorDoc(u) {
+		inc, _ := u["$inc"].(bson.M)
+		if inc == nil {
+			inc = bson.M{}
+		}
+		inc[field] = int64(1)
+		u["$inc"] = inc
+		if set, ok := u["$set"].(bson.M); ok {
+			delete(set, field)
+		}
+	} else {
+		if !checked {
+			version, checked = toVersion(u[field])
+		}
+		if !checked || version <= 0 {
+			return nil, nil, 0, false, ErrMissingVersion
+		}
+		u[field] = version + 1
+	}
+
+	if !check || !checked {
+		return query, u, 0, false, nil
+	}
+
+	q, err := andQuery(query, bson.M{field: version})
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	return q, u, version, true, nil
+}
+
+// versionConflict, tell apart a stale version from a missing document
+func versionConflict(n int, err error, version int64) error {
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return &ConflictError{Version: version}
+	}
+
+	return ErrNotFound
+}
+
+// toVersion, convert numeric version values
+func toVersion(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+
+	return 0, false
+}
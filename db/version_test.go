@@ -0,0 +1,96 @@
+package lxDb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/stretchr/testify/assert"
+)
+
+type versionedDoc struct {
+	Id      string `bson:"_id"`
+	Name    string `bson:"name"`
+	Version int64  `bson:"version"`
+}
+
+func setupVersioned(t *testing.T) *lxDb.MemoryDb {
+	db := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), TestDbName, TestCollection)
+	db.VersionField = "version"
+	if err := db.Create(&versionedDoc{Id: "a", Name: "otto"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestMemoryDb_Version(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Create starts at version 1", func(t *testing.T) {
+		db := setupVersioned(t)
+
+		var doc versionedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.Equal(t, int64(1), doc.Version)
+	})
+
+	t.Run("Update increments version", func(t *testing.T) {
+		db := setupVersioned(t)
+		assert.NoError(t, db.Update(bson.M{"_id": "a"}, bson.M{"$set": bson.M{"name": "emil"}}))
+		assert.NoError(t, db.UpdateContext(lxDb.WithVersion(ctx, 2), bson.M{"_id": "a"}, bson.M{"$set": bson.M{"name": "hans"}}))
+
+		var doc versionedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.Equal(t, "hans", doc.Name)
+		assert.Equal(t, int64(3), doc.Version)
+	})
+
+	t.Run("Outdated version returns conflict", func(t *testing.T) {
+		db := setupVersioned(t)
+		assert.NoError(t, db.UpdateContext(lxDb.WithVersion(ctx, 1), bson.M{"_id": "a"}, bson.M{"$set": bson.M{"name": "emil"}}))
+
+		err := db.UpdateContext(lxDb.WithVersion(ctx, 1), bson.M{"_id": "a"}, bson.M{"$set": bson.M{"name": "hans"}})
+		assert.True(t, lxDb.IsConflict(err))
+		assert.Equal(t, &lxDb.ConflictError{Version: 1}, err)
+
+		var doc versionedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.Equal(t, "emil", doc.Name)
+	})
+
+	t.Run("Missing document returns not found", func(t *testing.T) {
+		db := setupVersioned(t)
+		err := db.UpdateContext(lxDb.WithVersion(ctx, 1), bson.M{"_id": "b"}, bson.M{"$set": bson.M{"name": "hans"}})
+		assert.Equal(t, lxDb.ErrNotFound, err)
+	})
+
+	t.Run("Replacement checks version of document", func(t *testing.T) {
+		db := setupVersioned(t)
+		assert.NoError(t, db.Update(bson.M{"_id": "a"}, &versionedDoc{Id: "a", Name: "emil", Version: 1}))
+
+		err := db.Update(bson.M{"_id": "a"}, &versionedDoc{Id: "a", Name: "hans", Version: 1})
+		assert.True(t, lxDb.IsConflict(err))
+
+		err = db.Update(bson.M{"_id": "a"}, &versionedDoc{Id: "a", Name: "hans"})
+		assert.Equal(t, lxDb.ErrMissingVersion, err)
+
+		var doc versionedDoc
+		assert.NoError(t, db.GetOne(bson.M{"_id": "a"}, &doc))
+		assert.Equal(t, versionedDoc{Id: "a", Name: "emil", Version: 2}, doc)
+	})
+
+	t.Run("UpdateAll increments without check", func(t *testing.T) {
+		db := setupVersioned(t)
+		assert.NoError(t, db.Create(&versionedDoc{Id: "b", Name: "emil"}))
+
+		info, err := db.UpdateAllContext(lxDb.WithVersion(ctx, 5), nil, bson.M{"$set": bson.M{"name": "hans"}})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, info.Updated)
+
+		n, err := db.GetCount(bson.M{"version": 2})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+}
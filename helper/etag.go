@@ -0,0 +1,87 @@
+package lxHelper
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+const (
+	HeaderETag    = "ETag"
+	HeaderIfMatch = "If-Match"
+
+	// IfMatchAny, If-Match value matching any current version
+	IfMatchAny = "*"
+)
+
+// ETag, format document version as strong entity tag
+func ETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ParseETag, return document version of entity tag, weak tags are accepted
+func ParseETag(tag string) (int64, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+
+	s, err := strconv.Unquote(tag)
+	if err != nil {
+		s = tag
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// SetETag, set ETag header of echo response
+func SetETag(c echo.Context, version int64) {
+	c.Response().Header().Set(HeaderETag, ETag(version))
+}
+
+// IfMatch, return version of If-Match header, ok is false without header or for *
+func IfMatch(c echo.Context) (int64, bool, error) {
+	tag := strings.TrimSpace(c.Request().Header.Get(HeaderIfMatch))
+	if tag == "" || tag == IfMatchAny {
+		return 0, false, nil
+	}
+
+	version, err := ParseETag(tag)
+	if err != nil {
+		return 0, false, echo.NewHTTPError(http.StatusBadRequest, "invalid If-Match header")
+	}
+
+	return version, true, nil
+}
+
+// IfMatchContext, return request context with the If-Match version for lxDb UpdateContext,
+// required enforces the header with 428 Precondition Required, * satisfies it without a version check
+func IfMatchContext(c echo.Context, required bool) (context.Context, error) {
+	ctx := c.Request().Context()
+	if strings.TrimSpace(c.Request().Header.Get(HeaderIfMatch)) == IfMatchAny {
+		return ctx, nil
+	}
+
+	version, ok, err := IfMatch(c)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if required {
+			return nil, echo.NewHTTPError(http.StatusPreconditionRequired, "If-Match header required")
+		}
+		return ctx, nil
+	}
+
+	return lxDb.WithVersion(ctx, version), nil
+}
+
+// ConflictToHTTP, map lxDb version conflicts to 412 Precondition Failed, other errors are returned as is
+func ConflictToHTTP(err error) error {
+	if lxDb.IsConflict(err) {
+		return echo.NewHTTPError(http.StatusPreconditionFailed, err.Error())
+	}
+
+	return err
+}
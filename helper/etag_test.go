@@ -0,0 +1,80 @@
+package lxHelper_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/helper"
+	"github.com/litixsoft/lx-golib/test-helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETag(t *testing.T) {
+	assert.Equal(t, `"3"`, lxHelper.ETag(3))
+
+	for _, tag := range []string{`"3"`, `W/"3"`, `3`} {
+		v, err := lxHelper.ParseETag(tag)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), v)
+	}
+
+	_, err := lxHelper.ParseETag(`"abc"`)
+	assert.Error(t, err)
+
+	rec, c := lxTestHelper.SetEchoRequest(echo.GET, "/users/1", nil)
+	lxHelper.SetETag(c, 7)
+	assert.Equal(t, `"7"`, rec.Header().Get(lxHelper.HeaderETag))
+}
+
+func TestIfMatchContext(t *testing.T) {
+	t.Run("Version from header", func(t *testing.T) {
+		_, c := lxTestHelper.SetEchoRequest(echo.PUT, "/users/1", nil)
+		c.Request().Header.Set(lxHelper.HeaderIfMatch, `"4"`)
+
+		ctx, err := lxHelper.IfMatchContext(c, true)
+		assert.NoError(t, err)
+		v, ok := lxDb.VersionFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, int64(4), v)
+	})
+
+	t.Run("Missing header", func(t *testing.T) {
+		_, c := lxTestHelper.SetEchoRequest(echo.PUT, "/users/1", nil)
+
+		ctx, err := lxHelper.IfMatchContext(c, false)
+		assert.NoError(t, err)
+		_, ok := lxDb.VersionFromContext(ctx)
+		assert.False(t, ok)
+
+		_, err = lxHelper.IfMatchContext(c, true)
+		assert.Equal(t, http.StatusPreconditionRequired, err.(*echo.HTTPError).Code)
+	})
+
+	t.Run("Any version", func(t *testing.T) {
+		_, c := lxTestHelper.SetEchoRequest(echo.PUT, "/users/1", nil)
+		c.Request().Header.Set(lxHelper.HeaderIfMatch, lxHelper.IfMatchAny)
+
+		ctx, err := lxHelper.IfMatchContext(c, true)
+		assert.NoError(t, err)
+		_, ok := lxDb.VersionFromContext(ctx)
+		assert.False(t, ok)
+	})
+
+	t.Run("Invalid header", func(t *testing.T) {
+		_, c := lxTestHelper.SetEchoRequest(echo.PUT, "/users/1", nil)
+		c.Request().Header.Set(lxHelper.HeaderIfMatch, `"x"`)
+
+		_, err := lxHelper.IfMatchContext(c, false)
+		assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code)
+	})
+}
+
+func TestConflictToHTTP(t *testing.T) {
+	err := lxHelper.ConflictToHTTP(&lxDb.ConflictError{Version: 1})
+	assert.Equal(t, http.StatusPreconditionFailed, err.(*echo.HTTPError).Code)
+
+	assert.Equal(t, context.Canceled, lxHelper.ConflictToHTTP(context.Canceled))
+}
@@ -1,6 +1,26 @@
 package lxHelper
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+var (
+	// filter[field] or filter[field][$op]
+	filterParam = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[(\$[a-z]+)\])?$`)
+
+	// decimal numbers only, no hex, inf or nan
+	numberValue = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
 
 func NewReqByQuery(opts string) (*ReqByQuery, error) {
 	var data ReqByQuery
@@ -12,5 +32,170 @@ func NewReqByQuery(opts string) (*ReqByQuery, error) {
 		}
 	}
 
+	// Check sort and fields syntax
+	if err := data.Options.Validate(nil); err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// NewReqByValues, build request from conventional query string values,
+// e.g. skip=10&limit=20&sort=-name&fields=name,email&filter[email][$eq]=x
+func NewReqByValues(values url.Values) (*ReqByQuery, error) {
+	var data ReqByQuery
+	var err error
+
+	for key, vals := range values {
+		if len(vals) == 0 {
+			continue
+		}
+		val := vals[len(vals)-1]
+
+		switch key {
+		case "skip":
+			if data.Options.Skip, err = strconv.Atoi(val); err != nil {
+				return nil, fmt.Errorf("invalid skip: %s", val)
+			}
+		case "limit":
+			if data.Options.Limit, err = strconv.Atoi(val); err != nil {
+				return nil, fmt.Errorf("invalid limit: %s", val)
+			}
+		case "count":
+			if data.Options.Count, err = strconv.ParseBool(val); err != nil {
+				return nil, fmt.Errorf("invalid count: %s", val)
+			}
+		case "sort":
+			data.Options.Sort = val
+		case "fields":
+			data.Options.Fields = val
+		case "cursor":
+			data.Options.Cursor = val
+		default:
+			match := filterParam.FindStringSubmatch(key)
+			if match == nil {
+				continue
+			}
+			if operatorField(match[1]) {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid filter field: %s", match[1]))
+			}
+			if err := addFilter(&data, match[1], match[2], vals); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Check sort and fields syntax
+	if err := data.Options.Validate(nil); err != nil {
+		return nil, err
+	}
+
 	return &data, nil
-}
\ No newline at end of file
+}
+
+// NewReqByEcho, build request from the query string of echo context
+func NewReqByEcho(c echo.Context) (*ReqByQuery, error) {
+	return NewReqByValues(c.QueryParams())
+}
+
+// operatorField, field name is or contains a $ segment, e.g. $where or a.$expr,
+// such names would inject query operators
+func operatorField(field string) bool {
+	for _, segment := range strings.Split(field, ".") {
+		if strings.HasPrefix(segment, "$") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addFilter, add a filter param to the query
+func addFilter(data *ReqByQuery, field, op string, vals []string) error {
+	if data.Query == nil {
+		data.Query = M{}
+	}
+
+	var value interface{}
+	switch op {
+	case "$in", "$nin", "$all":
+		var list []interface{}
+		for _, v := range vals {
+			for _, item := range strings.Split(v, ",") {
+				list = append(list, CoerceValue(item))
+			}
+		}
+		value = list
+	case "$regex", "$options":
+		value = vals[len(vals)-1]
+	case "$exists":
+		b, err := strconv.ParseBool(vals[len(vals)-1])
+		if err != nil {
+			return fmt.Errorf("invalid filter[%s][%s]: %s", field, op, vals[len(vals)-1])
+		}
+		value = b
+	default:
+		if len(vals) > 1 {
+			return fmt.Errorf("filter[%s] is given more than once", field)
+		}
+		value = CoerceValue(vals[0])
+	}
+
+	// Plain equality
+	if op == "" {
+		if _, ok := data.Query[field]; ok {
+			return fmt.Errorf("filter[%s] conflicts with operator filters", field)
+		}
+		data.Query[field] = value
+		return nil
+	}
+
+	cond, ok := data.Query[field].(M)
+	if !ok {
+		if _, exists := data.Query[field]; exists {
+			return fmt.Errorf("filter[%s] conflicts with equality filter", field)
+		}
+		cond = M{}
+		data.Query[field] = cond
+	}
+	cond[op] = value
+
+	return nil
+}
+
+// CoerceValue, convert query string value to bool, null, number, date or ObjectId,
+// wrap in double quotes to keep a string
+func CoerceValue(s string) interface{} {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+
+	if numberValue.MatchString(s) {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	if bson.IsObjectIdHex(s) {
+		return bson.ObjectIdHex(s)
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t
+	}
+
+	return s
+}
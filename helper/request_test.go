@@ -0,0 +1,102 @@
+package lxHelper_test
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/helper"
+	"github.com/litixsoft/lx-golib/test-helper"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNewReqByQuery(t *testing.T) {
+	t.Run("Parse options and query from json", func(t *testing.T) {
+		req, err := lxHelper.NewReqByQuery(`{"opts":{"skip":10,"limit":20,"sort":"-created,name","fields":"name"},"query":{"name":"otto"}}`)
+		assert.NoError(t, err)
+		assert.Equal(t, lxDb.Options{Skip: 10, Limit: 20, Sort: "-created,name", Fields: "name"}, req.Options)
+		assert.Equal(t, lxHelper.M{"name": "otto"}, req.Query)
+	})
+
+	t.Run("Return error for malformed sort", func(t *testing.T) {
+		_, err := lxHelper.NewReqByQuery(`{"opts":{"sort":"--name"}}`)
+		assert.Error(t, err)
+	})
+}
+
+func TestNewReqByValues(t *testing.T) {
+	t.Run("Parse options and filters from query string", func(t *testing.T) {
+		id := bson.NewObjectId()
+		values, _ := url.ParseQuery("skip=10&limit=20&count=true&sort=-name&fields=name,email" +
+			"&filter[email][$eq]=x@example.com&filter[age][$gte]=18&filter[active]=true" +
+			"&filter[score][$lt]=1.5&filter[zip]=%2201234%22&filter[_id][$in]=" + id.Hex() +
+			"&filter[created][$gt]=2018-05-01T10:00:00Z&filter[name][$regex]=^12")
+
+		req, err := lxHelper.NewReqByValues(values)
+		assert.NoError(t, err)
+		assert.Equal(t, lxDb.Options{Skip: 10, Limit: 20, Count: true, Sort: "-name", Fields: "name,email"}, req.Options)
+		assert.Equal(t, lxHelper.M{
+			"email":   lxHelper.M{"$eq": "x@example.com"},
+			"age":     lxHelper.M{"$gte": int64(18)},
+			"active":  true,
+			"score":   lxHelper.M{"$lt": 1.5},
+			"zip":     "01234",
+			"_id":     lxHelper.M{"$in": []interface{}{id}},
+			"created": lxHelper.M{"$gt": time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)},
+			"name":    lxHelper.M{"$regex": "^12"},
+		}, req.Query)
+	})
+
+	t.Run("Split and merge values of $in", func(t *testing.T) {
+		values, _ := url.ParseQuery("filter[gender][$in]=Male,Female&filter[gender][$in]=null")
+
+		req, err := lxHelper.NewReqByValues(values)
+		assert.NoError(t, err)
+		assert.Equal(t, lxHelper.M{"gender": lxHelper.M{"$in": []interface{}{"Male", "Female", nil}}}, req.Query)
+	})
+
+	t.Run("Keep strings which look like special numbers", func(t *testing.T) {
+		assert.Equal(t, "NaN", lxHelper.CoerceValue("NaN"))
+		assert.Equal(t, "0x10", lxHelper.CoerceValue("0x10"))
+	})
+
+	t.Run("Return error for invalid values", func(t *testing.T) {
+		for _, q := range []string{"skip=a", "limit=1.5", "count=yes", "sort=--name",
+			"filter[a]=1&filter[a][$gt]=2", "filter[a]=1&filter[a]=2", "filter[a][$exists]=maybe"} {
+			values, _ := url.ParseQuery(q)
+			_, err := lxHelper.NewReqByValues(values)
+			assert.Error(t, err, q)
+		}
+	})
+}
+
+func TestNewReqByValues_OperatorFields(t *testing.T) {
+	for _, q := range []string{"filter[$where]=sleep(1000)", "filter[$expr][$gt]=1",
+		"filter[profile.$where]=1", "filter[$or.0.a]=1"} {
+		values, _ := url.ParseQuery(q)
+		_, err := lxHelper.NewReqByValues(values)
+		if assert.IsType(t, &echo.HTTPError{}, err, q) {
+			assert.Equal(t, http.StatusBadRequest, err.(*echo.HTTPError).Code, q)
+		}
+	}
+
+	// Dollar signs inside a name are no operators
+	values, _ := url.ParseQuery("filter[price$]=1")
+	req, err := lxHelper.NewReqByValues(values)
+	assert.NoError(t, err)
+	assert.Equal(t, lxHelper.M{"price$": int64(1)}, req.Query)
+}
+
+func TestNewReqByEcho(t *testing.T) {
+	t.Run("Parse request from echo context", func(t *testing.T) {
+		_, c := lxTestHelper.SetEchoRequest(echo.GET, "/users?limit=5&filter[name]=otto", nil)
+
+		req, err := lxHelper.NewReqByEcho(c)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, req.Options.Limit)
+		assert.Equal(t, lxHelper.M{"name": "otto"}, req.Query)
+	})
+}
@@ -0,0 +1,328 @@
+package lxMigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/audit"
+	"github.com/litixsoft/lx-golib/db"
+)
+
+const (
+	Up   = "up"
+	Down = "down"
+
+	DefaultLockTTL = 10 * time.Minute
+
+	lockId = "data:lock"
+	kind   = "data"
+)
+
+var (
+	// ErrLocked, another runner holds the lock
+	ErrLocked = errors.New("migrations are locked by another runner")
+
+	// ErrIrreversible, migration has no down func
+	ErrIrreversible = errors.New("migration can't be reverted")
+
+	// ErrLockLost, the lock couldn't be refreshed during a run
+	ErrLockLost = errors.New("migration lock was lost")
+)
+
+// Migration, numbered data migration
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// Step, applied or planned migration step
+type Step struct {
+	Version     int           `json:"version"`
+	Description string        `json:"description"`
+	Direction   string        `json:"direction"`
+	Duration    time.Duration `json:"duration"`
+	DryRun      bool          `json:"dry_run"`
+}
+
+// record, applied migration in the state collection
+type record struct {
+	Id          string    `bson:"_id"`
+	Kind        string    `bson:"kind"`
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// lock, lock document against concurrent runs
+type lock struct {
+	Id        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	LockedAt  time.Time `bson:"locked_at"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Runner, applies registered migrations and stores the state in db
+type Runner struct {
+	Owner     string        // Lock owner, hostname and pid by default
+	LockTTL   time.Duration // Lock expires after ttl when a runner dies
+	Heartbeat time.Duration // Lock refresh interval during a run, default LockTTL / 3
+	DryRun    bool          // Only report the steps
+
+	db         lxDb.IBaseDb
+	audit      lxAudit.IAudit
+	migrations []Migration
+}
+
+// NewRunner, return runner with state in db, usually lxDb.MigrationsCollection,
+// audit is optional and receives every applied step
+func NewRunner(db lxDb.IBaseDb, audit lxAudit.IAudit) *Runner {
+	host, _ := os.Hostname()
+
+	return &Runner{
+		Owner:   fmt.Sprintf("%s:%d", host, os.Getpid()),
+		LockTTL: DefaultLockTTL,
+		db:      db,
+		audit:   audit,
+	}
+}
+
+// Register, add migrations, versions must be positive and unique
+func (r *Runner) Register(migrations ...Migration) error {
+	for _, m := range migrations {
+		if m.Version <= 0 {
+			return fmt.Errorf("migration version must be positive, got %d", m.Version)
+		}
+		if m.Up == nil {
+			return fmt.Errorf("migration %d has no up func", m.Version)
+		}
+		for _, other := range r.migrations {
+			if other.Version == m.Version {
+				return fmt.Errorf("migration %d is already registered", m.Version)
+			}
+		}
+		r.migrations = append(r.migrations, m)
+	}
+
+	sort.Slice(r.migrations, func(i, j int) bool {
+		return r.migrations[i].Version < r.migrations[j].Version
+	})
+
+	return nil
+}
+
+// Version, highest applied version, 0 when nothing is applied
+func (r *Runner) Version(ctx context.Context) (int, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version, nil
+}
+
+// Up, apply all pending migrations up to target, 0 for all
+func (r *Runner) Up(ctx context.Context, target int) ([]Step, error) {
+	return r.run(ctx, Up, target)
+}
+
+// Down, revert all applied migrations above target
+func (r *Runner) Down(ctx context.Context, target int) ([]Step, error) {
+	return r.run(ctx, Down, target)
+}
+
+// run, lock, plan and apply the steps of direction, the lock is refreshed until the run ends
+func (r *Runner) run(ctx context.Context, direction string, target int) (steps []Step, err error) {
+	if !r.DryRun {
+		if err := r.lock(ctx); err != nil {
+			return nil, err
+		}
+		defer r.unlock()
+
+		// Steps are cancelled when the lock is lost, its error wins
+		var stop func() error
+		ctx, stop = r.heartbeat(ctx)
+		defer func() {
+			if lost := stop(); lost != nil {
+				err = lost
+			}
+		}()
+	}
+
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the whole plan, a run doesn't stop halfway at an irreversible step
+	plan := r.plan(direction, target, applied)
+	if direction == Down {
+		for _, m := range plan {
+			if m.Down == nil {
+				return nil, fmt.Errorf("%v: %d %s", ErrIrreversible, m.Version, m.Description)
+			}
+		}
+	}
+
+	for _, m := range plan {
+		step := Step{Version: m.Version, Description: m.Description, Direction: direction, DryRun: r.DryRun}
+		if r.DryRun {
+			steps = append(steps, step)
+			continue
+		}
+
+		start := time.Now()
+		if err := r.apply(ctx, direction, m); err != nil {
+			return steps, fmt.Errorf("migration %d %s failed: %v", m.Version, direction, err)
+		}
+		step.Duration = time.Since(start)
+		steps = append(steps, step)
+
+		if r.audit != nil {
+			message := fmt.Sprintf("migration %s %d: %s", direction, m.Version, m.Description)
+			if err := r.audit.LogSyncContext(ctx, r.Owner, message, step); err != nil {
+				return steps, fmt.Errorf("migration %d %s applied, audit failed: %v", m.Version, direction, err)
+			}
+		}
+	}
+
+	return steps, nil
+}
+
+// plan, migrations to run in order
+func (r *Runner) plan(direction string, target int, applied map[int]bool) []Migration {
+	var plan []Migration
+
+	if direction == Up {
+		for _, m := range r.migrations {
+			if !applied[m.Version] && (target <= 0 || m.Version <= target) {
+				plan = append(plan, m)
+			}
+		}
+		return plan
+	}
+
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		if m := r.migrations[i]; applied[m.Version] && m.Version > target {
+			plan = append(plan, m)
+		}
+	}
+
+	return plan
+}
+
+// apply, run migration func and store the state
+func (r *Runner) apply(ctx context.Context, direction string, m Migration) error {
+	id := fmt.Sprintf("%s:%08d", kind, m.Version)
+
+	if direction == Down {
+		if err := m.Down(ctx); err != nil {
+			return err
+		}
+		return r.db.DeleteContext(ctx, bson.M{"_id": id})
+	}
+
+	if err := m.Up(ctx); err != nil {
+		return err
+	}
+
+	return r.db.CreateContext(ctx, &record{
+		Id:          id,
+		Kind:        kind,
+		Version:     m.Version,
+		Description: m.Description,
+		AppliedAt:   time.Now(),
+	})
+}
+
+// applied, versions of applied migrations
+func (r *Runner) applied(ctx context.Context) (map[int]bool, error) {
+	var records []record
+	if _, err := r.db.GetAllContext(ctx, bson.M{"kind": kind}, &records, nil); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]bool{}
+	for _, rec := range records {
+		applied[rec.Version] = true
+	}
+
+	return applied, nil
+}
+
+// lock, acquire the lock document, expired locks are removed
+func (r *Runner) lock(ctx context.Context) error {
+	now := time.Now()
+	if _, err := r.db.DeleteAllContext(ctx, bson.M{"_id": lockId, "expires_at": bson.M{"$lt": now}}); err != nil {
+		return err
+	}
+
+	err := r.db.CreateContext(ctx, &lock{Id: lockId, Owner: r.Owner, LockedAt: now, ExpiresAt: now.Add(r.LockTTL)})
+	if mgo.IsDup(err) {
+		return ErrLocked
+	}
+
+	return err
+}
+
+// heartbeat, extend the lock every Heartbeat until stop, the returned context is cancelled
+// when the lock can't be extended, stop returns ErrLockLost in that case
+func (r *Runner) heartbeat(ctx context.Context) (context.Context, func() error) {
+	interval := r.Heartbeat
+	if interval <= 0 {
+		interval = r.LockTTL / 3
+	}
+	if interval <= 0 {
+		interval = DefaultLockTTL / 3
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	var lost error
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := r.db.UpdateContext(ctx, bson.M{"_id": lockId, "owner": r.Owner},
+					bson.M{"$set": bson.M{"expires_at": time.Now().Add(r.LockTTL)}})
+				if err != nil && ctx.Err() == nil {
+					lost = fmt.Errorf("%v: %v", ErrLockLost, err)
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ctx, func() error {
+		cancel()
+		<-done
+		return lost
+	}
+}
+
+// unlock, release the lock of this runner, even after the context is done
+func (r *Runner) unlock() {
+	r.db.DeleteAll(bson.M{"_id": lockId, "owner": r.Owner})
+}
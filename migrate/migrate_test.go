@@ -0,0 +1,229 @@
+package lxMigrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/audit/repos"
+	"github.com/litixsoft/lx-golib/db"
+	"github.com/litixsoft/lx-golib/migrate"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRunner(t *testing.T) (*lxMigrate.Runner, *lxDb.MemoryDb, *[]string) {
+	store := lxDb.NewMemoryStore()
+	state := lxDb.NewMemoryDb(store, "test_db", lxDb.MigrationsCollection)
+	audit := lxAuditRepos.NewAuditMongo(lxDb.NewMemoryDb(store, "test_db", "audit"), "test_service", "localhost")
+
+	var calls []string
+	step := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return nil
+		}
+	}
+
+	r := lxMigrate.NewRunner(state, audit)
+	assert.NoError(t, r.Register(
+		lxMigrate.Migration{Version: 2, Description: "split name", Up: step("up2"), Down: step("down2")},
+		lxMigrate.Migration{Version: 1, Description: "add status", Up: step("up1"), Down: step("down1")},
+		lxMigrate.Migration{Version: 3, Description: "drop legacy", Up: step("up3")},
+	))
+
+	return r, state, &calls
+}
+
+func TestRunner_Register(t *testing.T) {
+	r := lxMigrate.NewRunner(lxDb.NewMemoryDb(lxDb.NewMemoryStore(), "test_db", lxDb.MigrationsCollection), nil)
+	up := func(ctx context.Context) error { return nil }
+
+	assert.NoError(t, r.Register(lxMigrate.Migration{Version: 1, Up: up}))
+	assert.Error(t, r.Register(lxMigrate.Migration{Version: 1, Up: up}))
+	assert.Error(t, r.Register(lxMigrate.Migration{Version: 0, Up: up}))
+	assert.Error(t, r.Register(lxMigrate.Migration{Version: 2}))
+}
+
+func TestRunner_UpDown(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("up to target and latest", func(t *testing.T) {
+		r, _, calls := setupRunner(t)
+
+		steps, err := r.Up(ctx, 2)
+		assert.NoError(t, err)
+		assert.Len(t, steps, 2)
+		assert.Equal(t, []string{"up1", "up2"}, *calls)
+
+		v, err := r.Version(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, v)
+
+		steps, err = r.Up(ctx, 0)
+		assert.NoError(t, err)
+		assert.Len(t, steps, 1)
+		assert.Equal(t, 3, steps[0].Version)
+		assert.Equal(t, lxMigrate.Up, steps[0].Direction)
+	})
+
+	t.Run("down in reverse order", func(t *testing.T) {
+		r, _, calls := setupRunner(t)
+		_, err := r.Up(ctx, 2)
+		assert.NoError(t, err)
+
+		steps, err := r.Down(ctx, 0)
+		assert.NoError(t, err)
+		assert.Len(t, steps, 2)
+		assert.Equal(t, []string{"up1", "up2", "down2", "down1"}, *calls)
+
+		v, err := r.Version(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, v)
+	})
+
+	t.Run("irreversible migration", func(t *testing.T) {
+		r, _, _ := setupRunner(t)
+		_, err := r.Up(ctx, 0)
+		assert.NoError(t, err)
+
+		_, err = r.Down(ctx, 0)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), lxMigrate.ErrIrreversible.Error())
+	})
+
+	t.Run("irreversible step stops the run before reverting", func(t *testing.T) {
+		var calls []string
+		r := lxMigrate.NewRunner(lxDb.NewMemoryDb(lxDb.NewMemoryStore(), "test_db", lxDb.MigrationsCollection), nil)
+		assert.NoError(t, r.Register(
+			lxMigrate.Migration{Version: 1, Up: func(ctx context.Context) error { return nil }},
+			lxMigrate.Migration{Version: 2, Up: func(ctx context.Context) error { return nil }, Down: func(ctx context.Context) error {
+				calls = append(calls, "down2")
+				return nil
+			}},
+		))
+		_, err := r.Up(ctx, 0)
+		assert.NoError(t, err)
+
+		steps, err := r.Down(ctx, 0)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), lxMigrate.ErrIrreversible.Error())
+		assert.Empty(t, steps)
+		assert.Empty(t, calls)
+
+		v, _ := r.Version(ctx)
+		assert.Equal(t, 2, v)
+	})
+
+	t.Run("failed step stops the run", func(t *testing.T) {
+		r := lxMigrate.NewRunner(lxDb.NewMemoryDb(lxDb.NewMemoryStore(), "test_db", lxDb.MigrationsCollection), nil)
+		assert.NoError(t, r.Register(
+			lxMigrate.Migration{Version: 1, Up: func(ctx context.Context) error { return nil }},
+			lxMigrate.Migration{Version: 2, Up: func(ctx context.Context) error { return errors.New("boom") }},
+		))
+
+		steps, err := r.Up(ctx, 0)
+		assert.Error(t, err)
+		assert.Len(t, steps, 1)
+
+		v, _ := r.Version(ctx)
+		assert.Equal(t, 1, v)
+	})
+}
+
+func TestRunner_DryRun(t *testing.T) {
+	ctx := context.Background()
+	r, _, calls := setupRunner(t)
+	r.DryRun = true
+
+	steps, err := r.Up(ctx, 0)
+	assert.NoError(t, err)
+	assert.Len(t, steps, 3)
+	assert.True(t, steps[0].DryRun)
+	assert.Empty(t, *calls)
+
+	v, err := r.Version(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, v)
+}
+
+func TestRunner_Lock(t *testing.T) {
+	ctx := context.Background()
+	r, state, _ := setupRunner(t)
+
+	assert.NoError(t, state.Create(bson.M{"_id": "data:lock", "owner": "other", "expires_at": time.Now().Add(time.Minute)}))
+	_, err := r.Up(ctx, 0)
+	assert.Equal(t, lxMigrate.ErrLocked, err)
+
+	// Expired lock is taken over
+	assert.NoError(t, state.Update(bson.M{"_id": "data:lock"}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}}))
+	_, err = r.Up(ctx, 0)
+	assert.NoError(t, err)
+
+	n, err := state.GetCount(bson.M{"_id": "data:lock"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestRunner_Heartbeat(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("lock is extended during a run", func(t *testing.T) {
+		state := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), "test_db", lxDb.MigrationsCollection)
+		r := lxMigrate.NewRunner(state, nil)
+		r.LockTTL = 50 * time.Millisecond
+		r.Heartbeat = 10 * time.Millisecond
+		assert.NoError(t, r.Register(lxMigrate.Migration{Version: 1, Up: func(ctx context.Context) error {
+			time.Sleep(150 * time.Millisecond)
+
+			var lock struct {
+				ExpiresAt time.Time `bson:"expires_at"`
+			}
+			assert.NoError(t, state.GetOne(bson.M{"_id": "data:lock"}, &lock))
+			assert.True(t, lock.ExpiresAt.After(time.Now()), "lock should be extended")
+			return nil
+		}}))
+
+		_, err := r.Up(ctx, 0)
+		assert.NoError(t, err)
+	})
+
+	t.Run("lost lock cancels the run", func(t *testing.T) {
+		state := lxDb.NewMemoryDb(lxDb.NewMemoryStore(), "test_db", lxDb.MigrationsCollection)
+		r := lxMigrate.NewRunner(state, nil)
+		r.Heartbeat = 10 * time.Millisecond
+		assert.NoError(t, r.Register(lxMigrate.Migration{Version: 1, Up: func(ctx context.Context) error {
+			// Another runner took the lock over
+			assert.NoError(t, state.Update(bson.M{"_id": "data:lock"}, bson.M{"$set": bson.M{"owner": "other"}}))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		}}))
+
+		_, err := r.Up(ctx, 0)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), lxMigrate.ErrLockLost.Error())
+
+		v, _ := r.Version(ctx)
+		assert.Equal(t, 0, v)
+	})
+}
+
+func TestRunner_Audit(t *testing.T) {
+	store := lxDb.NewMemoryStore()
+	auditDb := lxDb.NewMemoryDb(store, "test_db", "audit")
+	r := lxMigrate.NewRunner(lxDb.NewMemoryDb(store, "test_db", lxDb.MigrationsCollection), lxAuditRepos.NewAuditMongo(auditDb, "test_service", "localhost"))
+	assert.NoError(t, r.Register(lxMigrate.Migration{Version: 1, Description: "add status", Up: func(ctx context.Context) error { return nil }}))
+
+	_, err := r.Up(context.Background(), 0)
+	assert.NoError(t, err)
+
+	n, err := auditDb.GetCount(bson.M{"message": "migration up 1: add status"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
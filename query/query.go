@@ -0,0 +1,379 @@
+package lxQuery
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/helper"
+)
+
+const (
+	DefaultMaxDepth       = 4
+	DefaultMaxRegexLength = 64
+	DefaultMaxInLength    = 100
+)
+
+// comparison operators which can be allowed per field
+var comparisonOps = map[string]bool{
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$all": true, "$exists": true, "$regex": true, "$size": true,
+}
+
+// logical operators which can be allowed per policy
+var logicalOps = map[string]bool{"$and": true, "$or": true, "$nor": true}
+
+// Violation, a rejected part of a query
+type Violation struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Error, all violations of a rejected query
+type Error struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = fmt.Sprintf("%s: %s", v.Path, v.Reason)
+	}
+
+	return "query rejected: " + strings.Join(msgs, "; ")
+}
+
+// Policy, whitelist of fields and operators for the queries of one resource
+type Policy struct {
+	Fields         map[string][]string // Allowed comparison operators per field, "$eq" allows plain values
+	Logical        []string            // Allowed logical operators
+	MaxDepth       int                 // Max nesting of logical operators
+	MaxRegexLength int                 // Max length of regex patterns
+	MaxInLength    int                 // Max number of values for $in, $nin and $all
+}
+
+// NewPolicy, return policy with default limits and all logical operators allowed
+func NewPolicy() *Policy {
+	return &Policy{
+		Fields:         map[string][]string{},
+		Logical:        []string{"$and", "$or", "$nor"},
+		MaxDepth:       DefaultMaxDepth,
+		MaxRegexLength: DefaultMaxRegexLength,
+		MaxInLength:    DefaultMaxInLength,
+	}
+}
+
+// Allow, allow field with operators, panics on unknown operators
+func (p *Policy) Allow(field string, operators ...string) *Policy {
+	for _, op := range operators {
+		if !comparisonOps[op] {
+			panic(fmt.Sprintf("lxQuery: unknown operator %s", op))
+		}
+	}
+	p.Fields[field] = append(p.Fields[field], operators...)
+
+	return p
+}
+
+// Sanitize, check the client query against the policy and convert it to a bson filter
+func (p *Policy) Sanitize(query lxHelper.M) (bson.M, error) {
+	s := &sanitizer{policy: p}
+	filter := s.document("", query, 0)
+
+	if len(s.violations) > 0 {
+		return nil, &Error{Violations: s.violations}
+	}
+
+	return filter, nil
+}
+
+// sanitizer, state of one Sanitize run
+type sanitizer struct {
+	policy     *Policy
+	violations []Violation
+}
+
+func (s *sanitizer) reject(path, format string, args ...interface{}) {
+	s.violations = append(s.violations, Violation{Path: path, Reason: fmt.Sprintf(format, args...)})
+}
+
+// document, sanitize a filter document
+func (s *sanitizer) document(path string, doc map[string]interface{}, depth int) bson.M {
+	filter := bson.M{}
+
+	for _, key := range sortedKeys(doc) {
+		value := doc[key]
+		keyPath := join(path, key)
+
+		if strings.HasPrefix(key, "$") {
+			if !logicalOps[key] || !contains(s.policy.Logical, key) {
+				s.reject(keyPath, "operator is not allowed")
+				continue
+			}
+			if depth+1 > s.policy.MaxDepth {
+				s.reject(keyPath, "max depth of %d exceeded", s.policy.MaxDepth)
+				continue
+			}
+
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				s.reject(keyPath, "must be a non-empty array")
+				continue
+			}
+
+			docs := make([]interface{}, 0, len(list))
+			for i, item := range list {
+				m, ok := toMap(item)
+				if !ok {
+					s.reject(fmt.Sprintf("%s.%d", keyPath, i), "must be a document")
+					continue
+				}
+				docs = append(docs, s.document(fmt.Sprintf("%s.%d", keyPath, i), m, depth+1))
+			}
+			filter[key] = docs
+			continue
+		}
+
+		ops, ok := s.policy.Fields[key]
+		if !ok {
+			s.reject(keyPath, "field is not allowed")
+			continue
+		}
+		if v, ok := s.field(keyPath, value, ops); ok {
+			filter[key] = v
+		}
+	}
+
+	return filter
+}
+
+// field, sanitize the condition of a field
+func (s *sanitizer) field(path string, value interface{}, ops []string) (interface{}, bool) {
+	m, isMap := toMap(value)
+
+	// Plain value is equality
+	if !isMap {
+		if !contains(ops, "$eq") {
+			s.reject(path, "operator $eq is not allowed")
+			return nil, false
+		}
+		return s.scalar(path, value)
+	}
+
+	cond := bson.M{}
+	valid := true
+	for _, op := range sortedKeys(m) {
+		opPath := join(path, op)
+		v := m[op]
+
+		if op == "$options" {
+			if _, ok := m["$regex"]; !ok {
+				s.reject(opPath, "only allowed with $regex")
+				valid = false
+			}
+			continue
+		}
+		if !strings.HasPrefix(op, "$") {
+			s.reject(opPath, "embedded documents are not allowed")
+			valid = false
+			continue
+		}
+		if !comparisonOps[op] || !contains(ops, op) {
+			s.reject(opPath, "operator is not allowed")
+			valid = false
+			continue
+		}
+
+		var ok bool
+		switch op {
+		case "$in", "$nin", "$all":
+			v, ok = s.list(opPath, v)
+		case "$exists":
+			if _, ok = v.(bool); !ok {
+				s.reject(opPath, "must be a boolean")
+			}
+		case "$size":
+			if ok = isNumber(v); !ok {
+				s.reject(opPath, "must be a number")
+			}
+		case "$regex":
+			options, _ := m["$options"].(string)
+			v, ok = s.regex(opPath, v, options)
+		default:
+			v, ok = s.scalar(opPath, v)
+		}
+
+		if !ok {
+			valid = false
+			continue
+		}
+		cond[op] = v
+	}
+
+	return cond, valid
+}
+
+// scalar, check plain value
+func (s *sanitizer) scalar(path string, value interface{}) (interface{}, bool) {
+	switch value.(type) {
+	case nil, string, bool, float64, float32, int, int32, int64, time.Time, bson.ObjectId:
+		return value, true
+	}
+
+	s.reject(path, "value must be a string, number, boolean, date, ObjectId or null")
+	return nil, false
+}
+
+// list, check values of $in, $nin and $all
+func (s *sanitizer) list(path string, value interface{}) (interface{}, bool) {
+	list, ok := value.([]interface{})
+	if !ok {
+		s.reject(path, "must be an array")
+		return nil, false
+	}
+	if len(list) > s.policy.MaxInLength {
+		s.reject(path, "max length of %d exceeded", s.policy.MaxInLength)
+		return nil, false
+	}
+
+	for i, v := range list {
+		if _, ok := s.scalar(fmt.Sprintf("%s.%d", path, i), v); !ok {
+			return nil, false
+		}
+	}
+
+	return list, true
+}
+
+// regex, check pattern and options for complexity
+func (s *sanitizer) regex(path string, value interface{}, options string) (interface{}, bool) {
+	pattern, ok := value.(string)
+	if !ok {
+		s.reject(path, "must be a string")
+		return nil, false
+	}
+	if len(pattern) > s.policy.MaxRegexLength {
+		s.reject(path, "max length of %d exceeded", s.policy.MaxRegexLength)
+		return nil, false
+	}
+	if nestedQuantifier(pattern) {
+		s.reject(path, "nested quantifiers are not allowed")
+		return nil, false
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		s.reject(path, "invalid pattern")
+		return nil, false
+	}
+	if strings.Trim(options, "imsx") != "" {
+		s.reject(join(path, "$options"), "only i, m, s and x are allowed")
+		return nil, false
+	}
+
+	return bson.RegEx{Pattern: pattern, Options: options}, true
+}
+
+// nestedQuantifier, pattern repeats a group containing a quantifier at any depth,
+// like (a+)+, ((a+))+ or (?:(a*)b)*, such patterns are prone to catastrophic backtracking
+func nestedQuantifier(pattern string) bool {
+	quantifier := func(i int) bool {
+		return i < len(pattern) && strings.IndexByte("+*{", pattern[i]) >= 0
+	}
+
+	// Per open group, whether it contains a quantifier
+	var groups []bool
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			// Skip character class, a leading ] is a literal
+			i++
+			if i < len(pattern) && pattern[i] == '^' {
+				i++
+			}
+			if i < len(pattern) && pattern[i] == ']' {
+				i++
+			}
+			for ; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			groups = append(groups, false)
+		case ')':
+			if len(groups) == 0 {
+				continue
+			}
+			inner := groups[len(groups)-1]
+			groups = groups[:len(groups)-1]
+			if inner && quantifier(i+1) {
+				return true
+			}
+			if len(groups) > 0 && (inner || quantifier(i+1)) {
+				groups[len(groups)-1] = true
+			}
+		default:
+			if quantifier(i) && len(groups) > 0 {
+				groups[len(groups)-1] = true
+			}
+		}
+	}
+
+	return false
+}
+
+// toMap, convert documents from json or lxHelper.M to map
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case lxHelper.M:
+		return m, true
+	case bson.M:
+		return m, true
+	}
+
+	return nil, false
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case float64, float32, int, int32, int64:
+		return true
+	}
+
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedKeys, keys in stable order for reproducible violations
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
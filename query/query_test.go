@@ -0,0 +1,129 @@
+package lxQuery_test
+
+import (
+	"encoding/json"
+	"github.com/globalsign/mgo/bson"
+	"github.com/litixsoft/lx-golib/helper"
+	"github.com/litixsoft/lx-golib/query"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newPolicy() *lxQuery.Policy {
+	return lxQuery.NewPolicy().
+		Allow("name", "$eq", "$regex", "$in").
+		Allow("age", "$gt", "$lt").
+		Allow("is_active", "$eq", "$exists")
+}
+
+func parse(t *testing.T, s string) lxHelper.M {
+	var m lxHelper.M
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestPolicy_Sanitize(t *testing.T) {
+	t.Run("Convert allowed query to bson filter", func(t *testing.T) {
+		q := parse(t, `{"$or":[{"name":{"$regex":"^ot","$options":"i"}},{"age":{"$gt":18,"$lt":65}}],"is_active":true}`)
+
+		filter, err := newPolicy().Sanitize(q)
+		assert.NoError(t, err)
+		assert.Equal(t, bson.M{
+			"$or": []interface{}{
+				bson.M{"name": bson.M{"$regex": bson.RegEx{Pattern: "^ot", Options: "i"}}},
+				bson.M{"age": bson.M{"$gt": float64(18), "$lt": float64(65)}},
+			},
+			"is_active": true,
+		}, filter)
+	})
+
+	t.Run("Accept ObjectId and date values from query string parser", func(t *testing.T) {
+		id := bson.NewObjectId()
+		now := time.Now()
+		p := lxQuery.NewPolicy().Allow("_id", "$in").Allow("created", "$gt")
+
+		filter, err := p.Sanitize(lxHelper.M{"_id": lxHelper.M{"$in": []interface{}{id}}, "created": lxHelper.M{"$gt": now}})
+		assert.NoError(t, err)
+		assert.Equal(t, bson.M{"_id": bson.M{"$in": []interface{}{id}}, "created": bson.M{"$gt": now}}, filter)
+	})
+
+	t.Run("Return empty filter for nil query", func(t *testing.T) {
+		filter, err := newPolicy().Sanitize(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, bson.M{}, filter)
+	})
+
+	t.Run("Reject dangerous operators and unknown fields", func(t *testing.T) {
+		q := parse(t, `{"$where":"sleep(1000)","password":"x","name":{"$function":{}},"age":{"$expr":1}}`)
+
+		_, err := newPolicy().Sanitize(q)
+		if assert.IsType(t, &lxQuery.Error{}, err) {
+			assert.Equal(t, []lxQuery.Violation{
+				{Path: "$where", Reason: "operator is not allowed"},
+				{Path: "age.$expr", Reason: "operator is not allowed"},
+				{Path: "name.$function", Reason: "operator is not allowed"},
+				{Path: "password", Reason: "field is not allowed"},
+			}, err.(*lxQuery.Error).Violations)
+		}
+	})
+
+	t.Run("Reject operator not allowed for field", func(t *testing.T) {
+		_, err := newPolicy().Sanitize(parse(t, `{"age":30}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Reject embedded documents and array values", func(t *testing.T) {
+		_, err := newPolicy().Sanitize(parse(t, `{"name":{"first":"otto"}}`))
+		assert.Error(t, err)
+
+		_, err = newPolicy().Sanitize(parse(t, `{"name":["otto"]}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Reject too deep nesting", func(t *testing.T) {
+		p := newPolicy()
+		p.MaxDepth = 1
+
+		_, err := p.Sanitize(parse(t, `{"$or":[{"name":"a"}]}`))
+		assert.NoError(t, err)
+
+		_, err = p.Sanitize(parse(t, `{"$or":[{"$and":[{"name":"a"}]}]}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Reject complex regex", func(t *testing.T) {
+		for _, pattern := range []string{`(a+)+$`, `((a+))+`, `(?:(a+)b)*`, `(a{2,})*`, `(x|(y*)z){3,}`} {
+			_, err := newPolicy().Sanitize(lxHelper.M{"name": lxHelper.M{"$regex": pattern}})
+			assert.Error(t, err, pattern)
+		}
+
+		// Quantifiers outside of repeated groups and escaped or bracketed ones are fine
+		for _, pattern := range []string{`a+(b)+`, `(ab)+c*`, `(a\+)+`, `([+*])+`, `(a+)b`} {
+			_, err := newPolicy().Sanitize(lxHelper.M{"name": lxHelper.M{"$regex": pattern}})
+			assert.NoError(t, err, pattern)
+		}
+
+		_, err := newPolicy().Sanitize(parse(t, `{"name":{"$regex":"abc","$options":"e"}}`))
+		assert.Error(t, err)
+
+		p := newPolicy()
+		p.MaxRegexLength = 3
+		_, err = p.Sanitize(parse(t, `{"name":{"$regex":"abcd"}}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Reject too long $in list", func(t *testing.T) {
+		p := newPolicy()
+		p.MaxInLength = 2
+
+		_, err := p.Sanitize(parse(t, `{"name":{"$in":["a","b","c"]}}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Panic on unknown operator in policy", func(t *testing.T) {
+		assert.Panics(t, func() { lxQuery.NewPolicy().Allow("name", "$where") })
+	})
+}
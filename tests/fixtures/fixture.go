@@ -3,6 +3,8 @@ package fixtures
 import (
 	"os"
 	"log"
+	"testing"
+	"time"
 	"github.com/globalsign/mgo"
 )
 
@@ -12,8 +14,11 @@ const (
 
 var DbHost string
 
-// getConn, get a new db connection
-func GetMongoConn() *mgo.Session {
+// mongoErr, dial error of an unreachable mongod, later tests skip without waiting
+var mongoErr error
+
+// GetMongoConn, get a new db connection, skips the test when mongod is not reachable
+func GetMongoConn(t testing.TB) *mgo.Session {
 
 	// Check DbHost environment
 	dbHost := os.Getenv("DBHOST")
@@ -26,12 +31,17 @@ func GetMongoConn() *mgo.Session {
 	// Set dbHost
 	DbHost = dbHost
 
+	if mongoErr != nil {
+		t.Skipf("mongoDb %s not reachable: %v", dbHost, mongoErr)
+	}
+
 	log.Println("DBHOST:", dbHost)
 
 	// Create new connection
-	conn, err := mgo.Dial(dbHost)
+	conn, err := mgo.DialWithTimeout(dbHost, 2*time.Second)
 	if err != nil {
-		log.Fatal(err)
+		mongoErr = err
+		t.Skipf("mongoDb %s not reachable: %v", dbHost, err)
 	}
 	conn.SetMode(mgo.Monotonic, true)
 
@@ -5,9 +5,12 @@
 package lxGoLibMocks
 
 import (
+	context "context"
+	mgo "github.com/globalsign/mgo"
 	gomock "github.com/golang/mock/gomock"
 	db "github.com/litixsoft/lx-golib/db"
 	reflect "reflect"
+	time "time"
 )
 
 // MockIBaseDb is a mock of IBaseDb interface
@@ -45,6 +48,42 @@ func (mr *MockIBaseDbMockRecorder) Create(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockIBaseDb)(nil).Create), arg0)
 }
 
+// CreateAll mocks base method
+func (m *MockIBaseDb) CreateAll(arg0 []interface{}) error {
+	ret := m.ctrl.Call(m, "CreateAll", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAll indicates an expected call of CreateAll
+func (mr *MockIBaseDbMockRecorder) CreateAll(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAll", reflect.TypeOf((*MockIBaseDb)(nil).CreateAll), arg0)
+}
+
+// CreateAllContext mocks base method
+func (m *MockIBaseDb) CreateAllContext(arg0 context.Context, arg1 []interface{}) error {
+	ret := m.ctrl.Call(m, "CreateAllContext", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAllContext indicates an expected call of CreateAllContext
+func (mr *MockIBaseDbMockRecorder) CreateAllContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAllContext", reflect.TypeOf((*MockIBaseDb)(nil).CreateAllContext), arg0, arg1)
+}
+
+// CreateContext mocks base method
+func (m *MockIBaseDb) CreateContext(arg0 context.Context, arg1 interface{}) error {
+	ret := m.ctrl.Call(m, "CreateContext", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateContext indicates an expected call of CreateContext
+func (mr *MockIBaseDbMockRecorder) CreateContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateContext", reflect.TypeOf((*MockIBaseDb)(nil).CreateContext), arg0, arg1)
+}
+
 // Delete mocks base method
 func (m *MockIBaseDb) Delete(arg0 interface{}) error {
 	ret := m.ctrl.Call(m, "Delete", arg0)
@@ -70,6 +109,31 @@ func (mr *MockIBaseDbMockRecorder) DeleteAll(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAll", reflect.TypeOf((*MockIBaseDb)(nil).DeleteAll), arg0)
 }
 
+// DeleteAllContext mocks base method
+func (m *MockIBaseDb) DeleteAllContext(arg0 context.Context, arg1 interface{}) (db.ChangeInfo, error) {
+	ret := m.ctrl.Call(m, "DeleteAllContext", arg0, arg1)
+	ret0, _ := ret[0].(db.ChangeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteAllContext indicates an expected call of DeleteAllContext
+func (mr *MockIBaseDbMockRecorder) DeleteAllContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAllContext", reflect.TypeOf((*MockIBaseDb)(nil).DeleteAllContext), arg0, arg1)
+}
+
+// DeleteContext mocks base method
+func (m *MockIBaseDb) DeleteContext(arg0 context.Context, arg1 interface{}) error {
+	ret := m.ctrl.Call(m, "DeleteContext", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteContext indicates an expected call of DeleteContext
+func (mr *MockIBaseDbMockRecorder) DeleteContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteContext", reflect.TypeOf((*MockIBaseDb)(nil).DeleteContext), arg0, arg1)
+}
+
 // GetAll mocks base method
 func (m *MockIBaseDb) GetAll(arg0, arg1 interface{}, arg2 *db.Options) (int, error) {
 	ret := m.ctrl.Call(m, "GetAll", arg0, arg1, arg2)
@@ -83,6 +147,19 @@ func (mr *MockIBaseDbMockRecorder) GetAll(arg0, arg1, arg2 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockIBaseDb)(nil).GetAll), arg0, arg1, arg2)
 }
 
+// GetAllContext mocks base method
+func (m *MockIBaseDb) GetAllContext(arg0 context.Context, arg1, arg2 interface{}, arg3 *db.Options) (int, error) {
+	ret := m.ctrl.Call(m, "GetAllContext", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllContext indicates an expected call of GetAllContext
+func (mr *MockIBaseDbMockRecorder) GetAllContext(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllContext", reflect.TypeOf((*MockIBaseDb)(nil).GetAllContext), arg0, arg1, arg2, arg3)
+}
+
 // GetCount mocks base method
 func (m *MockIBaseDb) GetCount(arg0 interface{}) (int, error) {
 	ret := m.ctrl.Call(m, "GetCount", arg0)
@@ -96,6 +173,19 @@ func (mr *MockIBaseDbMockRecorder) GetCount(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCount", reflect.TypeOf((*MockIBaseDb)(nil).GetCount), arg0)
 }
 
+// GetCountContext mocks base method
+func (m *MockIBaseDb) GetCountContext(arg0 context.Context, arg1 interface{}) (int, error) {
+	ret := m.ctrl.Call(m, "GetCountContext", arg0, arg1)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCountContext indicates an expected call of GetCountContext
+func (mr *MockIBaseDbMockRecorder) GetCountContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCountContext", reflect.TypeOf((*MockIBaseDb)(nil).GetCountContext), arg0, arg1)
+}
+
 // GetOne mocks base method
 func (m *MockIBaseDb) GetOne(arg0, arg1 interface{}) error {
 	ret := m.ctrl.Call(m, "GetOne", arg0, arg1)
@@ -108,8 +198,98 @@ func (mr *MockIBaseDbMockRecorder) GetOne(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOne", reflect.TypeOf((*MockIBaseDb)(nil).GetOne), arg0, arg1)
 }
 
+// GetOneContext mocks base method
+func (m *MockIBaseDb) GetOneContext(arg0 context.Context, arg1, arg2 interface{}) error {
+	ret := m.ctrl.Call(m, "GetOneContext", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GetOneContext indicates an expected call of GetOneContext
+func (mr *MockIBaseDbMockRecorder) GetOneContext(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOneContext", reflect.TypeOf((*MockIBaseDb)(nil).GetOneContext), arg0, arg1, arg2)
+}
+
+// GetPage mocks base method
+func (m *MockIBaseDb) GetPage(arg0, arg1 interface{}, arg2 *db.Options) (*db.Page, error) {
+	ret := m.ctrl.Call(m, "GetPage", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*db.Page)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPage indicates an expected call of GetPage
+func (mr *MockIBaseDbMockRecorder) GetPage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPage", reflect.TypeOf((*MockIBaseDb)(nil).GetPage), arg0, arg1, arg2)
+}
+
+// GetPageContext mocks base method
+func (m *MockIBaseDb) GetPageContext(arg0 context.Context, arg1, arg2 interface{}, arg3 *db.Options) (*db.Page, error) {
+	ret := m.ctrl.Call(m, "GetPageContext", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*db.Page)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPageContext indicates an expected call of GetPageContext
+func (mr *MockIBaseDbMockRecorder) GetPageContext(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPageContext", reflect.TypeOf((*MockIBaseDb)(nil).GetPageContext), arg0, arg1, arg2, arg3)
+}
+
+// Purge mocks base method
+func (m *MockIBaseDb) Purge(arg0 time.Duration) (db.ChangeInfo, error) {
+	ret := m.ctrl.Call(m, "Purge", arg0)
+	ret0, _ := ret[0].(db.ChangeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Purge indicates an expected call of Purge
+func (mr *MockIBaseDbMockRecorder) Purge(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Purge", reflect.TypeOf((*MockIBaseDb)(nil).Purge), arg0)
+}
+
+// PurgeContext mocks base method
+func (m *MockIBaseDb) PurgeContext(arg0 context.Context, arg1 time.Duration) (db.ChangeInfo, error) {
+	ret := m.ctrl.Call(m, "PurgeContext", arg0, arg1)
+	ret0, _ := ret[0].(db.ChangeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeContext indicates an expected call of PurgeContext
+func (mr *MockIBaseDbMockRecorder) PurgeContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeContext", reflect.TypeOf((*MockIBaseDb)(nil).PurgeContext), arg0, arg1)
+}
+
+// Restore mocks base method
+func (m *MockIBaseDb) Restore(arg0 interface{}) (db.ChangeInfo, error) {
+	ret := m.ctrl.Call(m, "Restore", arg0)
+	ret0, _ := ret[0].(db.ChangeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Restore indicates an expected call of Restore
+func (mr *MockIBaseDbMockRecorder) Restore(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockIBaseDb)(nil).Restore), arg0)
+}
+
+// RestoreContext mocks base method
+func (m *MockIBaseDb) RestoreContext(arg0 context.Context, arg1 interface{}) (db.ChangeInfo, error) {
+	ret := m.ctrl.Call(m, "RestoreContext", arg0, arg1)
+	ret0, _ := ret[0].(db.ChangeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreContext indicates an expected call of RestoreContext
+func (mr *MockIBaseDbMockRecorder) RestoreContext(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreContext", reflect.TypeOf((*MockIBaseDb)(nil).RestoreContext), arg0, arg1)
+}
+
 // Setup mocks base method
-func (m *MockIBaseDb) Setup(arg0 interface{}) error {
+func (m *MockIBaseDb) Setup(arg0 []mgo.Index) error {
 	ret := m.ctrl.Call(m, "Setup", arg0)
 	ret0, _ := ret[0].(error)
 	return ret0
@@ -144,3 +324,28 @@ func (m *MockIBaseDb) UpdateAll(arg0, arg1 interface{}) (db.ChangeInfo, error) {
 func (mr *MockIBaseDbMockRecorder) UpdateAll(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAll", reflect.TypeOf((*MockIBaseDb)(nil).UpdateAll), arg0, arg1)
 }
+
+// UpdateAllContext mocks base method
+func (m *MockIBaseDb) UpdateAllContext(arg0 context.Context, arg1, arg2 interface{}) (db.ChangeInfo, error) {
+	ret := m.ctrl.Call(m, "UpdateAllContext", arg0, arg1, arg2)
+	ret0, _ := ret[0].(db.ChangeInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateAllContext indicates an expected call of UpdateAllContext
+func (mr *MockIBaseDbMockRecorder) UpdateAllContext(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAllContext", reflect.TypeOf((*MockIBaseDb)(nil).UpdateAllContext), arg0, arg1, arg2)
+}
+
+// UpdateContext mocks base method
+func (m *MockIBaseDb) UpdateContext(arg0 context.Context, arg1, arg2 interface{}) error {
+	ret := m.ctrl.Call(m, "UpdateContext", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateContext indicates an expected call of UpdateContext
+func (mr *MockIBaseDbMockRecorder) UpdateContext(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateContext", reflect.TypeOf((*MockIBaseDb)(nil).UpdateContext), arg0, arg1, arg2)
+}